@@ -0,0 +1,697 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf16"
+)
+
+// chapters of the ID3v2 CHAP/CTOC frames, of the Nero chpl box and of the
+// QuickTime chapter tracks
+
+const (
+	id3FrameHeaderSize = 10
+
+	chplTimescale  = 10000000
+	maxChapters    = 1 << 12
+	maxMp4BoxBytes = 16 << 20
+)
+
+type Chapter struct {
+	Title string
+	Start time.Duration
+	// 0 when unknown, the start of the next chapter is then used
+	End time.Duration
+}
+
+type id3SubFrame struct {
+	id    string
+	flags []byte
+	body  []byte
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func putSynchsafe(b []byte, v int) {
+	b[0] = byte(v>>21) & 0x7F
+	b[1] = byte(v>>14) & 0x7F
+	b[2] = byte(v>>7) & 0x7F
+	b[3] = byte(v) & 0x7F
+}
+
+// frames embedded in CHAP and CTOC frames, sizes are synchsafe in ID3v2.4 only
+func parseSubFrames(b []byte, version byte) ([]id3SubFrame, error) {
+	var res = make([]id3SubFrame, 0)
+	for len(b) >= id3FrameHeaderSize && b[0] != 0 {
+		size := int(binary.BigEndian.Uint32(b[4:8]))
+		if version == 4 {
+			size = synchsafe(b[4:8])
+		}
+
+		if size > len(b)-id3FrameHeaderSize {
+			return nil, errors.New("invalid embedded frame size")
+		}
+
+		res = append(res, id3SubFrame{
+			id:    string(b[0:4]),
+			flags: b[8:10],
+			body:  b[id3FrameHeaderSize : id3FrameHeaderSize+size],
+		})
+		b = b[id3FrameHeaderSize+size:]
+	}
+
+	return res, nil
+}
+
+func writeSubFrames(frames []id3SubFrame, version byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, id3FrameHeaderSize)
+	for _, f := range frames {
+		copy(header[0:4], f.id)
+		if version == 4 {
+			putSynchsafe(header[4:8], len(f.body))
+		} else {
+			binary.BigEndian.PutUint32(header[4:8], uint32(len(f.body)))
+		}
+		// flags have different meanings between versions
+		header[8] = 0
+		header[9] = 0
+
+		buf.Write(header)
+		buf.Write(f.body)
+	}
+
+	return buf.Bytes()
+}
+
+// split a null terminated latin-1 string from the rest
+func splitTerminated(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, errors.New("unterminated string")
+	}
+
+	return latin1(b[:i]), b[i+1:], nil
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func decodeUtf16(b []byte, order binary.ByteOrder) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		v := order.Uint16(b[i:])
+		if v == 0 {
+			break
+		}
+		u = append(u, v)
+	}
+	return string(utf16.Decode(u))
+}
+
+// body of a text frame: encoding byte then the text
+func decodeId3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	text := b[1:]
+	switch b[0] {
+	case 1:
+		if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			return decodeUtf16(text[2:], binary.LittleEndian)
+		}
+		if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			return decodeUtf16(text[2:], binary.BigEndian)
+		}
+		return decodeUtf16(text, binary.LittleEndian)
+	case 2:
+		return decodeUtf16(text, binary.BigEndian)
+	case 3:
+		return string(bytes.TrimRight(text, "\x00"))
+	default:
+		return latin1(bytes.TrimRight(text, "\x00"))
+	}
+}
+
+// rewrite the embedded frames of a CHAP or CTOC frame body for another version
+func ConvertChapterFrame(id string, body []byte, from byte, to byte) ([]byte, error) {
+	if from == to || (from != 4 && to != 4) {
+		return body, nil
+	}
+
+	var header []byte
+	switch id {
+	case "CHAP":
+		_, rest, err := splitTerminated(body)
+		if err != nil || len(rest) < 16 {
+			return nil, errors.New("invalid CHAP frame")
+		}
+		header = body[:len(body)-len(rest)+16]
+	case "CTOC":
+		_, rest, err := splitTerminated(body)
+		if err != nil || len(rest) < 2 {
+			return nil, errors.New("invalid CTOC frame")
+		}
+		count := int(rest[1])
+		rest = rest[2:]
+		for i := 0; i < count; i++ {
+			if _, rest, err = splitTerminated(rest); err != nil {
+				return nil, errors.New("invalid CTOC frame")
+			}
+		}
+		header = body[:len(body)-len(rest)]
+	default:
+		return body, nil
+	}
+
+	frames, err := parseSubFrames(body[len(header):], from)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte(nil), header...), writeSubFrames(frames, to)...), nil
+}
+
+// element id and chapter of a CHAP frame body
+func ParseId3Chapter(body []byte, version byte) (string, Chapter, error) {
+	var c Chapter
+
+	id, rest, err := splitTerminated(body)
+	if err != nil || len(rest) < 16 {
+		return "", c, errors.New("invalid CHAP frame")
+	}
+
+	c.Start = time.Duration(binary.BigEndian.Uint32(rest[0:4])) * time.Millisecond
+	c.End = time.Duration(binary.BigEndian.Uint32(rest[4:8])) * time.Millisecond
+
+	frames, err := parseSubFrames(rest[16:], version)
+	if err != nil {
+		return "", c, err
+	}
+	for _, f := range frames {
+		if f.id == "TIT2" {
+			c.Title = decodeId3Text(f.body)
+		}
+	}
+
+	if c.Title == "" {
+		c.Title = id
+	}
+
+	return id, c, nil
+}
+
+// child element ids of a CTOC frame body, and whether it is the top level one
+func ParseId3TableOfContents(body []byte) ([]string, bool, error) {
+	_, rest, err := splitTerminated(body)
+	if err != nil || len(rest) < 2 {
+		return nil, false, errors.New("invalid CTOC frame")
+	}
+
+	topLevel := rest[0]&0x02 != 0
+	count := int(rest[1])
+	rest = rest[2:]
+
+	var children = make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		var child string
+		if child, rest, err = splitTerminated(rest); err != nil {
+			return nil, false, errors.New("invalid CTOC frame")
+		}
+		children = append(children, child)
+	}
+
+	return children, topLevel, nil
+}
+
+// body of a frame as stored, false if it is compressed, encrypted or
+// unsynchronised
+func plainId3FrameBody(f id3SubFrame, version byte) ([]byte, bool) {
+	body := f.body
+	if version == 4 {
+		if f.flags[1]&0x0E != 0 {
+			return nil, false
+		}
+		// data length indicator
+		if f.flags[1]&0x01 != 0 {
+			if len(body) < 4 {
+				return nil, false
+			}
+			body = body[4:]
+		}
+		return body, true
+	}
+
+	if f.flags[1]&0xC0 != 0 {
+		return nil, false
+	}
+	// group identifier
+	if f.flags[1]&0x20 != 0 {
+		if len(body) < 1 {
+			return nil, false
+		}
+		body = body[1:]
+	}
+	return body, true
+}
+
+// chapters of the CHAP frames of the tag at the beginning of the data, in the
+// order of the top level CTOC frame if any
+// the frames are read as stored, whatever the ID3 library parses them into
+func ReadId3Chapters(r io.ReaderAt) ([]Chapter, error) {
+	// the largest size a tag can declare, the file being checked on upload
+	tag, version, err := readId3Tag(r, 1<<28+2*id3HeaderSize)
+	if err != nil || tag == nil {
+		return nil, err
+	}
+
+	var chapters = make(map[string]Chapter)
+	var ids = make([]string, 0)
+	var toc []string
+	err = walkId3Frames(tag, version, func(f id3SubFrame) error {
+		if f.id != "CHAP" && f.id != "CTOC" {
+			return nil
+		}
+
+		body, ok := plainId3FrameBody(f, version)
+		if !ok {
+			return nil
+		}
+
+		if f.id == "CHAP" {
+			id, c, err := ParseId3Chapter(body, version)
+			if err != nil {
+				// the other chapters are still usable
+				return nil
+			}
+			if _, ok := chapters[id]; !ok {
+				ids = append(ids, id)
+			}
+			chapters[id] = c
+			return nil
+		}
+
+		// the table of contents only gives the order, which is the one of the
+		// start times in practice
+		children, topLevel, err := ParseId3TableOfContents(body)
+		if err == nil && topLevel && toc == nil {
+			toc = children
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if toc != nil {
+		ids = toc
+	}
+
+	var res = make([]Chapter, 0, len(ids))
+	for _, id := range ids {
+		if c, ok := chapters[id]; ok {
+			res = append(res, c)
+		}
+	}
+
+	return res, nil
+}
+
+// sort by start, and fill the missing ends with the next start
+func NormalizeChapters(l []Chapter, duration time.Duration) []Chapter {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Start < l[j].Start
+	})
+
+	for i := range l {
+		if l[i].End > l[i].Start {
+			continue
+		}
+
+		if i+1 < len(l) {
+			l[i].End = l[i+1].Start
+		} else {
+			l[i].End = duration
+		}
+	}
+
+	return l
+}
+
+// chapters of a mp4 file, from the chpl box or from the chapter track
+func ReadMp4Chapters(path string) ([]Chapter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	infos, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := readMp4ChapterTrack(f, infos.Size())
+	if err != nil || len(l) > 0 {
+		return l, err
+	}
+
+	return readMp4Chpl(f, infos.Size())
+}
+
+// Nero chapters: version, flags, (reserved), count, then start in 100ns
+// units and title for each
+func readMp4Chpl(r io.ReaderAt, size int64) ([]Chapter, error) {
+	chpl, ok, err := findMp4Path(r, size, "moov", "udta", "chpl")
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	b, err := readMp4Payload(r, chpl, maxMp4BoxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < mp4FullBoxHeaderSize {
+		return nil, errors.New("invalid chpl box")
+	}
+	pos := mp4FullBoxHeaderSize
+	if b[0] != 0 {
+		pos += 4
+	}
+	if pos >= len(b) {
+		return nil, errors.New("invalid chpl box")
+	}
+
+	count := int(b[pos])
+	pos++
+
+	var res = make([]Chapter, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+9 > len(b) {
+			return nil, errors.New("truncated chpl box")
+		}
+
+		start := binary.BigEndian.Uint64(b[pos:])
+		length := int(b[pos+8])
+		pos += 9
+		if pos+length > len(b) {
+			return nil, errors.New("truncated chpl box")
+		}
+
+		res = append(res, Chapter{
+			Title: string(b[pos : pos+length]),
+			Start: time.Duration(start) * time.Second / chplTimescale,
+		})
+		pos += length
+	}
+
+	return res, nil
+}
+
+type mp4Track struct {
+	id         uint32
+	box        mp4Box
+	chapterIds []uint32
+}
+
+func listMp4Tracks(r io.ReaderAt, size int64) ([]mp4Track, error) {
+	moov, ok, err := findMp4Path(r, size, "moov")
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	boxes, err := readMp4Boxes(r, moov.Offset, moov.End())
+	if err != nil {
+		return nil, err
+	}
+
+	var res = make([]mp4Track, 0)
+	for _, b := range boxes {
+		if b.Type != "trak" {
+			continue
+		}
+
+		t := mp4Track{
+			box: b,
+		}
+
+		tkhd, ok, err := findMp4Box(r, b, "tkhd")
+		if err != nil || !ok {
+			return nil, errors.New("track header not found")
+		}
+		header, err := readMp4Payload(r, tkhd, 256)
+		if err != nil {
+			return nil, err
+		}
+		// version 1 uses 64 bits dates
+		idOffset := 12
+		if len(header) > 0 && header[0] == 1 {
+			idOffset = 20
+		}
+		if t.id, ok = readUint32(header, idOffset); !ok {
+			return nil, errors.New("invalid track header")
+		}
+
+		if tref, ok, err := findMp4Box(r, b, "tref"); err == nil && ok {
+			if chap, ok, err := findMp4Box(r, tref, "chap"); err == nil && ok {
+				ids, err := readMp4Payload(r, chap, 4*maxChapters)
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i+4 <= len(ids); i += 4 {
+					t.chapterIds = append(t.chapterIds, binary.BigEndian.Uint32(ids[i:]))
+				}
+			}
+		}
+
+		res = append(res, t)
+	}
+
+	return res, nil
+}
+
+// read a sample table box made of a version, a count, and entries
+func readMp4Table(r io.ReaderAt, parent mp4Box, boxType string, entrySize int) ([]byte, int, error) {
+	b, ok, err := findMp4Box(r, parent, boxType)
+	if err != nil || !ok {
+		return nil, 0, err
+	}
+
+	payload, err := readMp4Payload(r, b, maxMp4BoxBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := mp4FullBoxHeaderSize
+	count, ok := readUint32(payload, start)
+	if !ok {
+		return nil, 0, errors.New("invalid " + boxType + " box")
+	}
+	start += 4
+
+	entries := payload[start:]
+	if len(entries) < int(count)*entrySize {
+		return nil, 0, errors.New("truncated " + boxType + " box")
+	}
+
+	return entries, int(count), nil
+}
+
+// QuickTime chapters: a text track referenced by the audio track, each
+// sample being a title with its duration
+func readMp4ChapterTrack(r io.ReaderAt, size int64) ([]Chapter, error) {
+	tracks, err := listMp4Tracks(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var chapterId uint32
+	for _, t := range tracks {
+		if len(t.chapterIds) > 0 {
+			chapterId = t.chapterIds[0]
+			break
+		}
+	}
+	if chapterId == 0 {
+		return nil, nil
+	}
+
+	for _, t := range tracks {
+		if t.id == chapterId {
+			return readTextTrack(r, t.box)
+		}
+	}
+
+	return nil, errors.New("chapter track not found")
+}
+
+func readTextTrack(r io.ReaderAt, trak mp4Box) ([]Chapter, error) {
+	mdia, ok, err := findMp4Box(r, trak, "mdia")
+	if err != nil || !ok {
+		return nil, errors.New("chapter track without media")
+	}
+
+	mdhd, ok, err := findMp4Box(r, mdia, "mdhd")
+	if err != nil || !ok {
+		return nil, errors.New("chapter track without media header")
+	}
+	header, err := readMp4Payload(r, mdhd, 64)
+	if err != nil {
+		return nil, err
+	}
+	scaleOffset := 12
+	if len(header) > 0 && header[0] == 1 {
+		scaleOffset = 20
+	}
+	timescale, ok := readUint32(header, scaleOffset)
+	if !ok || timescale == 0 {
+		return nil, errors.New("invalid chapter track timescale")
+	}
+
+	minf, ok, err := findMp4Box(r, mdia, "minf")
+	if err != nil || !ok {
+		return nil, errors.New("chapter track without media information")
+	}
+	stbl, ok, err := findMp4Box(r, minf, "stbl")
+	if err != nil || !ok {
+		return nil, errors.New("chapter track without sample table")
+	}
+
+	// sample durations
+	stts, sttsCount, err := readMp4Table(r, stbl, "stts", 8)
+	if err != nil {
+		return nil, err
+	}
+	var durations = make([]uint32, 0)
+	for i := 0; i < sttsCount; i++ {
+		count := binary.BigEndian.Uint32(stts[8*i:])
+		delta := binary.BigEndian.Uint32(stts[8*i+4:])
+		for j := uint32(0); j < count && len(durations) < maxChapters; j++ {
+			durations = append(durations, delta)
+		}
+	}
+
+	// sample sizes, the default size is before the count
+	stszBox, ok, err := findMp4Box(r, stbl, "stsz")
+	if err != nil || !ok {
+		return nil, errors.New("chapter track without sample sizes")
+	}
+	stsz, err := readMp4Payload(r, stszBox, maxMp4BoxBytes)
+	if err != nil {
+		return nil, err
+	}
+	defaultSize, ok1 := readUint32(stsz, mp4FullBoxHeaderSize)
+	sampleCount, ok2 := readUint32(stsz, mp4FullBoxHeaderSize+4)
+	if !ok1 || !ok2 || sampleCount > maxChapters {
+		return nil, errors.New("invalid stsz box")
+	}
+	var sizes = make([]uint32, sampleCount)
+	for i := range sizes {
+		sizes[i] = defaultSize
+		if defaultSize == 0 {
+			if sizes[i], ok = readUint32(stsz, mp4FullBoxHeaderSize+8+4*i); !ok {
+				return nil, errors.New("truncated stsz box")
+			}
+		}
+	}
+
+	// chunk offsets, 32 or 64 bits
+	var offsets = make([]int64, 0)
+	if stco, count, err := readMp4Table(r, stbl, "stco", 4); err != nil {
+		return nil, err
+	} else if stco != nil {
+		for i := 0; i < count; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(stco[4*i:])))
+		}
+	} else if co64, count, err := readMp4Table(r, stbl, "co64", 8); err != nil {
+		return nil, err
+	} else {
+		for i := 0; i < count; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(co64[8*i:])))
+		}
+	}
+
+	// samples per chunk
+	stsc, stscCount, err := readMp4Table(r, stbl, "stsc", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	var res = make([]Chapter, 0)
+	var sample int
+	var elapsed uint64
+	for chunk := range offsets {
+		perChunk := uint32(1)
+		for i := 0; i < stscCount; i++ {
+			if binary.BigEndian.Uint32(stsc[12*i:]) <= uint32(chunk+1) {
+				perChunk = binary.BigEndian.Uint32(stsc[12*i+4:])
+			}
+		}
+
+		offset := offsets[chunk]
+		for i := uint32(0); i < perChunk && sample < len(sizes); i++ {
+			title, err := readTextSample(r, offset, sizes[sample])
+			if err != nil {
+				return nil, err
+			}
+
+			var duration uint64
+			if sample < len(durations) {
+				duration = uint64(durations[sample])
+			}
+
+			res = append(res, Chapter{
+				Title: title,
+				Start: time.Duration(elapsed * uint64(time.Second) / uint64(timescale)),
+				End:   time.Duration((elapsed + duration) * uint64(time.Second) / uint64(timescale)),
+			})
+
+			elapsed += duration
+			offset += int64(sizes[sample])
+			sample++
+		}
+	}
+
+	return res, nil
+}
+
+// a 16 bits length then the text, UTF-8 or UTF-16 with a BOM
+func readTextSample(r io.ReaderAt, offset int64, size uint32) (string, error) {
+	if size < 2 || size > 1<<16 {
+		return "", errors.New("invalid text sample")
+	}
+
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return "", err
+	}
+
+	length := int(binary.BigEndian.Uint16(b))
+	if length > len(b)-2 {
+		return "", errors.New("invalid text sample")
+	}
+
+	text := b[2 : 2+length]
+	if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+		return decodeUtf16(text[2:], binary.BigEndian), nil
+	}
+	if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+		return decodeUtf16(text[2:], binary.LittleEndian), nil
+	}
+
+	return string(text), nil
+}
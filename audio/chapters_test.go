@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// a tag made of the given frames, with some padding
+func id3Fixture(version byte, frames []id3SubFrame) []byte {
+	body := append(writeSubFrames(frames, version), make([]byte, 16)...)
+
+	header := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:10], len(body))
+
+	return append(header, body...)
+}
+
+func chapFrame(version byte, id string, start time.Duration, end time.Duration, title string) id3SubFrame {
+	body := append([]byte(id), 0)
+	times := make([]byte, 16)
+	binary.BigEndian.PutUint32(times[0:], uint32(start.Milliseconds()))
+	binary.BigEndian.PutUint32(times[4:], uint32(end.Milliseconds()))
+	// no byte offsets
+	binary.BigEndian.PutUint32(times[8:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(times[12:], 0xFFFFFFFF)
+	body = append(body, times...)
+
+	if title != "" {
+		body = append(body, writeSubFrames([]id3SubFrame{{
+			id:   "TIT2",
+			body: append([]byte{3}, title...),
+		}}, version)...)
+	}
+
+	return id3SubFrame{
+		id:   "CHAP",
+		body: body,
+	}
+}
+
+func ctocFrame(id string, topLevel bool, children ...string) id3SubFrame {
+	body := append([]byte(id), 0)
+	var flags byte = 0x01
+	if topLevel {
+		flags |= 0x02
+	}
+	body = append(body, flags, byte(len(children)))
+	for _, c := range children {
+		body = append(append(body, c...), 0)
+	}
+
+	return id3SubFrame{
+		id:   "CTOC",
+		body: body,
+	}
+}
+
+func TestReadId3Chapters(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		data := id3Fixture(version, []id3SubFrame{
+			{id: "TIT2", body: []byte("\x03Episode")},
+			chapFrame(version, "ch1", 0, 90*time.Second, "Intro"),
+			chapFrame(version, "ch3", 300*time.Second, 0, ""),
+			// a nested table of contents does not give the order
+			ctocFrame("sub", false, "ch3", "ch1"),
+			chapFrame(version, "ch2", 90*time.Second, 300*time.Second, "Interview"),
+			ctocFrame("toc", true, "ch1", "ch2", "ch3", "missing"),
+		})
+
+		l, err := ReadId3Chapters(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("v2.%d: %s", version, err)
+		}
+
+		want := []Chapter{
+			{Title: "Intro", Start: 0, End: 90 * time.Second},
+			{Title: "Interview", Start: 90 * time.Second, End: 300 * time.Second},
+			{Title: "ch3", Start: 300 * time.Second},
+		}
+		if len(l) != len(want) {
+			t.Fatalf("v2.%d: %d chapters, expected %d", version, len(l), len(want))
+		}
+		for i := range want {
+			if l[i] != want[i] {
+				t.Errorf("v2.%d: chapter %d is %+v, expected %+v", version, i, l[i], want[i])
+			}
+		}
+	}
+}
+
+func TestReadId3ChaptersWithoutTableOfContents(t *testing.T) {
+	data := id3Fixture(4, []id3SubFrame{
+		chapFrame(4, "b", 60*time.Second, 0, "Second"),
+		chapFrame(4, "a", 0, 0, "First"),
+	})
+
+	l, err := ReadId3Chapters(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l = NormalizeChapters(l, 120*time.Second)
+	if len(l) != 2 || l[0].Title != "First" || l[0].End != 60*time.Second ||
+		l[1].Title != "Second" || l[1].End != 120*time.Second {
+		t.Errorf("unexpected chapters %+v", l)
+	}
+}
+
+func TestReadId3ChaptersWithoutTag(t *testing.T) {
+	l, err := ReadId3Chapters(bytes.NewReader([]byte{0xFF, 0xFB, 0x90, 0x00}))
+	if err != nil || len(l) != 0 {
+		t.Errorf("got %v, %v", l, err)
+	}
+}
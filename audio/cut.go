@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// copy the frames of a mp3 file between start and end (0 for the end of the
+// file), cut on the frame boundaries closest to the offsets
+// the ID3 tag and the info frame of the source are not copied, as they
+// describe the whole file
+func CutMp3(path string, start time.Duration, end time.Duration, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return err
+	}
+
+	pos, h, err := findFirstFrame(f, int64(id3Size(header)), maxFrameScan)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, h.Size)
+	if _, err := f.ReadAt(frame, pos); err != nil {
+		return err
+	}
+	if hasInfoFrame(frame, h) {
+		pos += int64(h.Size)
+	}
+
+	var startOffset, endOffset int64 = -1, -1
+	var prevPos int64 = -1
+	var prevTime time.Duration
+	var samples int64
+	buf := make([]byte, mpegHeaderSize)
+
+	for {
+		t := time.Duration(samples) * time.Second / time.Duration(h.SampleRate)
+		if startOffset < 0 && t >= start {
+			startOffset = closestFrame(pos, t, prevPos, prevTime, start)
+		}
+		if end > 0 && t >= end {
+			endOffset = closestFrame(pos, t, prevPos, prevTime, end)
+			break
+		}
+
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		// anything else than a frame ends the audio, an ID3v1 tag for instance
+		fh, ok := ParseFrameHeader(buf)
+		if !ok {
+			break
+		}
+
+		prevPos = pos
+		prevTime = t
+		samples += int64(fh.SamplesPerFrame)
+		pos += int64(fh.Size)
+	}
+
+	if startOffset < 0 {
+		return errors.New("start offset after the end of the file")
+	}
+	if endOffset < 0 {
+		endOffset = pos
+	}
+	if endOffset <= startOffset {
+		return errors.New("empty cut")
+	}
+
+	_, err = io.Copy(w, io.NewSectionReader(f, startOffset, endOffset-startOffset))
+	return err
+}
+
+// between the frame at pos and the previous one
+func closestFrame(pos int64, t time.Duration, prevPos int64, prevTime time.Duration, target time.Duration) int64 {
+	if prevPos >= 0 && target-prevTime < t-target {
+		return prevPos
+	}
+	return pos
+}
+
+// Xing or Info frame, with or without LAME extension
+func hasInfoFrame(frame []byte, h FrameHeader) bool {
+	pos := h.sideInfoEnd()
+	if pos+4 > len(frame) {
+		return false
+	}
+
+	id := string(frame[pos : pos+4])
+	return id == "Xing" || id == "Info"
+}
@@ -0,0 +1,147 @@
+package audio
+
+import (
+	"encoding/binary"
+	"github.com/hajimehoshi/go-mp3"
+	"io"
+	"os"
+)
+
+const (
+	// go-mp3 always outputs signed 16 bits little endian stereo
+	decoderChannels       = 2
+	decoderBytesPerSample = 2
+	decoderBytesPerFrame  = decoderChannels * decoderBytesPerSample
+
+	scanBufferFrames = 4096
+)
+
+// stream the PCM content of a mp3 file as interleaved samples in [-1, 1]
+type Decoder struct {
+	file *os.File
+	dec  *mp3.Decoder
+	buf  []byte
+
+	// channels of the file, a mono file being output as stereo
+	sourceChannels int
+}
+
+// a Sink receives the decoded samples of a file, see Scan
+type Sink interface {
+	Start(sampleRate int, channels int)
+	Write(samples []float64)
+}
+
+// a SourceSink is also told the channels of the file, the samples of a mono
+// file being duplicated on both channels
+type SourceSink interface {
+	Sink
+	SetSourceChannels(channels int)
+}
+
+func OpenDecoder(path string) (*Decoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := mp3.NewDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sourceChannels := decoderChannels
+	if h, err := ReadFirstFrameHeader(path); err == nil {
+		sourceChannels = h.Channels
+	}
+
+	return &Decoder{
+		file:           f,
+		dec:            dec,
+		sourceChannels: sourceChannels,
+	}, nil
+}
+
+func (d *Decoder) SampleRate() int {
+	return d.dec.SampleRate()
+}
+
+func (d *Decoder) Channels() int {
+	return decoderChannels
+}
+
+func (d *Decoder) SourceChannels() int {
+	return d.sourceChannels
+}
+
+// number of frames (samples per channel) in the stream
+func (d *Decoder) Length() int64 {
+	return d.dec.Length() / decoderBytesPerFrame
+}
+
+// fill dst with interleaved samples, only whole frames are returned
+func (d *Decoder) Read(dst []float64) (int, error) {
+	frames := len(dst) / decoderChannels
+	size := frames * decoderBytesPerFrame
+	if cap(d.buf) < size {
+		d.buf = make([]byte, size)
+	}
+	buf := d.buf[:size]
+
+	n, err := io.ReadFull(d.dec, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	n -= n % decoderBytesPerFrame
+	samples := n / decoderBytesPerSample
+	for i := 0; i < samples; i++ {
+		v := int16(binary.LittleEndian.Uint16(buf[i*decoderBytesPerSample:]))
+		dst[i] = float64(v) / 32768
+	}
+
+	if samples > 0 && err == io.EOF {
+		// report EOF on next call
+		err = nil
+	}
+
+	return samples, err
+}
+
+func (d *Decoder) Close() error {
+	return d.file.Close()
+}
+
+// decode the file once and feed every sink with its samples
+func Scan(path string, sinks ...Sink) error {
+	d, err := OpenDecoder(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	for _, s := range sinks {
+		s.Start(d.SampleRate(), d.Channels())
+		if ss, ok := s.(SourceSink); ok {
+			ss.SetSourceChannels(d.SourceChannels())
+		}
+	}
+
+	buf := make([]float64, scanBufferFrames*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		if n > 0 {
+			for _, s := range sinks {
+				s.Write(buf[:n])
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/braheezy/shine-mp3/pkg/mp3"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	FormatWav = "wav"
+	FormatMp3 = "mp3"
+
+	wavHeaderSize    = 44
+	wavBitsPerSample = 16
+
+	// shine encodes whole mp3 frames, so feed it with multiples of a frame
+	mp3SamplesPerFrame = 1152
+	mp3ChunkFrames     = 16
+)
+
+func wavDataSize(d *Decoder) int64 {
+	return d.Length() * int64(d.Channels()) * wavBitsPerSample / 8
+}
+
+// decode the file and encode it to w in the given format, the bitrate in kbps
+// being ignored by the lossless ones
+func Transcode(path string, format string, bitrate int, w io.Writer) error {
+	d, err := OpenDecoder(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	switch format {
+	case FormatWav:
+		return encodeWav(d, w)
+	case FormatMp3:
+		return encodeMp3(d, bitrate, w)
+	default:
+		return errors.New("unsupported format " + format)
+	}
+}
+
+func toInt16(v float64) int16 {
+	v = math.Round(v * 32768)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+func encodeWav(d *Decoder, w io.Writer) error {
+	channels := d.Channels()
+	blockAlign := channels * wavBitsPerSample / 8
+	dataSize := wavDataSize(d)
+
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+dataSize))
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1)
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(d.SampleRate()))
+	binary.LittleEndian.PutUint32(header[28:], uint32(d.SampleRate()*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], wavBitsPerSample)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(dataSize))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// the size is declared in the header, so stick to it whatever the decoder outputs
+	remaining := dataSize
+	samples := make([]float64, scanBufferFrames*channels)
+	out := make([]byte, len(samples)*2)
+	for remaining > 0 {
+		n, err := d.Read(samples)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			// pad with silence
+			n = len(samples)
+			for i := range samples {
+				samples[i] = 0
+			}
+		}
+
+		size := int64(2 * n)
+		if size > remaining {
+			size = remaining
+		}
+		for i := 0; i < int(size)/2; i++ {
+			binary.LittleEndian.PutUint16(out[2*i:], uint16(toInt16(samples[i])))
+		}
+
+		if _, err := w.Write(out[:size]); err != nil {
+			return err
+		}
+		remaining -= size
+	}
+
+	return nil
+}
+
+func encodeMp3(d *Decoder, bitrate int, w io.Writer) error {
+	if !validMp3Bitrate(bitrate) {
+		return errors.New("unsupported mp3 bitrate " + strconv.Itoa(bitrate))
+	}
+
+	channels := d.Channels()
+	enc := mp3.NewEncoder(d.SampleRate(), channels)
+	enc.Bitrate = bitrate
+
+	samples := make([]float64, mp3SamplesPerFrame*mp3ChunkFrames*channels)
+	out := make([]int16, len(samples))
+	for {
+		n, err := readFull(d, samples)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		for i := 0; i < n; i++ {
+			out[i] = toInt16(samples[i])
+		}
+		if err := enc.Write(w, out[:n]); err != nil {
+			return err
+		}
+
+		if n < len(samples) {
+			return nil
+		}
+	}
+}
+
+// the MPEG-1 layer III bitrates, in kbps
+func validMp3Bitrate(bitrate int) bool {
+	for _, b := range []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320} {
+		if b == bitrate {
+			return true
+		}
+	}
+	return false
+}
+
+// fill dst unless the end of the stream is reached
+func readFull(d *Decoder, dst []float64) (int, error) {
+	var total int
+	for total < len(dst) {
+		n, err := d.Read(dst[total:])
+		total += n
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
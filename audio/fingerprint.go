@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// chromagram based fingerprint: the spectrum of each frame is folded on the
+// 12 pitch classes, then each frame is reduced to 24 bits describing the
+// shape of the chroma vector and its evolution from the previous frame
+// it does not depend on the bitrate nor on the volume, so two encodings of
+// the same recording get close fingerprints
+
+const (
+	chromaBands     = 12
+	chromaMinFreq   = 28.0
+	chromaMaxFreq   = 3520.0
+	chromaSmoothing = 4
+
+	fingerprintFrameDuration = 0.18
+	fingerprintBits          = 2 * chromaBands
+
+	// maximum shift tried when comparing, in frames (about 6 seconds)
+	fingerprintMaxOffset = 64
+
+	// similarity of unrelated tracks is around 0.5 to 0.6
+	DuplicateThreshold = 0.8
+)
+
+type Fingerprint struct {
+	// duration of the audio, in seconds
+	Duration float64
+	Data     []uint32
+}
+
+func (f Fingerprint) Encode() string {
+	b := make([]byte, 4*len(f.Data))
+	for i, v := range f.Data {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func DecodeFingerprint(s string, duration float64) (Fingerprint, error) {
+	var f = Fingerprint{
+		Duration: duration,
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return f, err
+	}
+	if len(b)%4 != 0 {
+		return f, errors.New("invalid fingerprint length")
+	}
+
+	f.Data = make([]uint32, len(b)/4)
+	for i := range f.Data {
+		f.Data[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+
+	return f, nil
+}
+
+// durations too far apart cannot be the same recording
+func CloseDurations(a float64, b float64) bool {
+	return math.Abs(a-b) <= math.Max(15, 0.1*math.Max(a, b))
+}
+
+// bounds of the durations close to the given one, see CloseDurations
+func CloseDurationRange(d float64) (float64, float64) {
+	return d - math.Max(15, 0.1*d), math.Max(d+15, d/0.9)
+}
+
+// best ratio of identical bits over the possible alignments, in [0, 1]
+func Similarity(a Fingerprint, b Fingerprint) float64 {
+	if len(a.Data) == 0 || len(b.Data) == 0 {
+		return 0
+	}
+
+	shorter := len(a.Data)
+	if len(b.Data) < shorter {
+		shorter = len(b.Data)
+	}
+	minOverlap := (shorter + 1) / 2
+
+	var best float64
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		var errCount, overlap int
+		for i := range a.Data {
+			j := i + offset
+			if j < 0 {
+				continue
+			}
+			if j >= len(b.Data) {
+				break
+			}
+
+			errCount += bits.OnesCount32(a.Data[i] ^ b.Data[j])
+			overlap++
+		}
+
+		if overlap < minOverlap {
+			continue
+		}
+
+		s := 1 - float64(errCount)/float64(overlap*fingerprintBits)
+		if s > best {
+			best = s
+		}
+	}
+
+	return best
+}
+
+// a Fingerprinter is a Sink computing the Fingerprint of what it receives
+type Fingerprinter struct {
+	sampleRate int
+	channels   int
+	frames     int64
+
+	window    []float64
+	bandOf    []int
+	buf       []float64
+	spectrum  []complex128
+	hopSize   int
+	history   [][chromaBands]float64
+	lastChord [chromaBands]float64
+	hasLast   bool
+
+	data []uint32
+}
+
+func NewFingerprinter() *Fingerprinter {
+	return &Fingerprinter{}
+}
+
+func (f *Fingerprinter) Start(sampleRate int, channels int) {
+	f.sampleRate = sampleRate
+	f.channels = channels
+	f.frames = 0
+
+	// the frame duration is the same whatever the sample rate, the FFT input
+	// is zero padded to the next power of 2
+	length := int(fingerprintFrameDuration * float64(sampleRate))
+	size := 1
+	for size < length {
+		size <<= 1
+	}
+
+	f.window = hannWindow(length)
+	f.spectrum = make([]complex128, size)
+	f.buf = make([]float64, 0, length)
+	f.hopSize = length / 2
+
+	// pitch class of each FFT bin, -1 when out of range
+	f.bandOf = make([]int, size/2)
+	for k := range f.bandOf {
+		freq := float64(k) * float64(sampleRate) / float64(size)
+		if freq < chromaMinFreq || freq > chromaMaxFreq {
+			f.bandOf[k] = -1
+			continue
+		}
+
+		note := int(math.Round(12*math.Log2(freq/440))) + 9
+		f.bandOf[k] = ((note % chromaBands) + chromaBands) % chromaBands
+	}
+
+	f.history = f.history[:0]
+	f.hasLast = false
+	f.data = f.data[:0]
+}
+
+func (f *Fingerprinter) Write(samples []float64) {
+	for i := 0; i+f.channels <= len(samples); i += f.channels {
+		var mono float64
+		for c := 0; c < f.channels; c++ {
+			mono += samples[i+c]
+		}
+		f.buf = append(f.buf, mono/float64(f.channels))
+		f.frames++
+
+		if len(f.buf) == cap(f.buf) {
+			f.processFrame()
+			n := copy(f.buf, f.buf[f.hopSize:])
+			f.buf = f.buf[:n]
+		}
+	}
+}
+
+func (f *Fingerprinter) processFrame() {
+	for i := range f.spectrum {
+		if i < len(f.buf) {
+			f.spectrum[i] = complex(f.buf[i]*f.window[i], 0)
+		} else {
+			f.spectrum[i] = 0
+		}
+	}
+	fft(f.spectrum)
+
+	var chroma [chromaBands]float64
+	for k, band := range f.bandOf {
+		if band < 0 {
+			continue
+		}
+		re, im := real(f.spectrum[k]), imag(f.spectrum[k])
+		chroma[band] += re*re + im*im
+	}
+
+	// smooth over a few frames to be less sensitive to encoding artifacts
+	f.history = append(f.history, chroma)
+	if len(f.history) > chromaSmoothing {
+		f.history = f.history[1:]
+	}
+	var smooth [chromaBands]float64
+	for _, h := range f.history {
+		for b := range smooth {
+			smooth[b] += h[b]
+		}
+	}
+
+	var norm float64
+	for _, v := range smooth {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm > 0 {
+		for b := range smooth {
+			smooth[b] /= norm
+		}
+	}
+
+	var word uint32
+	for b := 0; b < chromaBands; b++ {
+		if smooth[b] > smooth[(b+1)%chromaBands] {
+			word |= 1 << uint(b)
+		}
+		if f.hasLast && smooth[b] > f.lastChord[b] {
+			word |= 1 << uint(chromaBands+b)
+		}
+	}
+
+	f.lastChord = smooth
+	f.hasLast = true
+	f.data = append(f.data, word)
+}
+
+func (f *Fingerprinter) Result() Fingerprint {
+	var duration float64
+	if f.sampleRate > 0 {
+		duration = float64(f.frames) / float64(f.sampleRate)
+	}
+
+	return Fingerprint{
+		Duration: duration,
+		Data:     append([]uint32(nil), f.data...),
+	}
+}
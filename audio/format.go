@@ -0,0 +1,213 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// containers recognized from their magic bytes, then checked by parsing them
+
+// FormatMp3 and FormatWav are shared with the encoder
+const (
+	FormatFlac = "flac"
+	FormatOgg  = "ogg"
+	FormatMp4  = "mp4"
+
+	// enough for the RIFF header
+	formatHeaderSize = 12
+
+	flacMagic          = "fLaC"
+	flacStreamInfo     = 0
+	flacStreamInfoSize = 34
+
+	oggPageHeaderSize = 27
+	oggMaxPacketStart = 8
+
+	wavFmtSize = 16
+)
+
+// codecs identified from the first packet of an ogg stream
+var oggCodecs = []string{"\x01vorbis", "OpusHead", "\x7FFLAC", "Speex   "}
+
+// format guessed from the first bytes of a file, empty if unknown
+func DetectFormat(header []byte) string {
+	switch {
+	case len(header) >= 4 && string(header[0:4]) == flacMagic:
+		return FormatFlac
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return FormatWav
+	case len(header) >= 4 && string(header[0:4]) == "OggS":
+		return FormatOgg
+	case IsMp4(header):
+		return FormatMp4
+	case id3Size(header) > 0:
+		// the ID3 tag is in front of the stream, which is checked on the file
+		return FormatMp3
+	}
+
+	if _, ok := ParseFrameHeader(header); ok {
+		return FormatMp3
+	}
+	return ""
+}
+
+// the format of a file, the magic bytes being confirmed by a successful parse
+// of the container, or of the first frames for mp3
+func VerifyFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	infos, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, formatHeaderSize)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	format := DetectFormat(header)
+	switch format {
+	case FormatMp3:
+		offset := int64(id3Size(header))
+
+		// an ID3 tag can also be in front of a FLAC stream
+		magic := make([]byte, len(flacMagic))
+		if _, err := f.ReadAt(magic, offset); err == nil && string(magic) == flacMagic {
+			return FormatFlac, verifyFlac(f, offset)
+		}
+		return format, verifyMpeg(f, offset)
+
+	case FormatFlac:
+		return format, verifyFlac(f, 0)
+	case FormatWav:
+		return format, verifyWav(f, infos.Size())
+	case FormatOgg:
+		return format, verifyOgg(f)
+	case FormatMp4:
+		return format, verifyMp4(f, infos.Size())
+	}
+
+	return "", errors.New("unknown format")
+}
+
+// two consecutive frames, of layer III as the decoder only supports this one
+func verifyMpeg(r io.ReaderAt, offset int64) error {
+	_, h, err := findFirstFrame(r, offset, maxFrameScan)
+	if err != nil {
+		return err
+	}
+
+	if h.Layer != 3 {
+		return fmt.Errorf("mpeg layer %d is not supported", h.Layer)
+	}
+	return nil
+}
+
+// the first metadata block must be the stream info
+func verifyFlac(r io.ReaderAt, offset int64) error {
+	b := make([]byte, len(flacMagic)+4+flacStreamInfoSize)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return errors.New("truncated flac header")
+	}
+
+	block := b[len(flacMagic):]
+	size := int(block[1])<<16 | int(block[2])<<8 | int(block[3])
+	if block[0]&0x7F != flacStreamInfo || size != flacStreamInfoSize {
+		return errors.New("missing flac stream info")
+	}
+
+	info := block[4:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	if sampleRate == 0 {
+		return errors.New("invalid flac sample rate")
+	}
+	return nil
+}
+
+// a fmt chunk describing some audio
+func verifyWav(r io.ReaderAt, size int64) error {
+	chunk := make([]byte, 8)
+	for offset := int64(formatHeaderSize); offset+8 <= size; {
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			return err
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		if string(chunk[0:4]) == "fmt " {
+			if chunkSize < wavFmtSize {
+				return errors.New("invalid wav format chunk")
+			}
+
+			fmtChunk := make([]byte, wavFmtSize)
+			if _, err := r.ReadAt(fmtChunk, offset+8); err != nil {
+				return err
+			}
+
+			channels := binary.LittleEndian.Uint16(fmtChunk[2:4])
+			sampleRate := binary.LittleEndian.Uint32(fmtChunk[4:8])
+			if binary.LittleEndian.Uint16(fmtChunk[0:2]) == 0 || channels == 0 || sampleRate == 0 {
+				return errors.New("invalid wav format chunk")
+			}
+			return nil
+		}
+
+		// chunks are padded to an even size
+		offset += 8 + chunkSize + chunkSize%2
+	}
+
+	return errors.New("missing wav format chunk")
+}
+
+// the first page must start a stream of a known codec
+func verifyOgg(r io.ReaderAt) error {
+	page := make([]byte, oggPageHeaderSize)
+	if _, err := r.ReadAt(page, 0); err != nil {
+		return errors.New("truncated ogg page")
+	}
+
+	// version, then the beginning of stream flag
+	if page[4] != 0 || page[5]&0x02 == 0 {
+		return errors.New("invalid ogg page")
+	}
+
+	segments := int64(page[26])
+	packet := make([]byte, oggMaxPacketStart)
+	if _, err := r.ReadAt(packet, oggPageHeaderSize+segments); err != nil {
+		return errors.New("truncated ogg page")
+	}
+
+	for _, c := range oggCodecs {
+		if string(packet[:len(c)]) == c {
+			return nil
+		}
+	}
+	return errors.New("unknown ogg codec")
+}
+
+// a movie box next to the file type one
+func verifyMp4(r io.ReaderAt, size int64) error {
+	boxes, err := readMp4Boxes(r, 0, size)
+	if err != nil {
+		return err
+	}
+
+	if len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		return errors.New("missing mp4 file type box")
+	}
+	for _, b := range boxes {
+		if b.Type == "moov" {
+			return nil
+		}
+	}
+	return errors.New("missing mp4 movie box")
+}
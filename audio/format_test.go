@@ -0,0 +1,187 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// frames of a MPEG audio stream made of the given header
+func mpegFixture(t *testing.T, header []byte, count int) []byte {
+	h, ok := ParseFrameHeader(header)
+	if !ok {
+		t.Fatalf("invalid frame header %x", header)
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < count; i++ {
+		frame := make([]byte, h.Size)
+		copy(frame, header)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func id3EmptyFixture() []byte {
+	return id3Fixture(4, []id3SubFrame{{id: "TIT2", body: []byte("\x03Title")}})
+}
+
+func flacFixture(blockType byte) []byte {
+	b := []byte(flacMagic)
+	b = append(b, 0x80|blockType, 0, 0, flacStreamInfoSize)
+
+	info := make([]byte, flacStreamInfoSize)
+	// 44100 Hz on 20 bits, then 2 channels and 16 bits per sample
+	info[10] = 0x0A
+	info[11] = 0xC4
+	info[12] = 0x42
+	info[13] = 0xF0
+	return append(b, info...)
+}
+
+func wavFixture(withFmt bool) []byte {
+	var chunks bytes.Buffer
+
+	// a chunk of odd size is padded
+	chunks.WriteString("LIST")
+	binary.Write(&chunks, binary.LittleEndian, uint32(3))
+	chunks.Write([]byte{1, 2, 3, 0})
+
+	if withFmt {
+		chunks.WriteString("fmt ")
+		binary.Write(&chunks, binary.LittleEndian, uint32(wavFmtSize))
+		for _, v := range []interface{}{
+			uint16(1), uint16(2), uint32(44100), uint32(44100 * 4), uint16(4), uint16(16),
+		} {
+			binary.Write(&chunks, binary.LittleEndian, v)
+		}
+	}
+
+	chunks.WriteString("data")
+	binary.Write(&chunks, binary.LittleEndian, uint32(4))
+	chunks.Write(make([]byte, 4))
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+chunks.Len()))
+	b.WriteString("WAVE")
+	b.Write(chunks.Bytes())
+	return b.Bytes()
+}
+
+func oggFixture(codec string) []byte {
+	page := make([]byte, oggPageHeaderSize)
+	copy(page, "OggS")
+	// beginning of stream
+	page[5] = 0x02
+	page[26] = 1
+
+	b := append(page, byte(len(codec)+8))
+	b = append(b, codec...)
+	return append(b, make([]byte, 8)...)
+}
+
+func mp4Fixture(withMoov bool) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(16))
+	b.WriteString("ftypM4A ")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+
+	if withMoov {
+		binary.Write(&b, binary.BigEndian, uint32(8))
+		b.WriteString("moov")
+	}
+	binary.Write(&b, binary.BigEndian, uint32(12))
+	b.WriteString("mdat")
+	b.Write(make([]byte, 4))
+	return b.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	mp3 := []byte{0xFF, 0xFB, 0x90, 0x00}
+
+	var cases = []struct {
+		name   string
+		header []byte
+		format string
+	}{
+		{"mp3 frame", mp3, FormatMp3},
+		{"id3 tag", id3EmptyFixture()[:formatHeaderSize], FormatMp3},
+		{"flac", flacFixture(flacStreamInfo)[:formatHeaderSize], FormatFlac},
+		{"wav", wavFixture(true)[:formatHeaderSize], FormatWav},
+		{"riff of another kind", []byte("RIFF\x00\x00\x00\x00AVI "), ""},
+		{"ogg", oggFixture("OpusHead")[:formatHeaderSize], FormatOgg},
+		{"mp4", mp4Fixture(true)[:formatHeaderSize], FormatMp4},
+		{"text", []byte("hello world!"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, c := range cases {
+		if format := DetectFormat(c.header); format != c.format {
+			t.Errorf("%s: detected %q, expected %q", c.name, format, c.format)
+		}
+	}
+}
+
+func TestVerifyFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// layer III, then layer II
+	mp3 := mpegFixture(t, []byte{0xFF, 0xFB, 0x90, 0x00}, 3)
+	mp2 := mpegFixture(t, []byte{0xFF, 0xFD, 0x90, 0x00}, 3)
+
+	var cases = []struct {
+		name   string
+		data   []byte
+		format string
+		valid  bool
+	}{
+		{"mp3", mp3, FormatMp3, true},
+		{"mp3 with id3 tag", append(id3EmptyFixture(), mp3...), FormatMp3, true},
+		{"mp2", mp2, FormatMp3, false},
+		{"id3 tag without frames", append(id3EmptyFixture(), make([]byte, 64)...), FormatMp3, false},
+		{"flac", flacFixture(flacStreamInfo), FormatFlac, true},
+		{"flac with id3 tag", append(id3EmptyFixture(), flacFixture(flacStreamInfo)...), FormatFlac, true},
+		{"flac without stream info", flacFixture(4), FormatFlac, false},
+		{"wav", wavFixture(true), FormatWav, true},
+		{"wav without format", wavFixture(false), FormatWav, false},
+		{"ogg opus", oggFixture("OpusHead"), FormatOgg, true},
+		{"ogg vorbis", oggFixture("\x01vorbis"), FormatOgg, true},
+		{"ogg video", oggFixture("\x80theora"), FormatOgg, false},
+		{"mp4", mp4Fixture(true), FormatMp4, true},
+		{"mp4 without movie", mp4Fixture(false), FormatMp4, false},
+	}
+
+	for i, c := range cases {
+		p := path.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(p, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		format, err := VerifyFormat(p)
+		if format != c.format {
+			t.Errorf("%s: verified as %q, expected %q", c.name, format, c.format)
+		}
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+
+	p := path.Join(dir, "text")
+	if err := ioutil.WriteFile(p, []byte("not audio at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyFormat(p); err == nil {
+		t.Errorf("text verified as audio")
+	}
+}
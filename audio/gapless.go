@@ -0,0 +1,188 @@
+package audio
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// encoder delay and padding, needed by players to remove the silence the
+// encoders add at the beginning and at the end of the tracks
+
+const (
+	maxFrameScan = 64 << 10
+
+	xingFlagFrames  = 0x01
+	xingFlagBytes   = 0x02
+	xingFlagToc     = 0x04
+	xingFlagQuality = 0x08
+
+	xingTocSize = 100
+	lameTagSize = 24
+	maxDelay    = 1<<12 - 1
+)
+
+var lameEncoders = []string{"LAME", "Lavc", "Lavf"}
+
+type Gapless struct {
+	EncoderDelay   int
+	EncoderPadding int
+	// per channel, once delay and padding removed
+	Samples    int64
+	SampleRate int
+}
+
+// read the LAME info tag of a mp3 file, or the iTunSMPB tag of a mp4 file
+// return false if the file has no such information
+func ReadGapless(path string) (Gapless, bool, error) {
+	var g Gapless
+
+	f, err := os.Open(path)
+	if err != nil {
+		return g, false, err
+	}
+	defer f.Close()
+
+	infos, err := f.Stat()
+	if err != nil {
+		return g, false, err
+	}
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return g, false, err
+	}
+
+	if IsMp4(header) {
+		return readMp4Gapless(f, infos.Size())
+	}
+
+	offset, h, err := findFirstFrame(f, int64(id3Size(header)), maxFrameScan)
+	if err != nil {
+		return g, false, err
+	}
+
+	frame := make([]byte, h.Size)
+	if _, err := f.ReadAt(frame, offset); err != nil {
+		return g, false, err
+	}
+
+	return parseLameTag(frame, h)
+}
+
+func parseLameTag(frame []byte, h FrameHeader) (Gapless, bool, error) {
+	var g = Gapless{
+		SampleRate: h.SampleRate,
+	}
+
+	pos := h.sideInfoEnd()
+	if pos+8 > len(frame) {
+		return g, false, nil
+	}
+
+	id := string(frame[pos : pos+4])
+	if id != "Xing" && id != "Info" {
+		return g, false, nil
+	}
+
+	flags, _ := readUint32(frame, pos+4)
+	pos += 8
+
+	var frames uint32
+	if flags&xingFlagFrames != 0 {
+		var ok bool
+		if frames, ok = readUint32(frame, pos); !ok {
+			return g, false, errors.New("truncated xing header")
+		}
+		pos += 4
+	}
+	if flags&xingFlagBytes != 0 {
+		pos += 4
+	}
+	if flags&xingFlagToc != 0 {
+		pos += xingTocSize
+	}
+	if flags&xingFlagQuality != 0 {
+		pos += 4
+	}
+
+	if frames == 0 || pos+lameTagSize > len(frame) {
+		return g, false, nil
+	}
+
+	lame := frame[pos : pos+lameTagSize]
+	known := false
+	for _, e := range lameEncoders {
+		if string(lame[0:4]) == e {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return g, false, nil
+	}
+
+	g.EncoderDelay = int(lame[21])<<4 | int(lame[22])>>4
+	g.EncoderPadding = int(lame[22]&0x0F)<<8 | int(lame[23])
+
+	// the info frame itself is not counted
+	g.Samples = int64(frames)*int64(h.SamplesPerFrame) -
+		int64(g.EncoderDelay) - int64(g.EncoderPadding)
+	if g.Samples < 0 {
+		return g, false, errors.New("invalid lame tag")
+	}
+
+	return g, true, nil
+}
+
+// iTunSMPB is made of hexadecimal fields:
+// " 00000000 00000840 000001CA 00000000003F31F6 ..." where the second one is
+// the delay, the third one the padding and the fourth one the sample count
+func ParseITunSMPB(s string) (Gapless, bool) {
+	var g Gapless
+
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return g, false
+	}
+
+	delay, err := strconv.ParseInt(fields[1], 16, 64)
+	if err != nil || delay > maxDelay {
+		return g, false
+	}
+	padding, err := strconv.ParseInt(fields[2], 16, 64)
+	if err != nil {
+		return g, false
+	}
+	samples, err := strconv.ParseInt(fields[3], 16, 64)
+	if err != nil || samples <= 0 {
+		return g, false
+	}
+
+	g.EncoderDelay = int(delay)
+	g.EncoderPadding = int(padding)
+	g.Samples = samples
+	return g, true
+}
+
+func readMp4Gapless(f *os.File, size int64) (Gapless, bool, error) {
+	var g Gapless
+
+	value, ok, err := readMp4FreeformTag(f, size, "iTunSMPB")
+	if err != nil || !ok {
+		return g, false, err
+	}
+
+	g, ok = ParseITunSMPB(value)
+	if !ok {
+		return g, false, nil
+	}
+
+	g.SampleRate, err = readMp4Timescale(f, size)
+	if err != nil {
+		return g, false, err
+	}
+
+	return g, true, nil
+}
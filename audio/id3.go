@@ -0,0 +1,122 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// bounds of an ID3v2 tag, checked on the frame headers only so that a crafted
+// tag is rejected before any parser allocates what it declares
+
+const (
+	id3FlagUnsync   = 0x80
+	id3FlagExtended = 0x40
+
+	framePicture = "APIC"
+)
+
+var ErrId3Limit = errors.New("id3v2 tag over the limits")
+
+// check the tag at the beginning of the data, if any
+// the frames of a tag unsynchronised as a whole cannot be walked, only its
+// size is checked then
+func CheckId3(r io.ReaderAt, maxTagSize int, maxFrames int, maxPictureSize int) error {
+	tag, version, err := readId3Tag(r, maxTagSize)
+	if err != nil || tag == nil {
+		return err
+	}
+
+	var frames int
+	return walkId3Frames(tag, version, func(f id3SubFrame) error {
+		if f.id == framePicture && len(f.body) > maxPictureSize {
+			return fmt.Errorf("%w: picture of %d bytes", ErrId3Limit, len(f.body))
+		}
+
+		frames++
+		if frames > maxFrames {
+			return fmt.Errorf("%w: more than %d frames", ErrId3Limit, maxFrames)
+		}
+		return nil
+	})
+}
+
+// frames and version of the tag at the beginning of the data, nil if there is
+// none or if its frames cannot be walked
+func readId3Tag(r io.ReaderAt, maxTagSize int) ([]byte, byte, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	size := id3Size(header)
+	if size == 0 {
+		return nil, 0, nil
+	}
+	if size > maxTagSize {
+		return nil, 0, fmt.Errorf("%w: tag of %d bytes", ErrId3Limit, size)
+	}
+
+	version := header[3]
+	if version < 3 || version > 4 || (version == 3 && header[5]&id3FlagUnsync != 0) {
+		return nil, 0, nil
+	}
+
+	tag := make([]byte, int(synchsafe(header[6:10])))
+	if _, err := r.ReadAt(tag, id3HeaderSize); err != nil {
+		return nil, 0, errors.New("truncated id3v2 tag")
+	}
+
+	pos := 0
+	if header[5]&id3FlagExtended != 0 {
+		if len(tag) < 4 {
+			return nil, 0, errors.New("truncated id3v2 extended header")
+		}
+		if version == 4 {
+			pos = int(synchsafe(tag[0:4]))
+		} else {
+			pos = 4 + int(uint32(tag[0])<<24|uint32(tag[1])<<16|uint32(tag[2])<<8|uint32(tag[3]))
+		}
+		if pos > len(tag) {
+			pos = len(tag)
+		}
+	}
+
+	return tag[pos:], version, nil
+}
+
+// call fn on each frame of the tag, up to the padding
+func walkId3Frames(tag []byte, version byte, fn func(f id3SubFrame) error) error {
+	pos := 0
+	for pos+id3FrameHeaderSize <= len(tag) && tag[pos] != 0 {
+		id := string(tag[pos : pos+4])
+		var frameSize int
+		if version == 4 {
+			frameSize = int(synchsafe(tag[pos+4 : pos+8]))
+		} else {
+			frameSize = int(uint32(tag[pos+4])<<24 | uint32(tag[pos+5])<<16 |
+				uint32(tag[pos+6])<<8 | uint32(tag[pos+7]))
+		}
+		flags := tag[pos+8 : pos+10]
+		pos += id3FrameHeaderSize
+
+		if frameSize > len(tag)-pos {
+			return fmt.Errorf("%w: frame %s of %d bytes beyond the tag", ErrId3Limit, id, frameSize)
+		}
+
+		if err := fn(id3SubFrame{
+			id:    id,
+			flags: flags,
+			body:  tag[pos : pos+frameSize],
+		}); err != nil {
+			return err
+		}
+
+		pos += frameSize
+	}
+
+	return nil
+}
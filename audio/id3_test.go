@@ -0,0 +1,60 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCheckId3(t *testing.T) {
+	const (
+		maxTag     = 4096
+		maxFrames  = 4
+		maxPicture = 1024
+	)
+
+	text := id3SubFrame{id: "TIT2", body: []byte("\x03Title")}
+	picture := func(size int) id3SubFrame {
+		return id3SubFrame{id: framePicture, body: make([]byte, size)}
+	}
+
+	// a frame declaring more than the tag holds
+	beyond := id3Fixture(4, []id3SubFrame{text})
+	putSynchsafe(beyond[id3HeaderSize+4:id3HeaderSize+8], 1000)
+
+	// unsynchronised as a whole, the frames are not walked
+	unsync := id3Fixture(3, []id3SubFrame{text, text, text, text, text})
+	unsync[5] |= id3FlagUnsync
+
+	var cases = []struct {
+		name  string
+		data  []byte
+		limit bool
+	}{
+		{"no tag", []byte{0xFF, 0xFB, 0x90, 0x00}, false},
+		{"empty", nil, false},
+		{"v2.3", id3Fixture(3, []id3SubFrame{text, picture(maxPicture)}), false},
+		{"v2.4", id3Fixture(4, []id3SubFrame{text, text, text, text}), false},
+		{"too many frames", id3Fixture(4, []id3SubFrame{text, text, text, text, text}), true},
+		{"picture too large", id3Fixture(3, []id3SubFrame{picture(maxPicture + 1)}), true},
+		{"tag too large", id3Fixture(4, []id3SubFrame{{id: "PRIV", body: make([]byte, maxTag)}}), true},
+		{"frame beyond the tag", beyond, true},
+		{"unsynchronised v2.3", unsync, false},
+	}
+
+	for _, c := range cases {
+		err := CheckId3(bytes.NewReader(c.data), maxTag, maxFrames, maxPicture)
+		if c.limit && !errors.Is(err, ErrId3Limit) {
+			t.Errorf("%s: expected to be over the limits, got %v", c.name, err)
+		}
+		if !c.limit && err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		}
+	}
+
+	// the header declares more than the data holds
+	truncated := id3Fixture(4, []id3SubFrame{text})
+	if err := CheckId3(bytes.NewReader(truncated[:len(truncated)-4]), maxTag, maxFrames, maxPicture); err == nil {
+		t.Errorf("truncated tag accepted")
+	}
+}
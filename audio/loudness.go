@@ -0,0 +1,248 @@
+package audio
+
+import (
+	"math"
+)
+
+// EBU R128 / ITU-R BS.1770-4 loudness measurement
+
+const (
+	blockDuration    = 0.4
+	subBlocksInBlock = 4
+
+	absoluteGate = -70.0
+	relativeGate = -10.0
+
+	// ReplayGain 2.0 reference level
+	ReplayGainReference = -18.0
+)
+
+type Loudness struct {
+	// integrated loudness in LUFS
+	Integrated float64
+	// sample peak, linear
+	Peak float64
+	// number of blocks above the absolute gate, used to weight album loudness
+	Blocks int
+}
+
+// gain to apply to reach the ReplayGain reference, in dB
+func (l Loudness) Gain() float64 {
+	if l.Blocks == 0 {
+		return 0
+	}
+
+	return ReplayGainReference - l.Integrated
+}
+
+// loudness of a set of tracks played one after the other
+// the relative gate is applied per track, which is close enough for ReplayGain
+func AlbumLoudness(tracks []Loudness) Loudness {
+	var res = Loudness{
+		Integrated: absoluteGate,
+	}
+
+	var energy float64
+	for _, t := range tracks {
+		if t.Peak > res.Peak {
+			res.Peak = t.Peak
+		}
+
+		if t.Blocks == 0 {
+			continue
+		}
+
+		energy += float64(t.Blocks) * loudnessToEnergy(t.Integrated)
+		res.Blocks += t.Blocks
+	}
+
+	if res.Blocks > 0 {
+		res.Integrated = energyToLoudness(energy / float64(res.Blocks))
+	}
+
+	return res
+}
+
+func energyToLoudness(e float64) float64 {
+	return -0.691 + 10*math.Log10(e)
+}
+
+func loudnessToEnergy(l float64) float64 {
+	return math.Pow(10, (l+0.691)/10)
+}
+
+// second order IIR filter, direct form II transposed
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	z1, z2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// K-weighting pre-filter (high shelf) and RLB filter (high pass)
+// coefficients are derived for any sample rate, as done by libebur128
+func newKWeighting(sampleRate int) [2]biquad {
+	rate := float64(sampleRate)
+
+	f0 := 1681.974450955533
+	g := 3.999843853973347
+	q := 0.7071752369554196
+
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return [2]biquad{shelf, highPass}
+}
+
+// a LoudnessMeter is a Sink measuring the integrated loudness of what it receives
+type LoudnessMeter struct {
+	channels int
+	// channels summed, a mono file decoded as stereo counting once
+	measured int
+	filters  [][2]biquad
+
+	subBlockFrames int
+	subBlockCount  int
+	subBlockSum    float64
+	subBlocks      []float64
+
+	blocks []float64
+	peak   float64
+}
+
+func NewLoudnessMeter() *LoudnessMeter {
+	return &LoudnessMeter{}
+}
+
+func (m *LoudnessMeter) Start(sampleRate int, channels int) {
+	m.channels = channels
+	m.measured = channels
+	m.filters = make([][2]biquad, channels)
+	for c := range m.filters {
+		m.filters[c] = newKWeighting(sampleRate)
+	}
+
+	m.subBlockFrames = int(float64(sampleRate) * blockDuration / subBlocksInBlock)
+	m.subBlockCount = 0
+	m.subBlockSum = 0
+	m.subBlocks = m.subBlocks[:0]
+	m.blocks = m.blocks[:0]
+	m.peak = 0
+}
+
+// the samples of a mono file being duplicated by the decoder, only the first
+// channel is measured, otherwise the loudness would be 3 LU too high
+func (m *LoudnessMeter) SetSourceChannels(channels int) {
+	if channels > 0 && channels < m.channels {
+		m.measured = channels
+	}
+}
+
+func (m *LoudnessMeter) Write(samples []float64) {
+	for i := 0; i+m.channels <= len(samples); i += m.channels {
+		for c := 0; c < m.measured; c++ {
+			x := samples[i+c]
+			if a := math.Abs(x); a > m.peak {
+				m.peak = a
+			}
+
+			f := &m.filters[c]
+			y := f[1].process(f[0].process(x))
+
+			// all channel weights are 1 for mono and stereo
+			m.subBlockSum += y * y
+		}
+
+		m.subBlockCount++
+		if m.subBlockCount == m.subBlockFrames {
+			m.pushSubBlock()
+		}
+	}
+}
+
+// blocks are 400ms long with a 75% overlap, so a block is made of 4 sub blocks
+func (m *LoudnessMeter) pushSubBlock() {
+	m.subBlocks = append(m.subBlocks, m.subBlockSum)
+	if len(m.subBlocks) > subBlocksInBlock {
+		m.subBlocks = m.subBlocks[1:]
+	}
+	m.subBlockSum = 0
+	m.subBlockCount = 0
+
+	if len(m.subBlocks) < subBlocksInBlock {
+		return
+	}
+
+	var sum float64
+	for _, s := range m.subBlocks {
+		sum += s
+	}
+
+	energy := sum / float64(m.subBlockFrames*subBlocksInBlock)
+	if energy > 0 && energyToLoudness(energy) > absoluteGate {
+		m.blocks = append(m.blocks, energy)
+	}
+}
+
+func (m *LoudnessMeter) Result() Loudness {
+	var res = Loudness{
+		Integrated: absoluteGate,
+		Peak:       m.peak,
+	}
+
+	if len(m.blocks) == 0 {
+		return res
+	}
+
+	var sum float64
+	for _, e := range m.blocks {
+		sum += e
+	}
+	gate := energyToLoudness(sum/float64(len(m.blocks))) + relativeGate
+
+	sum = 0
+	var count int
+	for _, e := range m.blocks {
+		if energyToLoudness(e) > gate {
+			sum += e
+			count++
+		}
+	}
+
+	if count == 0 {
+		return res
+	}
+
+	res.Integrated = energyToLoudness(sum / float64(count))
+	res.Blocks = len(m.blocks)
+	return res
+}
@@ -0,0 +1,45 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+// a 997 Hz sine at 0 dBFS on a single channel reads -3.01 LKFS
+func sineLoudness(channels int, source int) Loudness {
+	const rate = 48000
+
+	m := NewLoudnessMeter()
+	m.Start(rate, channels)
+	m.SetSourceChannels(source)
+
+	samples := make([]float64, 0, rate*5*channels)
+	for i := 0; i < rate*5; i++ {
+		x := math.Sin(2 * math.Pi * 997 * float64(i) / rate)
+		for c := 0; c < channels; c++ {
+			samples = append(samples, x)
+		}
+	}
+	m.Write(samples)
+
+	return m.Result()
+}
+
+func TestLoudnessMono(t *testing.T) {
+	mono := sineLoudness(1, 1)
+	if math.Abs(mono.Integrated+3.01) > 0.05 {
+		t.Fatalf("mono sine: got %.2f LKFS, want -3.01", mono.Integrated)
+	}
+
+	// decoded as stereo, the samples being duplicated
+	duplicated := sineLoudness(2, 1)
+	if math.Abs(duplicated.Integrated-mono.Integrated) > 0.01 {
+		t.Fatalf("mono decoded as stereo: got %.2f LKFS, want %.2f", duplicated.Integrated, mono.Integrated)
+	}
+
+	// a real stereo signal counts both channels
+	stereo := sineLoudness(2, 2)
+	if math.Abs(stereo.Integrated-mono.Integrated-3.01) > 0.05 {
+		t.Fatalf("stereo sine: got %.2f LKFS, want %.2f", stereo.Integrated, mono.Integrated+3.01)
+	}
+}
@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// minimal reader of the ISO base media (MP4) boxes
+
+const (
+	mp4BoxHeaderSize = 8
+	// version and flags of the full boxes
+	mp4FullBoxHeaderSize = 4
+)
+
+type mp4Box struct {
+	Type string
+	// offset and size of the payload
+	Offset int64
+	Size   int64
+}
+
+func (b mp4Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+func IsMp4(header []byte) bool {
+	return len(header) >= 8 && string(header[4:8]) == "ftyp"
+}
+
+// list the boxes between offset and end
+func readMp4Boxes(r io.ReaderAt, offset int64, end int64) ([]mp4Box, error) {
+	var res = make([]mp4Box, 0)
+	header := make([]byte, 16)
+
+	for offset+mp4BoxHeaderSize <= end {
+		if _, err := r.ReadAt(header[:mp4BoxHeaderSize], offset); err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:]))
+		boxType := string(header[4:8])
+		headerSize := int64(mp4BoxHeaderSize)
+
+		switch size {
+		case 0:
+			// up to the end
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize += 8
+		}
+
+		if size < headerSize || offset+size > end {
+			return nil, errors.New("invalid mp4 box " + boxType)
+		}
+
+		res = append(res, mp4Box{
+			Type:   boxType,
+			Offset: offset + headerSize,
+			Size:   size - headerSize,
+		})
+		offset += size
+	}
+
+	return res, nil
+}
+
+// payload of a child box
+func findMp4Box(r io.ReaderAt, parent mp4Box, boxType string) (mp4Box, bool, error) {
+	boxes, err := readMp4Boxes(r, parent.Offset, parent.End())
+	if err != nil {
+		return mp4Box{}, false, err
+	}
+
+	for _, b := range boxes {
+		if b.Type == boxType {
+			return b, true, nil
+		}
+	}
+
+	return mp4Box{}, false, nil
+}
+
+// follow a path of boxes from the root, "meta" being a full box
+func findMp4Path(r io.ReaderAt, size int64, path ...string) (mp4Box, bool, error) {
+	current := mp4Box{
+		Offset: 0,
+		Size:   size,
+	}
+
+	for _, p := range path {
+		b, ok, err := findMp4Box(r, current, p)
+		if err != nil || !ok {
+			return b, ok, err
+		}
+
+		if p == "meta" {
+			b.Offset += mp4FullBoxHeaderSize
+			b.Size -= mp4FullBoxHeaderSize
+		}
+		current = b
+	}
+
+	return current, true, nil
+}
+
+func readMp4Payload(r io.ReaderAt, b mp4Box, max int64) ([]byte, error) {
+	if b.Size > max {
+		return nil, errors.New("mp4 box " + b.Type + " too big")
+	}
+
+	buf := make([]byte, b.Size)
+	_, err := r.ReadAt(buf, b.Offset)
+	return buf, err
+}
+
+// value of an iTunes freeform metadata (the "----" items of the ilst box)
+func readMp4FreeformTag(r io.ReaderAt, size int64, name string) (string, bool, error) {
+	ilst, ok, err := findMp4Path(r, size, "moov", "udta", "meta", "ilst")
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	items, err := readMp4Boxes(r, ilst.Offset, ilst.End())
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, item := range items {
+		if item.Type != "----" {
+			continue
+		}
+
+		children, err := readMp4Boxes(r, item.Offset, item.End())
+		if err != nil {
+			return "", false, err
+		}
+
+		var itemName, value string
+		for _, c := range children {
+			payload, err := readMp4Payload(r, c, 1<<16)
+			if err != nil {
+				return "", false, err
+			}
+
+			switch c.Type {
+			case "name":
+				if len(payload) >= mp4FullBoxHeaderSize {
+					itemName = string(payload[mp4FullBoxHeaderSize:])
+				}
+			case "data":
+				// type and locale
+				if len(payload) >= 8 {
+					value = string(payload[8:])
+				}
+			}
+		}
+
+		if itemName == name {
+			return value, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// timescale of the first track, which is the sample rate for audio
+func readMp4Timescale(r io.ReaderAt, size int64) (int, error) {
+	mdhd, ok, err := findMp4Path(r, size, "moov", "trak", "mdia", "mdhd")
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("mp4 media header not found")
+	}
+
+	payload, err := readMp4Payload(r, mdhd, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	// version 1 uses 64 bits dates
+	offset := 12
+	if len(payload) > 0 && payload[0] == 1 {
+		offset = 20
+	}
+
+	v, ok := readUint32(payload, offset)
+	if !ok {
+		return 0, errors.New("invalid mp4 media header")
+	}
+
+	return int(v), nil
+}
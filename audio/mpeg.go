@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// MPEG audio frame headers
+
+const (
+	mpegVersion1  = 1
+	mpegVersion2  = 2
+	mpegVersion25 = 25
+
+	mpegHeaderSize = 4
+	id3HeaderSize  = 10
+)
+
+var mpegBitrates = map[int][3][16]int{
+	mpegVersion1: {
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+	},
+	mpegVersion2: {
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+	},
+}
+
+var mpegSampleRates = map[int][3]int{
+	mpegVersion1:  {44100, 48000, 32000},
+	mpegVersion2:  {22050, 24000, 16000},
+	mpegVersion25: {11025, 12000, 8000},
+}
+
+type FrameHeader struct {
+	Version    int
+	Layer      int
+	Protected  bool
+	Bitrate    int // kbps
+	SampleRate int
+	Padding    bool
+	Channels   int
+	// whole frame, header included
+	Size            int
+	SamplesPerFrame int
+}
+
+func ParseFrameHeader(b []byte) (FrameHeader, bool) {
+	var h FrameHeader
+	if len(b) < mpegHeaderSize || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+
+	switch (b[1] >> 3) & 0x03 {
+	case 0:
+		h.Version = mpegVersion25
+	case 2:
+		h.Version = mpegVersion2
+	case 3:
+		h.Version = mpegVersion1
+	default:
+		return h, false
+	}
+
+	layerBits := (b[1] >> 1) & 0x03
+	if layerBits == 0 {
+		return h, false
+	}
+	h.Layer = 4 - int(layerBits)
+	h.Protected = b[1]&0x01 == 0
+
+	bitrateVersion := h.Version
+	if bitrateVersion == mpegVersion25 {
+		bitrateVersion = mpegVersion2
+	}
+	h.Bitrate = mpegBitrates[bitrateVersion][h.Layer-1][b[2]>>4]
+	if h.Bitrate <= 0 {
+		// free format is not supported
+		return h, false
+	}
+
+	rateIndex := (b[2] >> 2) & 0x03
+	if rateIndex == 3 {
+		return h, false
+	}
+	h.SampleRate = mpegSampleRates[h.Version][rateIndex]
+	h.Padding = (b[2]>>1)&0x01 == 1
+
+	h.Channels = 2
+	if b[3]>>6 == 3 {
+		h.Channels = 1
+	}
+
+	var padding int
+	switch h.Layer {
+	case 1:
+		h.SamplesPerFrame = 384
+		if h.Padding {
+			padding = 4
+		}
+		h.Size = (12*h.Bitrate*1000/h.SampleRate)*4 + padding
+	default:
+		h.SamplesPerFrame = 1152
+		if h.Layer == 3 && h.Version != mpegVersion1 {
+			h.SamplesPerFrame = 576
+		}
+		if h.Padding {
+			padding = 1
+		}
+		h.Size = h.SamplesPerFrame/8*h.Bitrate*1000/h.SampleRate + padding
+	}
+
+	return h, true
+}
+
+// offset of the layer 3 side information in a frame, where the Xing header is
+func (h FrameHeader) sideInfoEnd() int {
+	offset := mpegHeaderSize
+	if h.Protected {
+		offset += 2
+	}
+
+	if h.Version == mpegVersion1 {
+		if h.Channels == 1 {
+			return offset + 17
+		}
+		return offset + 32
+	}
+
+	if h.Channels == 1 {
+		return offset + 9
+	}
+	return offset + 17
+}
+
+// size of the ID3v2 tag at the beginning of the data, 0 if there is none
+func id3Size(b []byte) int {
+	if len(b) < id3HeaderSize || string(b[0:3]) != "ID3" {
+		return 0
+	}
+
+	size := int(b[6]&0x7F)<<21 | int(b[7]&0x7F)<<14 | int(b[8]&0x7F)<<7 | int(b[9]&0x7F)
+	size += id3HeaderSize
+	if b[5]&0x10 != 0 {
+		// footer
+		size += id3HeaderSize
+	}
+
+	return size
+}
+
+// find the first frame followed by another valid frame, starting at offset
+// return the offset of the frame
+func findFirstFrame(r io.ReaderAt, offset int64, maxScan int64) (int64, FrameHeader, error) {
+	var h FrameHeader
+	buf := make([]byte, mpegHeaderSize)
+	next := make([]byte, mpegHeaderSize)
+
+	for pos := offset; pos < offset+maxScan; pos++ {
+		if _, err := r.ReadAt(buf, pos); err != nil {
+			return 0, h, err
+		}
+
+		candidate, ok := ParseFrameHeader(buf)
+		if !ok {
+			continue
+		}
+
+		if _, err := r.ReadAt(next, pos+int64(candidate.Size)); err != nil {
+			if err == io.EOF {
+				// a single frame
+				return pos, candidate, nil
+			}
+			return 0, h, err
+		}
+
+		if nh, ok := ParseFrameHeader(next); ok && nh.Version == candidate.Version &&
+			nh.Layer == candidate.Layer && nh.SampleRate == candidate.SampleRate {
+			return pos, candidate, nil
+		}
+	}
+
+	return 0, h, errors.New("no mpeg frame found")
+}
+
+// header of the first frame of the file, after its ID3 tag
+func ReadFirstFrameHeader(path string) (FrameHeader, error) {
+	var h FrameHeader
+
+	f, err := os.Open(path)
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return h, err
+	}
+
+	_, h, err = findFirstFrame(f, int64(id3Size(header)), maxFrameScan)
+	return h, err
+}
+
+func readUint32(b []byte, offset int) (uint32, bool) {
+	if offset < 0 || offset+4 > len(b) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b[offset:]), true
+}
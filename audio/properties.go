@@ -0,0 +1,387 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// properties of the audio stream of a file, read from the headers of its
+// format, mp3 files being decoded to get their exact duration
+
+const (
+	// where the last ogg page is looked for
+	oggTailSize = 64 << 10
+	// the granule positions of opus are always at this rate
+	opusGranuleRate = 48000
+
+	wavFormatPcm        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+type Properties struct {
+	Format     string
+	SampleRate int
+	Channels   int
+	// kbps
+	Bitrate int
+	// seconds
+	Duration float64
+	Lossless bool
+}
+
+func ReadProperties(path string) (Properties, error) {
+	var p Properties
+
+	format, err := VerifyFormat(path)
+	if err != nil {
+		return p, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return p, err
+	}
+	defer f.Close()
+
+	infos, err := f.Stat()
+	if err != nil {
+		return p, err
+	}
+
+	switch format {
+	case FormatMp3:
+		p, err = readMpegProperties(path)
+	case FormatFlac:
+		header := make([]byte, id3HeaderSize)
+		if _, err := f.ReadAt(header, 0); err != nil {
+			return p, err
+		}
+		p, err = readFlacProperties(f, int64(id3Size(header)))
+	case FormatWav:
+		p, err = readWavProperties(f, infos.Size())
+	case FormatOgg:
+		p, err = readOggProperties(f, infos.Size())
+	case FormatMp4:
+		p, err = readMp4Properties(f, infos.Size())
+	}
+	if err != nil {
+		return p, err
+	}
+
+	// the nominal bitrate of mp3 is in its frames, the average one is used
+	// for the other formats
+	p.Format = format
+	if p.Bitrate == 0 && p.Duration > 0 {
+		p.Bitrate = int(float64(infos.Size()) * 8 / p.Duration / 1000)
+	}
+
+	return p, nil
+}
+
+func readMpegProperties(path string) (Properties, error) {
+	var p Properties
+
+	h, err := ReadFirstFrameHeader(path)
+	if err != nil {
+		return p, err
+	}
+
+	d, err := OpenDecoder(path)
+	if err != nil {
+		return p, err
+	}
+	defer d.Close()
+
+	return Properties{
+		SampleRate: h.SampleRate,
+		Channels:   h.Channels,
+		Bitrate:    h.Bitrate,
+		Duration:   float64(d.Length()) / float64(d.SampleRate()),
+	}, nil
+}
+
+// the stream info block, the first one after the magic bytes
+func parseFlacStreamInfo(info []byte) (Properties, error) {
+	var p Properties
+	if len(info) < flacStreamInfoSize {
+		return p, errors.New("truncated flac stream info")
+	}
+
+	p.SampleRate = int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	p.Channels = int(info[12]>>1&0x07) + 1
+	samples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if p.SampleRate == 0 {
+		return p, errors.New("invalid flac sample rate")
+	}
+
+	p.Duration = float64(samples) / float64(p.SampleRate)
+	p.Lossless = true
+	return p, nil
+}
+
+func readFlacProperties(r io.ReaderAt, offset int64) (Properties, error) {
+	b := make([]byte, flacStreamInfoSize)
+	if _, err := r.ReadAt(b, offset+int64(len(flacMagic))+4); err != nil {
+		return Properties{}, errors.New("truncated flac header")
+	}
+
+	return parseFlacStreamInfo(b)
+}
+
+// from the format chunk and the size of the data chunk
+func readWavProperties(r io.ReaderAt, size int64) (Properties, error) {
+	var p Properties
+	var byteRate int64
+	var dataSize int64 = -1
+
+	chunk := make([]byte, 8)
+	for offset := int64(formatHeaderSize); offset+8 <= size; {
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			return p, err
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			fmtChunk := make([]byte, wavFmtSize)
+			if _, err := r.ReadAt(fmtChunk, offset+8); err != nil {
+				return p, err
+			}
+
+			tag := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			p.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			p.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(fmtChunk[8:12]))
+			p.Lossless = tag == wavFormatPcm || tag == wavFormatFloat || tag == wavFormatExtensible
+
+		case "data":
+			// the size of a stream still being written is unknown
+			dataSize = chunkSize
+			if dataSize == 0xFFFFFFFF || offset+8+dataSize > size {
+				dataSize = size - offset - 8
+			}
+		}
+
+		// chunks are padded to an even size
+		offset += 8 + chunkSize + chunkSize%2
+	}
+
+	if p.SampleRate == 0 {
+		return p, errors.New("missing wav format chunk")
+	}
+	if byteRate > 0 && dataSize >= 0 {
+		p.Duration = float64(dataSize) / float64(byteRate)
+	}
+
+	return p, nil
+}
+
+// from the identification header of the first page, and the granule position
+// of the last one
+func readOggProperties(r io.ReaderAt, size int64) (Properties, error) {
+	var p Properties
+
+	page := make([]byte, oggPageHeaderSize)
+	if _, err := r.ReadAt(page, 0); err != nil {
+		return p, errors.New("truncated ogg page")
+	}
+
+	segments := int64(page[26])
+	table := make([]byte, segments)
+	if _, err := r.ReadAt(table, oggPageHeaderSize); err != nil {
+		return p, errors.New("truncated ogg page")
+	}
+	var packetSize int64
+	for _, s := range table {
+		packetSize += int64(s)
+		if s < 255 {
+			break
+		}
+	}
+
+	packet := make([]byte, packetSize)
+	if _, err := r.ReadAt(packet, oggPageHeaderSize+segments); err != nil {
+		return p, errors.New("truncated ogg page")
+	}
+
+	// granule positions per second, and samples to skip at the start
+	var rate int
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		p.Channels = int(packet[11])
+		p.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		rate = p.SampleRate
+
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 16:
+		p.Channels = int(packet[9])
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		p.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		if p.SampleRate == 0 {
+			p.SampleRate = opusGranuleRate
+		}
+		rate = opusGranuleRate
+
+	case bytes.HasPrefix(packet, []byte("\x7FFLAC")) && len(packet) >= 17+flacStreamInfoSize:
+		// mapping version, header count and the native magic, then the
+		// stream info block
+		info, err := parseFlacStreamInfo(packet[17:])
+		if err != nil {
+			return p, err
+		}
+		p.Channels = info.Channels
+		p.SampleRate = info.SampleRate
+		p.Lossless = true
+		rate = p.SampleRate
+
+	case bytes.HasPrefix(packet, []byte("Speex   ")) && len(packet) >= 52:
+		p.SampleRate = int(binary.LittleEndian.Uint32(packet[36:40]))
+		p.Channels = int(binary.LittleEndian.Uint32(packet[48:52]))
+		rate = p.SampleRate
+
+	default:
+		return p, errors.New("unknown ogg codec")
+	}
+
+	if rate == 0 {
+		return p, errors.New("invalid ogg sample rate")
+	}
+
+	granule, err := lastOggGranule(r, size)
+	if err != nil {
+		return p, err
+	}
+	if granule > preSkip {
+		p.Duration = float64(granule-preSkip) / float64(rate)
+	}
+
+	return p, nil
+}
+
+// granule position of the last page, the total of samples of the stream
+func lastOggGranule(r io.ReaderAt, size int64) (int64, error) {
+	start := size - oggTailSize
+	if start < 0 {
+		start = 0
+	}
+
+	tail := make([]byte, size-start)
+	if _, err := r.ReadAt(tail, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0, errors.New("last ogg page not found")
+	}
+
+	return int64(binary.LittleEndian.Uint64(tail[i+6 : i+14])), nil
+}
+
+// from the media header and the sample description of the first sound track
+func readMp4Properties(r io.ReaderAt, size int64) (Properties, error) {
+	var p Properties
+
+	moov, ok, err := findMp4Path(r, size, "moov")
+	if err != nil {
+		return p, err
+	}
+	if !ok {
+		return p, errors.New("missing mp4 movie box")
+	}
+
+	boxes, err := readMp4Boxes(r, moov.Offset, moov.End())
+	if err != nil {
+		return p, err
+	}
+
+	for _, trak := range boxes {
+		if trak.Type != "trak" {
+			continue
+		}
+
+		mdia, ok, err := findMp4Box(r, trak, "mdia")
+		if err != nil || !ok {
+			continue
+		}
+
+		// the handler type follows the version, the flags and a reserved field
+		hdlr, ok, err := findMp4Box(r, mdia, "hdlr")
+		if err != nil || !ok {
+			continue
+		}
+		handler, err := readMp4Payload(r, hdlr, 256)
+		if err != nil || len(handler) < 12 || string(handler[8:12]) != "soun" {
+			continue
+		}
+
+		return readMp4SoundTrack(r, mdia)
+	}
+
+	return p, errors.New("no mp4 sound track")
+}
+
+func readMp4SoundTrack(r io.ReaderAt, mdia mp4Box) (Properties, error) {
+	var p Properties
+
+	mdhd, ok, err := findMp4Box(r, mdia, "mdhd")
+	if err != nil {
+		return p, err
+	}
+	if !ok {
+		return p, errors.New("mp4 media header not found")
+	}
+	header, err := readMp4Payload(r, mdhd, 64)
+	if err != nil {
+		return p, err
+	}
+
+	// version 1 uses 64 bits dates and duration
+	var timescale uint32
+	var duration uint64
+	if len(header) >= 32 && header[0] == 1 {
+		timescale = binary.BigEndian.Uint32(header[20:24])
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else if len(header) >= 20 && header[0] == 0 {
+		timescale = binary.BigEndian.Uint32(header[12:16])
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	} else {
+		return p, errors.New("invalid mp4 media header")
+	}
+	if timescale > 0 {
+		p.Duration = float64(duration) / float64(timescale)
+	}
+
+	var stsd = mdia
+	for _, boxType := range []string{"minf", "stbl", "stsd"} {
+		if stsd, ok, err = findMp4Box(r, stsd, boxType); err != nil {
+			return p, err
+		}
+		if !ok {
+			return p, errors.New("mp4 sample description not found")
+		}
+	}
+
+	description, err := readMp4Payload(r, stsd, 4096)
+	if err != nil {
+		return p, err
+	}
+
+	// entry count, then the first sample entry: size, codec, reserved, data
+	// reference, version, revision, vendor, channels, sample size,
+	// compression, packet size and the sample rate in 16.16
+	if len(description) < mp4FullBoxHeaderSize+4+36 {
+		return p, errors.New("invalid mp4 sample description")
+	}
+	entry := description[mp4FullBoxHeaderSize+4:]
+	codec := string(entry[4:8])
+	p.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+	p.SampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+	p.Lossless = codec == "alac" || codec == "fLaC"
+
+	return p, nil
+}
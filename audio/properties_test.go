@@ -0,0 +1,133 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+)
+
+// a stream of the given codec, whose last page ends at the granule position
+func oggStreamFixture(packet []byte, granule int64) []byte {
+	page := make([]byte, oggPageHeaderSize)
+	copy(page, "OggS")
+	page[5] = 0x02
+	page[26] = 1
+
+	b := append(page, byte(len(packet)))
+	b = append(b, packet...)
+
+	last := make([]byte, oggPageHeaderSize)
+	copy(last, "OggS")
+	// end of stream
+	last[5] = 0x04
+	binary.LittleEndian.PutUint64(last[6:14], uint64(granule))
+	b = append(b, make([]byte, 100)...)
+	return append(b, last...)
+}
+
+func mp4BoxFixture(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, mp4BoxHeaderSize)
+	binary.BigEndian.PutUint32(b, uint32(mp4BoxHeaderSize+len(body)))
+	copy(b[4:], boxType)
+	return append(b, body...)
+}
+
+// a movie with a video track, then a sound one of the given codec
+func mp4TrackFixture(codec string, timescale uint32, duration uint32) []byte {
+	hdlr := func(handler string) []byte {
+		p := make([]byte, 24)
+		copy(p[8:], handler)
+		return mp4BoxFixture("hdlr", p)
+	}
+
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], timescale)
+	binary.BigEndian.PutUint32(mdhd[16:], duration)
+
+	entry := make([]byte, 36)
+	binary.BigEndian.PutUint32(entry[0:], 36)
+	copy(entry[4:], codec)
+	binary.BigEndian.PutUint16(entry[24:], 2)
+	binary.BigEndian.PutUint32(entry[32:], 44100<<16)
+	stsd := append(make([]byte, 8), entry...)
+	stsd[7] = 1
+
+	video := mp4BoxFixture("trak", mp4BoxFixture("mdia", hdlr("vide")))
+	sound := mp4BoxFixture("trak", mp4BoxFixture("mdia",
+		hdlr("soun"),
+		mp4BoxFixture("mdhd", mdhd),
+		mp4BoxFixture("minf", mp4BoxFixture("stbl", mp4BoxFixture("stsd", stsd))),
+	))
+
+	ftyp := mp4BoxFixture("ftyp", []byte("M4A "), make([]byte, 4))
+	return append(ftyp, mp4BoxFixture("moov", video, sound)...)
+}
+
+func TestReadProperties(t *testing.T) {
+	dir, err := ioutil.TempDir("", "properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 2 seconds at 44100 Hz
+	flac := flacFixture(flacStreamInfo)
+	binary.BigEndian.PutUint32(flac[len(flacMagic)+4+14:], 88200)
+
+	// 2 seconds of 16 bits stereo
+	wav := wavFixture(true)
+	binary.LittleEndian.PutUint32(wav[len(wav)-8:], 44100*4*2)
+	wav = append(wav, make([]byte, 44100*4*2-4)...)
+
+	vorbis := append([]byte("\x01vorbis"), make([]byte, 22)...)
+	vorbis[11] = 2
+	binary.LittleEndian.PutUint32(vorbis[12:], 44100)
+
+	opus := append([]byte("OpusHead"), make([]byte, 11)...)
+	opus[9] = 2
+	binary.LittleEndian.PutUint16(opus[10:], 312)
+	binary.LittleEndian.PutUint32(opus[12:], 48000)
+
+	var cases = []struct {
+		name       string
+		data       []byte
+		format     string
+		sampleRate int
+		channels   int
+		duration   float64
+		lossless   bool
+	}{
+		{"flac", flac, FormatFlac, 44100, 2, 2, true},
+		{"wav", wav, FormatWav, 44100, 2, 2, true},
+		{"ogg vorbis", oggStreamFixture(vorbis, 88200), FormatOgg, 44100, 2, 2, false},
+		{"ogg opus", oggStreamFixture(opus, 96312), FormatOgg, 48000, 2, 2, false},
+		{"mp4 aac", mp4TrackFixture("mp4a", 44100, 88200), FormatMp4, 44100, 2, 2, false},
+		{"mp4 alac", mp4TrackFixture("alac", 44100, 88200), FormatMp4, 44100, 2, 2, true},
+	}
+
+	for i, c := range cases {
+		p := path.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(p, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		props, err := ReadProperties(p)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+			continue
+		}
+
+		if props.Format != c.format || props.SampleRate != c.sampleRate || props.Channels != c.channels ||
+			math.Abs(props.Duration-c.duration) > 0.001 || props.Lossless != c.lossless {
+			t.Errorf("%s: got %+v", c.name, props)
+		}
+		if want := int(float64(len(c.data)) * 8 / props.Duration / 1000); props.Bitrate != want {
+			t.Errorf("%s: bitrate %d, expected the average %d", c.name, props.Bitrate, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// min/max peaks in the format of the BBC audiowaveform tool (version 2, 8 bits),
+// which web players such as peaks.js read directly
+
+const (
+	waveformVersion    = 2
+	waveformFlag8Bits  = 1
+	waveformHeaderSize = 24
+)
+
+// samples per pixel computed for each track
+var WaveformResolutions = []int{256, 1024, 4096}
+
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	// min and max pairs, channels are merged
+	Data []int8
+}
+
+// number of min/max pairs
+func (w Waveform) Length() int {
+	return len(w.Data) / 2
+}
+
+func (w Waveform) MarshalBinary() ([]byte, error) {
+	b := make([]byte, waveformHeaderSize+len(w.Data))
+	binary.LittleEndian.PutUint32(b[0:], waveformVersion)
+	binary.LittleEndian.PutUint32(b[4:], waveformFlag8Bits)
+	binary.LittleEndian.PutUint32(b[8:], uint32(w.SampleRate))
+	binary.LittleEndian.PutUint32(b[12:], uint32(w.SamplesPerPixel))
+	binary.LittleEndian.PutUint32(b[16:], uint32(w.Length()))
+	binary.LittleEndian.PutUint32(b[20:], 1)
+
+	for i, v := range w.Data {
+		b[waveformHeaderSize+i] = byte(v)
+	}
+
+	return b, nil
+}
+
+func (w *Waveform) UnmarshalBinary(b []byte) error {
+	if len(b) < waveformHeaderSize {
+		return errors.New("waveform data too short")
+	}
+
+	if binary.LittleEndian.Uint32(b[0:]) != waveformVersion ||
+		binary.LittleEndian.Uint32(b[4:]) != waveformFlag8Bits ||
+		binary.LittleEndian.Uint32(b[20:]) != 1 {
+		return errors.New("unsupported waveform data")
+	}
+
+	length := int(binary.LittleEndian.Uint32(b[16:]))
+	if len(b) != waveformHeaderSize+2*length {
+		return errors.New("waveform data length mismatch")
+	}
+
+	w.SampleRate = int(binary.LittleEndian.Uint32(b[8:]))
+	w.SamplesPerPixel = int(binary.LittleEndian.Uint32(b[12:]))
+	w.Data = make([]int8, 2*length)
+	for i := range w.Data {
+		w.Data[i] = int8(b[waveformHeaderSize+i])
+	}
+
+	return nil
+}
+
+type peakAccumulator struct {
+	samplesPerPixel int
+	count           int
+	min, max        float64
+	data            []int8
+}
+
+func (a *peakAccumulator) flush() {
+	a.data = append(a.data, toInt8(a.min), toInt8(a.max))
+	a.count = 0
+	a.min = 0
+	a.max = 0
+}
+
+func toInt8(v float64) int8 {
+	v = math.Round(v * 127)
+	if v > 127 {
+		return 127
+	}
+	if v < -128 {
+		return -128
+	}
+	return int8(v)
+}
+
+// a WaveformBuilder is a Sink computing the peaks at several resolutions at once
+type WaveformBuilder struct {
+	sampleRate   int
+	channels     int
+	accumulators []peakAccumulator
+}
+
+func NewWaveformBuilder(resolutions ...int) *WaveformBuilder {
+	b := &WaveformBuilder{}
+	for _, r := range resolutions {
+		b.accumulators = append(b.accumulators, peakAccumulator{
+			samplesPerPixel: r,
+		})
+	}
+
+	return b
+}
+
+func (b *WaveformBuilder) Start(sampleRate int, channels int) {
+	b.sampleRate = sampleRate
+	b.channels = channels
+	for i := range b.accumulators {
+		a := &b.accumulators[i]
+		a.count = 0
+		a.min = 0
+		a.max = 0
+		a.data = a.data[:0]
+	}
+}
+
+func (b *WaveformBuilder) Write(samples []float64) {
+	for i := 0; i+b.channels <= len(samples); i += b.channels {
+		min, max := samples[i], samples[i]
+		for c := 1; c < b.channels; c++ {
+			min = math.Min(min, samples[i+c])
+			max = math.Max(max, samples[i+c])
+		}
+
+		for j := range b.accumulators {
+			a := &b.accumulators[j]
+			if a.count == 0 || min < a.min {
+				a.min = min
+			}
+			if a.count == 0 || max > a.max {
+				a.max = max
+			}
+
+			a.count++
+			if a.count == a.samplesPerPixel {
+				a.flush()
+			}
+		}
+	}
+}
+
+func (b *WaveformBuilder) Result() []Waveform {
+	var res = make([]Waveform, 0)
+	for i := range b.accumulators {
+		a := &b.accumulators[i]
+		if a.count > 0 {
+			a.flush()
+		}
+
+		res = append(res, Waveform{
+			SampleRate:      b.sampleRate,
+			SamplesPerPixel: a.samplesPerPixel,
+			Data:            append([]int8(nil), a.data...),
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"io"
+	"net/http"
+)
+
+// GET
+// Authorization: 	None
+// Params: 			id, or artist and album
+// Body: 			None
+
+// get the cover stored for an album, public as the download
+func AlbumCoverGet(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get(idParam)
+	artist := r.URL.Query().Get(artistParam)
+	album := r.URL.Query().Get(albumParam)
+
+	if id == "" && (artist == "" || album == "") {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	p, err := managers.AlbumCoverGetManager(id, artist, album)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the cover", w)
+		return
+	}
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, p)
+}
+
+// POST
+// Authorization: 	token
+// Params: 			artist, album
+// Body: 			imageParam (jpg or png)
+
+// store the image as the cover of the album, replacing the image of its tracks
+func AlbumCoverUpload(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	artist := r.URL.Query().Get(artistParam)
+	album := r.URL.Query().Get(albumParam)
+
+	if artist == "" || album == "" {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	stagedPath, err := readCoverForm(w, r, requestId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("cover %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	a, err := managers.AlbumCoverStoreManager(artist, album, stagedPath)
+	if errors.Is(err, managers.ErrAlbumNotFound) {
+		api.Api.BuildErrorResponse(http.StatusNotFound, err.Error(), w)
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("cover %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(http.StatusInternalServerError, "failed to store the cover", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "cover stored", a, w)
+}
+
+// the image of the form, streamed to the staging area
+func readCoverForm(w http.ResponseWriter, r *http.Request, requestId string) (string, error) {
+	var stagedPath string
+
+	r.Body = http.MaxBytesReader(w, r.Body, managers.ImageMaxSize()+maxFormOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return stagedPath, errors.New("error parsing form")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			managers.FileUnstageManager(stagedPath)
+			return "", errors.New("error parsing form")
+		}
+
+		if part.FormName() == imageParam {
+			if stagedPath != "" {
+				err = errors.New("only one image expected")
+			} else if stagedPath, err = managers.ImageStageManager(requestId, part); err != nil {
+				err = errors.New("error getting image: " + err.Error())
+			}
+		}
+
+		part.Close()
+		if err != nil {
+			managers.FileUnstageManager(stagedPath)
+			return "", err
+		}
+	}
+
+	if stagedPath == "" {
+		return "", errors.New("error getting image")
+	}
+
+	return stagedPath, nil
+}
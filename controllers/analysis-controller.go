@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"net/http"
+)
+
+// POST
+// Authorization: 	token
+// Params: 			None
+// Body: 			None
+
+// analyse the files stored before the loudness analysis existed
+func AnalysisBackfillStart(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	if !managers.AnalysisBackfillStartManager() {
+		api.Api.BuildErrorResponse(http.StatusConflict, "backfill already running", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "backfill started",
+		managers.AnalysisBackfillStatusManager(), w)
+}
+
+// GET
+// Authorization: 	token
+// Params: 			None
+// Body: 			None
+
+// get the progress of the last backfill
+func AnalysisBackfillStatus(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "backfill status retrieved",
+		managers.AnalysisBackfillStatusManager(), w)
+}
+
+// GET
+// Authorization: 	token
+// Params: 			None
+// Body: 			None
+
+// list the tracks likely to be the same recording
+func FingerprintDuplicates(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	l, err := managers.FingerprintDuplicatesManager()
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusInternalServerError, "failed to list duplicates", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "duplicates listed", l, w)
+}
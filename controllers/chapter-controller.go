@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"net/http"
+	"strconv"
+)
+
+const (
+	positionParam = "position_ms"
+)
+
+// GET
+// Authorization: 	token
+// Params: 			id, or title, artist
+// Body: 			None
+
+// list the chapters of a track
+func ChapterGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	t, ok := readTrack(w, r, false)
+	if !ok {
+		return
+	}
+
+	l, err := managers.ChapterListManager(t.Title, t.Artist)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to list the chapters", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "chapters listed", l, w)
+}
+
+// GET
+// Authorization: 	token
+// Params: 			id, or title, artist
+// Body: 			None
+
+// get the position where the user stopped in a track
+func ResumeGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	t, ok := readTrack(w, r, false)
+	if !ok {
+		return
+	}
+
+	p, err := managers.ResumeGetManager(accessToken, t.Title, t.Artist)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the resume position", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "resume position retrieved", p, w)
+}
+
+// PUT
+// Authorization: 	token
+// Params: 			id, or title, artist, position_ms
+// Body: 			None
+
+// save the position where the user stopped in a track
+func ResumePut(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	t, ok := readTrack(w, r, false)
+	if !ok {
+		return
+	}
+
+	position := r.URL.Query().Get(positionParam)
+	if position == "" {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	positionMs, err := strconv.ParseInt(position, 10, 64)
+	if err != nil {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "invalid position", w)
+		return
+	}
+
+	p, err := managers.ResumeSaveManager(accessToken, t.Title, t.Artist, positionMs)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "failed to save the resume position", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "resume position saved", p, w)
+}
@@ -1,29 +1,67 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-api-utils/auth"
 	"github.com/Dadard29/go-warehouse/api"
 	"github.com/Dadard29/go-warehouse/managers"
 	"github.com/Dadard29/go-warehouse/models"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-// download is public
+const (
+	replayGainParam = "replaygain"
+	profileParam    = "profile"
+
+	// seconds
+	transcodeRetryAfter = "30"
+
+	// left as is in an extended parameter value
+	rfc5987AttrChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$&+-.^_`|~"
+)
+
+// GET
+// Authorization: 	None, token for the links bound to a user
+// Params: 			id, or title, artist, album,
+//					expires, kid, bound, signature (of a signed link, required if so configured),
+//					replaygain (optional, "true" to write the ReplayGain tags),
+//					profile (optional, "mp3-64", "mp3-96", "mp3-128" or "wav" to transcode the file)
+// Body: 			None
+
+// download is public, unless the signed links are required
 func DownloadGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if err := managers.DownloadVerifyManager(r.URL.Query(), accessToken); err != nil {
+		if !errors.Is(err, managers.ErrSignatureMissing) {
+			logger.Error(err.Error())
+		}
+		api.Api.BuildErrorResponse(http.StatusForbidden, err.Error(), w)
+		return
+	}
 
-	title := r.URL.Query().Get("title")
-	artist := r.URL.Query().Get("artist")
-	album := r.URL.Query().Get("album")
+	tags, ok := readTrack(w, r, true)
+	if !ok {
+		return
+	}
 
-	if title == "" || artist == "" || album == "" {
-		api.Api.BuildErrorResponse(http.StatusBadRequest, "missing parameter", w)
+	if profile := r.URL.Query().Get(profileParam); profile != "" {
+		downloadRendition(tags, profile, w, r)
 		return
 	}
 
-	p, err := managers.DownloadGetManager(models.Tags{
-		Title:  title,
-		Artist: artist,
-		Album:  album,
-	})
+	var p string
+	var temporary bool
+	var err error
+	if r.URL.Query().Get(replayGainParam) == "true" {
+		p, err = managers.DownloadReplayGainManager(tags)
+		temporary = true
+	} else {
+		p, temporary, err = managers.DownloadGetManager(tags)
+	}
 
 	if err != nil {
 		logger.Error(err.Error())
@@ -35,4 +73,156 @@ func DownloadGet(w http.ResponseWriter, r *http.Request) {
 	// w.WriteHeader(http.StatusOK)
 	http.ServeFile(w, r, p)
 
+	if temporary {
+		managers.DownloadReleaseManager(p)
+	}
+
+}
+
+// serve the file transcoded in the given profile
+func downloadRendition(tags models.Tags, profile string, w http.ResponseWriter, r *http.Request) {
+	contentType, ok := managers.DownloadProfileManager(profile)
+	if !ok {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "unknown profile", w)
+		return
+	}
+
+	p, cached, err := managers.DownloadRenditionManager(tags, profile)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error getting file", w)
+		return
+	}
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if cached {
+		http.ServeFile(w, r, p)
+		return
+	}
+
+	if !managers.DownloadAcquireTranscodeManager() {
+		w.Header().Set("Retry-After", transcodeRetryAfter)
+		api.Api.BuildErrorResponse(http.StatusServiceUnavailable, "too many files being transcoded", w)
+		return
+	}
+	defer managers.DownloadReleaseTranscodeManager()
+
+	// the response is streamed while transcoding, so errors can only be logged
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if err := managers.DownloadStreamRenditionManager(tags, profile, w); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// GET
+// Authorization: 	token
+// Params: 			id, or title, artist
+// Body: 			None
+
+// get a link to download the track, signed and expiring if keys are configured
+func DownloadLinkGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	t, ok := readTrack(w, r, false)
+	if !ok {
+		return
+	}
+
+	m, err := managers.FileDbGet(t.Title, t.Artist)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the music", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "download link created",
+		managers.DownloadLinkManager(accessToken, m.Id), w)
+}
+
+// the name of an attachment, with an ASCII fallback and the UTF-8 name given
+// apart (RFC 5987) when it is not printable ASCII
+func attachmentDisposition(name string) string {
+	var ascii strings.Builder
+	var encoded strings.Builder
+	for _, c := range []byte(name) {
+		if c >= 0x20 && c < 0x7F {
+			ascii.WriteByte(c)
+		} else if c < 0x80 || c >= 0xC0 {
+			// one replacement per character
+			ascii.WriteByte('_')
+		}
+
+		if strings.IndexByte(rfc5987AttrChars, c) >= 0 {
+			encoded.WriteByte(c)
+		} else {
+			encoded.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": ascii.String(),
+	})
+	if ascii.String() == name {
+		return disposition
+	}
+
+	return disposition + "; filename*=UTF-8''" + encoded.String()
+}
+
+// GET, HEAD
+// Authorization: 	token
+// Params: 			artist, album (optional, the whole discography if missing),
+//					or id (several times or comma separated, for a selection)
+// Body: 			None
+
+// stream a ZIP of the tracks with the covers of their albums and a playlist,
+// built on the fly, HEAD giving its exact size
+func DownloadZip(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	q := r.URL.Query()
+	var ids = make([]string, 0)
+	for _, v := range q[idParam] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	artist := q.Get(artistParam)
+	if len(ids) == 0 && artist == "" {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	z, err := managers.DownloadZipManager(artist, q.Get(albumParam), ids)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the tracks", w)
+		return
+	}
+	defer managers.DownloadZipReleaseManager(z)
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(z.Size, 10))
+	w.Header().Set("Content-Disposition", attachmentDisposition(z.Name))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// the response is streamed, so errors can only be logged
+	if err := managers.DownloadZipStreamManager(z, w); err != nil {
+		logger.Error(err.Error())
+	}
 }
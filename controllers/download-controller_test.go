@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestAttachmentDisposition(t *testing.T) {
+	var cases = []struct {
+		name     string
+		expected string
+	}{
+		{"Artist - Album.zip", `attachment; filename="Artist - Album.zip"`},
+		{"Björk - Début.zip", `attachment; filename="Bj_rk - D_but.zip"; filename*=UTF-8''Bj%C3%B6rk%20-%20D%C3%A9but.zip`},
+		{"AC/DC \"Live\".zip", `attachment; filename="AC/DC \"Live\".zip"`},
+	}
+
+	for _, c := range cases {
+		d := attachmentDisposition(c.name)
+		if d != c.expected {
+			t.Errorf("%q: got %s", c.name, d)
+		}
+
+		// the UTF-8 name is decoded by the clients supporting it
+		if _, params, err := mime.ParseMediaType(d); err != nil || params["filename"] != c.name {
+			t.Errorf("%q: parsed as %q, %v", c.name, params["filename"], err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"github.com/Dadard29/go-warehouse/models"
+	"net/http"
+)
+
+const (
+	urlParam   = "url"
+	genreParam = "genre"
+	yearParam  = "year"
+)
+
+func ingestErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, managers.ErrImportDenied):
+		return http.StatusForbidden
+	case errors.Is(err, managers.ErrTooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, managers.ErrBadType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, managers.ErrBadTags):
+		return http.StatusBadRequest
+	case errors.Is(err, managers.ErrDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, managers.ErrChecksumMismatch):
+		return http.StatusUnprocessableEntity
+	default:
+		return fallback
+	}
+}
+
+// POST
+// Authorization: 	token
+// Params: 			None
+// Headers: 		Digest, Content-MD5, X-Checksum-SHA256 (optional, checksums of the file)
+// Body: 			url, imageUrlParam, title, artist, album, genre, year (tags overrides, optional),
+//					duplicate (optional, reject, replace, keep_both or keep_best),
+//					digest, content_md5, checksum_sha256 (optional, checksums of the file)
+
+// fetch a file from a URL and create it in DB and FS
+func FileImportUrl(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	url := r.FormValue(urlParam)
+	m := models.MusicParam{
+		ImageUrl: r.FormValue(imageUrlParam),
+	}
+	if url == "" || !m.CheckSanity() {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	policy, ok := readDuplicatePolicy(w, r.FormValue(duplicateParam))
+	if !ok {
+		return
+	}
+
+	expected, err := readChecksums(r, func(field string) string {
+		return r.FormValue(field)
+	})
+	if err != nil {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	overrides := models.Tags{
+		Title:       r.FormValue(titleParam),
+		Artist:      r.FormValue(artistParam),
+		Album:       r.FormValue(albumParam),
+		PublishedAt: r.FormValue(yearParam),
+		Genre:       r.FormValue(genreParam),
+	}
+
+	stagedPath, err := managers.ImportUrlManager(requestId, url, overrides, expected)
+	if err != nil {
+		logger.Error(fmt.Sprintf("import %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(ingestErrorStatus(err, http.StatusBadGateway),
+			"error fetching file: "+err.Error(), w)
+		return
+	}
+
+	fileDb, err := managers.FileIngestManager(accessToken, policy, managers.FixedMusicParam(m), stagedPath)
+	if err != nil {
+		logger.Error(fmt.Sprintf("import %s: %s", requestId, err.Error()))
+		if buildDuplicateResponse(err, w) {
+			return
+		}
+		api.Api.BuildErrorResponse(ingestErrorStatus(err, http.StatusInternalServerError),
+			"error storing file: "+err.Error(), w)
+		return
+	}
+
+	msg := "file imported"
+	if len(fileDb.PossibleDuplicates) > 0 {
+		msg = "file imported, possible duplicates found"
+	}
+
+	api.Api.BuildJsonResponse(true, msg, fileDb, w)
+}
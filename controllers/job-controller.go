@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"net/http"
+)
+
+// GET
+// Authorization: 	token
+// Params: 			id
+// Body: 			None
+
+// get the state of an upload job, with the resulting track once done
+func JobGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	id := r.URL.Query().Get(idParam)
+	if id == "" {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	j, err := managers.JobGetManager(accessToken, id)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the job", w)
+		return
+	}
+	if j.Music != nil {
+		j.Music.DownloadUrl = managers.DownloadLinkManager(accessToken, j.Music.Id).Url
+	}
+
+	api.Api.BuildJsonResponse(true, "job retrieved", j, w)
+}
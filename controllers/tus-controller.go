@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/Dadard29/go-api-utils/auth"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"github.com/Dadard29/go-warehouse/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resumable uploads following the tus protocol 1.0.0 (https://tus.io), with the
+// creation, expiration, termination and checksum extensions
+// the location of an upload is this route with its id as parameter
+
+const (
+	idParam = "id"
+
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination,checksum"
+	tusOctets     = "application/offset+octet-stream"
+
+	// defined by the checksum extension
+	statusChecksumMismatch = 460
+
+	tusResumableHeader   = "Tus-Resumable"
+	tusVersionHeader     = "Tus-Version"
+	tusExtensionHeader   = "Tus-Extension"
+	tusMaxSizeHeader     = "Tus-Max-Size"
+	tusChecksumHeader    = "Tus-Checksum-Algorithm"
+	uploadLengthHeader   = "Upload-Length"
+	uploadDeferHeader    = "Upload-Defer-Length"
+	uploadOffsetHeader   = "Upload-Offset"
+	uploadMetaHeader     = "Upload-Metadata"
+	uploadExpireHeader   = "Upload-Expires"
+	uploadChecksumHeader = "Upload-Checksum"
+)
+
+// "key base64value,key2 base64value2", values being optional
+func parseTusMetadata(s string) map[string]string {
+	var res = make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		values := strings.Fields(pair)
+		if len(values) == 0 {
+			continue
+		}
+
+		var v []byte
+		if len(values) > 1 {
+			var err error
+			if v, err = base64.StdEncoding.DecodeString(values[1]); err != nil {
+				continue
+			}
+		}
+		res[values[0]] = string(v)
+	}
+
+	return res
+}
+
+// check the token and the version of the protocol used by the client
+func checkTus(w http.ResponseWriter, r *http.Request) (string, bool) {
+	w.Header().Set(tusResumableHeader, tusVersion)
+
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return accessToken, false
+	}
+
+	if r.Header.Get(tusResumableHeader) != tusVersion {
+		w.Header().Set(tusVersionHeader, tusVersion)
+		api.Api.BuildErrorResponse(http.StatusPreconditionFailed, "unsupported tus version", w)
+		return accessToken, false
+	}
+
+	return accessToken, true
+}
+
+func tusErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, managers.ErrChecksumMismatch):
+		return statusChecksumMismatch
+	case errors.Is(err, managers.ErrBadChecksum):
+		return http.StatusBadRequest
+	}
+
+	switch err {
+	case managers.ErrTusNotFound:
+		return http.StatusNotFound
+	case managers.ErrTusOffsetMismatch, managers.ErrTusDone:
+		return http.StatusConflict
+	case managers.ErrTusBusy:
+		return http.StatusLocked
+	case managers.ErrTusTooBig:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func setTusExpires(w http.ResponseWriter, expires time.Time) {
+	w.Header().Set(uploadExpireHeader, expires.UTC().Format(http.TimeFormat))
+}
+
+// OPTIONS
+// Authorization: 	None
+// Params: 			None
+// Body: 			None
+
+// describe the supported version and extensions
+func TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	w.Header().Set(tusVersionHeader, tusVersion)
+	w.Header().Set(tusExtensionHeader, tusExtensions)
+	w.Header().Set(tusMaxSizeHeader, strconv.FormatInt(managers.UploadMaxSize(), 10))
+	w.Header().Set(tusChecksumHeader, managers.TusChecksumAlgorithms)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST
+// Authorization: 	token
+// Params: 			None
+// Headers: 		Upload-Length, Upload-Metadata (with image_url, and duplicate optionally)
+// Body: 			None
+
+// create an upload, its location is returned
+func TusCreate(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := checkTus(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get(uploadDeferHeader) != "" {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "deferred length not supported", w)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get(uploadLengthHeader), 10, 64)
+	if err != nil || length <= 0 {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "invalid upload length", w)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get(uploadMetaHeader))
+	policy, ok := readDuplicatePolicy(w, metadata[duplicateParam])
+	if !ok {
+		return
+	}
+
+	m := models.MusicParam{
+		ImageUrl:  metadata[imageUrlParam],
+		Duplicate: policy,
+	}
+	if !m.CheckSanity() {
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	u, err := managers.TusCreateManager(accessToken, length, m)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(tusErrorStatus(err), "error creating upload", w)
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"?"+idParam+"="+u.Id)
+	setTusExpires(w, u.ExpiresAt)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HEAD
+// Authorization: 	token
+// Params: 			id
+// Body: 			None
+
+// get the offset to resume from
+func TusHead(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := checkTus(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := managers.TusGetManager(accessToken, r.URL.Query().Get(idParam))
+	if err != nil {
+		w.WriteHeader(tusErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(u.Length, 10))
+	setTusExpires(w, u.ExpiresAt)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET
+// Authorization: 	token
+// Params: 			id
+// Body: 			None
+
+// get the state of an upload, with the outcome of the processing once complete
+func TusGet(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	u, err := managers.TusGetManager(accessToken, r.URL.Query().Get(idParam))
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(tusErrorStatus(err), "failed to get the upload", w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "upload retrieved", u, w)
+}
+
+// PATCH
+// Authorization: 	token
+// Params: 			id
+// Headers: 		Upload-Offset, Content-Type (application/offset+octet-stream),
+//					Upload-Checksum (optional, "<algorithm> <base64>" of the chunk)
+// Body: 			chunk
+
+// append a chunk, the file is stored once the last one is received
+// a chunk not matching its checksum is discarded, with the status 460
+func TusPatch(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := checkTus(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusOctets {
+		api.Api.BuildErrorResponse(http.StatusUnsupportedMediaType, "bad content type", w)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "invalid upload offset", w)
+		return
+	}
+
+	var checksum *managers.ChunkChecksum
+	if v := r.Header.Get(uploadChecksumHeader); v != "" {
+		if checksum, err = managers.ParseChunkChecksum(v); err != nil {
+			api.Api.BuildErrorResponse(http.StatusBadRequest, err.Error(), w)
+			return
+		}
+	}
+
+	u, err := managers.TusPatchManager(accessToken, r.URL.Query().Get(idParam), offset, r.Body, checksum)
+	if err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(tusErrorStatus(err), "error receiving chunk", w)
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	setTusExpires(w, u.ExpiresAt)
+
+	if u.Error != "" {
+		api.Api.BuildErrorResponse(http.StatusUnprocessableEntity, "error storing file: "+u.Error, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE
+// Authorization: 	token
+// Params: 			id
+// Body: 			None
+
+// abort an upload
+func TusDelete(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := checkTus(w, r)
+	if !ok {
+		return
+	}
+
+	if err := managers.TusDeleteManager(accessToken, r.URL.Query().Get(idParam)); err != nil {
+		logger.Error(err.Error())
+		api.Api.BuildErrorResponse(tusErrorStatus(err), "error deleting upload", w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
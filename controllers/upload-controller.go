@@ -1,22 +1,200 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Dadard29/go-api-utils/auth"
 	"github.com/Dadard29/go-warehouse/api"
 	"github.com/Dadard29/go-warehouse/managers"
 	"github.com/Dadard29/go-warehouse/models"
+	"io"
+	"io/ioutil"
 	"net/http"
 )
 
 const (
 	fileParam     = "file"
+	cueParam      = "cue"
 	imageUrlParam = "image_url"
+	imageParam    = "image"
 	queryParam    = "q"
 
-	titleParam = "title"
+	titleParam  = "title"
 	artistParam = "artist"
+	albumParam  = "album"
+
+	// for the fields other than the file
+	maxFormValueSize = 1 << 10
+	maxFormOverhead  = 2 << 20
+
+	requestIdHeader = "X-Request-Id"
+
+	asyncParam     = "async"
+	duplicateParam = "duplicate"
+
+	digestParam         = "digest"
+	contentMd5Param     = "content_md5"
+	checksumSha256Param = "checksum_sha256"
 )
 
+// the form fields of the checksums, with the header of the same value
+var checksumFields = map[string]string{
+	digestParam:         managers.ChecksumDigest,
+	contentMd5Param:     managers.ChecksumContentMd5,
+	checksumSha256Param: managers.ChecksumSha256,
+}
+
+type uploadForm struct {
+	imageUrl   string
+	coverPath  string
+	hasCue     bool
+	sheet      models.CueSheet
+	filename   string
+	stagedPath string
+
+	// given by the client, and computed while staging the file
+	expected models.Digest
+	digest   models.Digest
+
+	// errors of the file and of the cue sheet, when lenient
+	failures []string
+}
+
+// remove the staged files of a form which will not be stored
+func (form uploadForm) unstage() {
+	managers.FileUnstageManager(form.stagedPath)
+	managers.FileUnstageManager(form.coverPath)
+}
+
+// read the multipart body part by part, the file being streamed to the staging
+// area instead of being held in memory
+// the staged file and cover are removed on error
+// when lenient, the file, the cover and the cue sheet failing their checks are
+// reported in the form instead
+// the checksums are verified once the whole form is read, they may be given
+// after the file
+func readUploadForm(w http.ResponseWriter, r *http.Request, requestId string, lenient bool) (uploadForm, error) {
+	var form uploadForm
+
+	expected, err := readChecksums(r, nil)
+	if err != nil {
+		return form, err
+	}
+	form.expected = expected
+
+	r.Body = http.MaxBytesReader(w, r.Body,
+		managers.UploadMaxSize()+managers.ImageMaxSize()+maxFormOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return form, errors.New("error parsing form")
+	}
+
+	var hasFile, hasImage bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.unstage()
+			return uploadForm{}, errors.New("error parsing form")
+		}
+
+		switch part.FormName() {
+		case fileParam:
+			if hasFile {
+				err = errors.New("only one file expected")
+				break
+			}
+			hasFile = true
+			form.filename = part.FileName()
+			form.stagedPath, form.digest, err = managers.FileStageManager(
+				requestId, part, part.Header.Get("Content-Type"))
+			if err != nil {
+				err = errors.New("error getting file: " + err.Error())
+			}
+
+		case digestParam, contentMd5Param, checksumSha256Param:
+			var v []byte
+			if v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValueSize)); err == nil {
+				err = managers.ParseChecksum(&form.expected, checksumFields[part.FormName()], string(v))
+			}
+
+		case cueParam:
+			// the cue sheet is checked before storing anything
+			form.hasCue = true
+			if form.sheet, err = managers.CueParseManager(part); err != nil {
+				err = errors.New("error parsing cue sheet: " + err.Error())
+			}
+
+		case imageUrlParam:
+			var v []byte
+			v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValueSize))
+			form.imageUrl = string(v)
+
+		case imageParam:
+			if hasImage {
+				err = errors.New("only one image expected")
+				break
+			}
+			hasImage = true
+			if form.coverPath, err = managers.ImageStageManager(requestId, part); err != nil {
+				err = errors.New("error getting image: " + err.Error())
+			}
+		}
+
+		part.Close()
+		if err != nil && lenient && part.FormName() != imageUrlParam {
+			form.failures = append(form.failures, err.Error())
+			continue
+		}
+		if err != nil {
+			form.unstage()
+			return uploadForm{}, err
+		}
+	}
+
+	if !hasFile {
+		form.unstage()
+		return uploadForm{}, errors.New("error getting file")
+	}
+
+	if form.stagedPath != "" {
+		if err := managers.FileVerifyManager(form.expected, form.digest); err != nil {
+			if lenient {
+				form.failures = append(form.failures, err.Error())
+				return form, nil
+			}
+
+			form.unstage()
+			return uploadForm{}, err
+		}
+	}
+
+	return form, nil
+}
+
+// the checksums of the file given in the headers, and in the form fields read
+// with value if not nil
+func readChecksums(r *http.Request, value func(string) string) (models.Digest, error) {
+	var expected models.Digest
+
+	for param, field := range checksumFields {
+		if err := managers.ParseChecksum(&expected, field, r.Header.Get(field)); err != nil {
+			return expected, err
+		}
+
+		if value == nil {
+			continue
+		}
+		if err := managers.ParseChecksum(&expected, field, value(param)); err != nil {
+			return expected, err
+		}
+	}
+
+	return expected, nil
+}
+
 // GET
 // Authorization: 	token
 // Params: 			None
@@ -45,6 +223,27 @@ func FileFsCheck(w http.ResponseWriter, r *http.Request) {
 // Params: 			None
 // Body: 			None
 
+// list the stored files not matching the checksum they were stored with
+func FileIntegrityCheck(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	l := managers.FileIntegrityManager()
+	if len(l) > 0 {
+		api.Api.BuildJsonResponse(false, fmt.Sprintf("%d files corrupted or missing", len(l)), l, w)
+		return
+	}
+
+	api.Api.BuildJsonResponse(true, "all files intact", l, w)
+}
+
+// GET
+// Authorization: 	token
+// Params: 			None
+// Body: 			None
+
 // get last added files from DB
 func FileGetListLastAdded(w http.ResponseWriter, r *http.Request) {
 	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
@@ -58,6 +257,7 @@ func FileGetListLastAdded(w http.ResponseWriter, r *http.Request) {
 		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error listing musics", w)
 		return
 	}
+	managers.DownloadLinksManager(accessToken, l)
 
 	api.Api.BuildJsonResponse(true, "files listed", l, w)
 }
@@ -110,7 +310,7 @@ func FileGetListArtists(w http.ResponseWriter, r *http.Request) {
 
 // DELETE
 // Authorization: 	token
-// Params: 			title, album, artist
+// Params: 			id, or title, album, artist
 // Body: 			None
 
 // remove file from DB and FS
@@ -121,99 +321,509 @@ func FileDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	title := r.URL.Query().Get("title")
-	artist := r.URL.Query().Get("artist")
-	album := r.URL.Query().Get("album")
-
-	if title == "" || artist == "" || album == "" {
-		api.Api.BuildErrorResponse(http.StatusBadRequest, "missing parameter", w)
+	t, ok := readTrack(w, r, true)
+	if !ok {
 		return
 	}
 
-	_, err := managers.FileDeleteManager(models.Tags{
-		Title:  title,
-		Artist: artist,
-		Album:  album,
-	})
+	fileDb, err := managers.FileDeleteManager(t)
 
 	if err != nil {
 		logger.Error(err.Error())
-		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error while deleting file", w)
+		api.Api.BuildErrorResponse(http.StatusInternalServerError, err.Error(), w)
 		return
 	}
 
-	fileDb, err := managers.FileDbDelete(title, artist)
+	api.Api.BuildJsonResponse(true, "file deleted", fileDb, w)
+}
+
+// the track addressed by the request, by its id or by its natural key, the
+// album being required too by the endpoints working on the file
+// an error response is sent if missing
+func readTrack(w http.ResponseWriter, r *http.Request, withAlbum bool) (models.Tags, bool) {
+	q := r.URL.Query()
+
+	if id := q.Get(idParam); id != "" {
+		t, err := managers.FileDbTagsManager(id)
+		if err != nil {
+			api.Api.BuildErrorResponse(http.StatusNotFound, err.Error(), w)
+			return t, false
+		}
+		return t, true
+	}
+
+	t := models.Tags{
+		Title:  q.Get(titleParam),
+		Artist: q.Get(artistParam),
+		Album:  q.Get(albumParam),
+	}
+	if t.Title == "" || t.Artist == "" || (withAlbum && t.Album == "") {
+		api.Api.BuildMissingParameter(w)
+		return t, false
+	}
+
+	return t, true
+}
+
+// the duplicate policy of the request, an error response being sent if invalid
+func readDuplicatePolicy(w http.ResponseWriter, policy string) (string, bool) {
+	policy, err := managers.DuplicatePolicy(policy)
 	if err != nil {
-		logger.Error(err.Error())
-		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error while deleting file in db", w)
-		return
+		api.Api.BuildErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return policy, false
 	}
 
-	api.Api.BuildJsonResponse(true, "file deleted", fileDb, w)
+	return policy, true
+}
+
+// send the track an upload collided with, if the error is such a collision
+func buildDuplicateResponse(err error, w http.ResponseWriter) bool {
+	var dup *managers.DuplicateError
+	if !errors.As(err, &dup) {
+		return false
+	}
+
+	api.Api.BuildJsonResponse(false, err.Error(), dup.Collision, w)
+	return true
 }
 
 // POST
 // Authorization: 	token
-// Params: 			None
-// Body: 			fileParam, imageUrlParam
-
-// create file in DB and FS
+// Params: 			async (optional, true to get a job instead of waiting for the ingest),
+//					duplicate (optional, reject, replace, keep_both or keep_best)
+// Headers: 		Digest, Content-MD5, X-Checksum-SHA256 (optional, checksums of the file)
+// Body: 			fileParam, imageUrlParam or imageParam (jpg or png, stored as the cover of the album),
+//					cueParam (optional),
+//					digest, content_md5, checksum_sha256 (optional, checksums of the file)
+
+// create file in DB and FS, and the virtual tracks of the cue sheet if any
 func FileUpload(w http.ResponseWriter, r *http.Request) {
 	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
 	if !checkToken(accessToken, w) {
 		return
 	}
 
-	err := r.ParseMultipartForm(10 << 20)
+	policy, ok := readDuplicatePolicy(w, r.URL.Query().Get(duplicateParam))
+	if !ok {
+		return
+	}
+
+	// returned so that the client can match its upload with the logs
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	form, err := readUploadForm(w, r, requestId, false)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
 		api.Api.BuildErrorResponse(
-			http.StatusBadRequest, "error parsing form", w)
+			ingestErrorStatus(err, http.StatusBadRequest), err.Error(), w)
 		return
 	}
 
-	imageUrl := r.Form.Get(imageUrlParam)
 	m := models.MusicParam{
-		ImageUrl: imageUrl,
+		ImageUrl:  form.imageUrl,
+		Duplicate: policy,
+		CoverPath: form.coverPath,
 	}
 	if !m.CheckSanity() {
+		form.unstage()
 		api.Api.BuildMissingParameter(w)
 		return
 	}
 
-	file, fileHeaders, err := r.FormFile(fileParam)
-	if err != nil {
-		logger.Error(err.Error())
-		api.Api.BuildErrorResponse(
-			http.StatusBadRequest, "error getting file", w)
+	// the ingest is done in the background, the job being polled
+	if r.URL.Query().Get(asyncParam) == "true" {
+		var sheet *models.CueSheet
+		if form.hasCue {
+			sheet = &form.sheet
+		}
+
+		job, err := managers.JobCreateManager(accessToken, m, form.stagedPath, sheet)
+		if err != nil {
+			logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+			api.Api.BuildErrorResponse(
+				http.StatusInternalServerError, "error creating job", w)
+			return
+		}
+
+		api.Api.BuildJsonResponse(true, "job created", job, w)
 		return
 	}
 
+	// copied once the album is known
+	defer managers.FileUnstageManager(form.coverPath)
+
 	// store file
-	fileStored, err := managers.FileStoreManager(file, fileHeaders, m)
+	fileStored, err := managers.FileStoreManager(form.stagedPath, m)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+		if buildDuplicateResponse(err, w) {
+			return
+		}
 		api.Api.BuildErrorResponse(
 			http.StatusInternalServerError, "error storing file", w)
 		return
 	}
 
 	// create in db
-	fileDb, err := managers.FileDbCreateManager(accessToken, m, fileStored.Metadata)
+	fileDb, err := managers.FileDbCreateManager(accessToken, m, fileStored)
 	if err != nil {
-		managers.FileDeleteManager(fileStored.Metadata)
-		logger.Error(err.Error())
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
 		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error storing file in db", w)
 		return
 	}
 
+	if form.hasCue {
+		t := fileStored.Metadata
+		fileDb.Tracks, err = managers.CueCreateManager(accessToken, m, t, form.sheet)
+		if err != nil {
+			err = managers.CueRollbackManager(t, err)
+			logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+
+			msg := "error creating the tracks of the cue sheet"
+			if errors.Is(err, managers.ErrCueRollback) {
+				msg += ", the file stored could not be removed"
+			}
+			api.Api.BuildErrorResponse(http.StatusInternalServerError, msg, w)
+			return
+		}
+	}
+
+	fileDb.DownloadUrl = managers.DownloadLinkManager(accessToken, fileDb.Id).Url
+	managers.DownloadLinksManager(accessToken, fileDb.Tracks)
+
+	msg := "file stored"
+	if len(fileDb.PossibleDuplicates) > 0 {
+		msg = "file stored, possible duplicates found"
+	}
+
 	api.Api.BuildJsonResponse(
-		true, "file stored", fileDb, w)
+		true, msg, fileDb, w)
+}
+
+// read a batch, the files being staged in the order of the request
+// the checksum fields following a file are the ones of this file, verified
+// once the whole form is read
+// the errors of a file are kept in its entry, only the errors of the request
+// itself are returned
+func readBatchForm(w http.ResponseWriter, r *http.Request, requestId string) (string, []managers.StagedFile, error) {
+	var imageUrl string
+	var files = make([]managers.StagedFile, 0)
+
+	// given by the client, and computed while staging, per file
+	var expected = make([]models.Digest, 0)
+	var digests = make([]models.Digest, 0)
+
+	unstage := func() {
+		for _, f := range files {
+			managers.FileUnstageManager(f.Path)
+		}
+	}
+
+	maxBody := int64(managers.MaxBatchFiles())*managers.UploadMaxSize() + maxFormOverhead
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return imageUrl, nil, errors.New("error parsing form")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			unstage()
+			return imageUrl, nil, errors.New("error parsing form")
+		}
+
+		switch part.FormName() {
+		case fileParam:
+			f := managers.StagedFile{
+				Filename: part.FileName(),
+			}
+			var d models.Digest
+			if len(files) >= managers.MaxBatchFiles() {
+				f.Err = managers.ErrTooManyFiles
+			} else {
+				id := fmt.Sprintf("%s-%d", requestId, len(files))
+				f.Path, d, f.Err = managers.FileStageManager(id, part, part.Header.Get("Content-Type"))
+			}
+			files = append(files, f)
+			expected = append(expected, models.Digest{})
+			digests = append(digests, d)
+
+		case digestParam, contentMd5Param, checksumSha256Param:
+			if len(files) == 0 {
+				part.Close()
+				return imageUrl, nil, errors.New("checksum given before its file")
+			}
+
+			var v []byte
+			if v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValueSize)); err != nil {
+				part.Close()
+				unstage()
+				return imageUrl, nil, errors.New("error parsing form")
+			}
+
+			i := len(files) - 1
+			err = managers.ParseChecksum(&expected[i], checksumFields[part.FormName()], string(v))
+			if err != nil && files[i].Err == nil {
+				managers.FileUnstageManager(files[i].Path)
+				files[i].Path = ""
+				files[i].Err = err
+			}
+
+		case imageUrlParam:
+			var v []byte
+			if v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValueSize)); err != nil {
+				part.Close()
+				unstage()
+				return imageUrl, nil, errors.New("error parsing form")
+			}
+			imageUrl = string(v)
+		}
+
+		part.Close()
+	}
+
+	if len(files) == 0 {
+		return imageUrl, nil, errors.New("error getting files")
+	}
+
+	for i := range files {
+		if files[i].Err != nil {
+			continue
+		}
+
+		if err := managers.FileVerifyManager(expected[i], digests[i]); err != nil {
+			managers.FileUnstageManager(files[i].Path)
+			files[i].Path = ""
+			files[i].Err = err
+		}
+	}
+
+	return imageUrl, files, nil
+}
+
+// POST
+// Authorization: 	token
+// Params: 			duplicate (optional, reject, replace, keep_both or keep_best)
+// Headers: 		Digest, Content-MD5, X-Checksum-SHA256 (optional, checksums of the file)
+// Body: 			fileParam, imageUrlParam or imageParam (jpg or png, stored as the cover of the album),
+//					cueParam (optional),
+//					digest, content_md5, checksum_sha256 (optional, checksums of the file)
+
+// report what an upload of the file would do, without storing anything
+func FilePreview(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	policy, ok := readDuplicatePolicy(w, r.URL.Query().Get(duplicateParam))
+	if !ok {
+		return
+	}
+
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	form, err := readUploadForm(w, r, requestId, true)
+	if err != nil {
+		logger.Error(fmt.Sprintf("preview %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(
+			http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	var sheet *models.CueSheet
+	if form.hasCue {
+		sheet = &form.sheet
+	}
+
+	defer managers.FileUnstageManager(form.coverPath)
+
+	p := managers.FilePreviewManager(form.filename, form.stagedPath, models.MusicParam{
+		ImageUrl:  form.imageUrl,
+		Duplicate: policy,
+		CoverPath: form.coverPath,
+	}, sheet, form.failures)
+
+	msg := "upload would succeed"
+	if !p.Valid {
+		msg = "upload would fail"
+	}
+
+	api.Api.BuildJsonResponse(true, msg, p, w)
+}
+
+// POST
+// Authorization: 	token
+// Params: 			duplicate (optional, reject, replace, keep_both or keep_best)
+// Body: 			fileParam (several times), imageUrlParam,
+//					digest, content_md5, checksum_sha256 (optional, after the file they are the checksums of)
+
+// create several files in DB and FS, with the result of each of them
+func FileUploadBatch(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	policy, ok := readDuplicatePolicy(w, r.URL.Query().Get(duplicateParam))
+	if !ok {
+		return
+	}
+
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	imageUrl, files, err := readBatchForm(w, r, requestId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(
+			http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	m := models.MusicParam{
+		ImageUrl: imageUrl,
+	}
+	if !m.CheckSanity() {
+		for _, f := range files {
+			managers.FileUnstageManager(f.Path)
+		}
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	res := managers.FileBatchManager(accessToken, policy, managers.FixedMusicParam(m), files)
+
+	var stored int
+	for _, f := range res {
+		if f.Status == models.BatchStored {
+			stored++
+		}
+	}
+
+	api.Api.BuildJsonResponse(true,
+		fmt.Sprintf("%d of %d files stored", stored, len(res)), res, w)
+}
+
+// read the archive form, the archive being streamed to the staging area
+func readArchiveForm(w http.ResponseWriter, r *http.Request, requestId string) (string, string, string, error) {
+	var imageUrl, archivePath, kind string
+
+	r.Body = http.MaxBytesReader(w, r.Body, managers.ArchiveMaxSize()+maxFormOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", "", "", errors.New("error parsing form")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			managers.FileUnstageManager(archivePath)
+			return "", "", "", errors.New("error parsing form")
+		}
+
+		switch part.FormName() {
+		case fileParam:
+			if archivePath != "" {
+				err = errors.New("only one archive expected")
+				break
+			}
+			if archivePath, kind, err = managers.ArchiveStageManager(requestId, part); err != nil {
+				err = errors.New("error getting archive: " + err.Error())
+			}
+
+		case imageUrlParam:
+			var v []byte
+			v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValueSize))
+			imageUrl = string(v)
+		}
+
+		part.Close()
+		if err != nil {
+			managers.FileUnstageManager(archivePath)
+			return "", "", "", err
+		}
+	}
+
+	if archivePath == "" {
+		return "", "", "", errors.New("error getting archive")
+	}
+
+	return imageUrl, archivePath, kind, nil
+}
+
+// POST
+// Authorization: 	token
+// Params: 			duplicate (optional, reject, replace, keep_both or keep_best)
+// Body: 			fileParam (zip or tar archive), imageUrlParam (optional with an artwork in the archive)
+
+// create the files of an album archive in DB and FS, with the result of each entry
+// a cover.jpg or folder.png of the archive becomes the image of the album
+func FileUploadArchive(w http.ResponseWriter, r *http.Request) {
+	accessToken := auth.ParseApiKey(r, accessTokenKey, true)
+	if !checkToken(accessToken, w) {
+		return
+	}
+
+	policy, ok := readDuplicatePolicy(w, r.URL.Query().Get(duplicateParam))
+	if !ok {
+		return
+	}
+
+	requestId := managers.NewRequestId()
+	w.Header().Set(requestIdHeader, requestId)
+
+	imageUrl, archivePath, kind, err := readArchiveForm(w, r, requestId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(
+			http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	files, cover, err := managers.ArchiveExtractManager(requestId, archivePath, kind)
+	if err != nil {
+		logger.Error(fmt.Sprintf("upload %s: %s", requestId, err.Error()))
+		api.Api.BuildErrorResponse(
+			http.StatusBadRequest, "error extracting archive: "+err.Error(), w)
+		return
+	}
+	defer managers.FileUnstageManager(cover.Path)
+
+	if cover.Path == "" && imageUrl == "" {
+		for _, f := range files {
+			managers.FileUnstageManager(f.Path)
+		}
+		api.Api.BuildMissingParameter(w)
+		return
+	}
+
+	res := managers.FileBatchManager(accessToken, policy,
+		managers.ArchiveMusicParam(imageUrl, cover), files)
+
+	var stored int
+	for _, f := range res {
+		if f.Status == models.BatchStored {
+			stored++
+		}
+	}
+
+	api.Api.BuildJsonResponse(true,
+		fmt.Sprintf("%d of %d entries stored", stored, len(res)), res, w)
 }
 
 // GET
 // Authorization: 	token
-// Params: 			None
+// Params: 			id, or title, artist
 // Body: 			None
 
 // get a file object from DB
@@ -223,20 +833,18 @@ func FileGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	title := r.URL.Query().Get(titleParam)
-	artist := r.URL.Query().Get(artistParam)
-
-	if title == "" || artist == "" {
-		api.Api.BuildMissingParameter(w)
+	t, ok := readTrack(w, r, false)
+	if !ok {
 		return
 	}
 
-	m, err := managers.FileDbGet(title, artist)
+	m, err := managers.FileDbGet(t.Title, t.Artist)
 	if err != nil {
 		logger.Error(err.Error())
 		api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the music", w)
 		return
 	}
+	m.DownloadUrl = managers.DownloadLinkManager(accessToken, m.Id).Url
 
 	api.Api.BuildJsonResponse(true, "music retrieved", m, w)
 }
@@ -263,6 +871,7 @@ func FileSearch(w http.ResponseWriter, r *http.Request) {
 		api.Api.BuildErrorResponse(http.StatusInternalServerError, "error search for musics", w)
 		return
 	}
+	managers.DownloadLinksManager(accessToken, l)
 
 	api.Api.BuildJsonResponse(true, "search performed", l, w)
 
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/managers"
+	"net/http"
+	"strconv"
+)
+
+const (
+	resolutionParam = "resolution"
+	formatParam     = "format"
+
+	formatJson   = "json"
+	formatBinary = "binary"
+)
+
+// GET
+// Authorization: 	None
+// Params: 			id, or title, artist, album, resolution (samples per pixel, optional), format (json or binary, optional)
+// Body: 			None
+
+// get the peaks of a file to draw its waveform, public as the download
+func WaveformGet(w http.ResponseWriter, r *http.Request) {
+	tags, ok := readTrack(w, r, true)
+	if !ok {
+		return
+	}
+
+	resolution := managers.WaveformDefaultResolution
+	if v := r.URL.Query().Get(resolutionParam); v != "" {
+		var err error
+		resolution, err = strconv.Atoi(v)
+		if err != nil || !managers.CheckWaveformResolution(resolution) {
+			api.Api.BuildErrorResponse(http.StatusBadRequest, "invalid resolution", w)
+			return
+		}
+	}
+
+	format := r.URL.Query().Get(formatParam)
+	switch format {
+	case "", formatJson:
+		wf, err := managers.WaveformGetManager(tags, resolution)
+		if err != nil {
+			logger.Error(err.Error())
+			api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the waveform", w)
+			return
+		}
+
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		api.Api.BuildJsonResponse(true, "waveform retrieved", wf, w)
+
+	case formatBinary:
+		data, err := managers.WaveformGetBinaryManager(tags, resolution)
+		if err != nil {
+			logger.Error(err.Error())
+			api.Api.BuildErrorResponse(http.StatusNotFound, "failed to get the waveform", w)
+			return
+		}
+
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			logger.Error(err.Error())
+		}
+
+	default:
+		api.Api.BuildErrorResponse(http.StatusBadRequest, "invalid format", w)
+	}
+}
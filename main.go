@@ -7,8 +7,10 @@ import (
 	"github.com/Dadard29/go-subscription-connector/subChecker"
 	"github.com/Dadard29/go-warehouse/api"
 	"github.com/Dadard29/go-warehouse/controllers"
+	"github.com/Dadard29/go-warehouse/managers"
 	"github.com/Dadard29/go-warehouse/models"
 	"net/http"
+	"os"
 )
 
 var routes = service.RouteMapping{
@@ -17,7 +19,48 @@ var routes = service.RouteMapping{
 		MethodMapping: service.MethodMapping{
 			http.MethodPost:   controllers.FileUpload,
 			http.MethodDelete: controllers.FileDelete,
-			http.MethodGet: controllers.FileGet,
+			http.MethodGet:    controllers.FileGet,
+		},
+	},
+	"/upload/preview": service.Route{
+		Description: "check an upload without storing anything",
+		MethodMapping: service.MethodMapping{
+			http.MethodPost: controllers.FilePreview,
+		},
+	},
+	"/upload/batch": service.Route{
+		Description: "upload several files at once",
+		MethodMapping: service.MethodMapping{
+			http.MethodPost: controllers.FileUploadBatch,
+		},
+	},
+	"/upload/archive": service.Route{
+		Description: "upload an album archive",
+		MethodMapping: service.MethodMapping{
+			http.MethodPost: controllers.FileUploadArchive,
+		},
+	},
+	"/upload/url": service.Route{
+		Description: "import a file from a URL",
+		MethodMapping: service.MethodMapping{
+			http.MethodPost: controllers.FileImportUrl,
+		},
+	},
+	"/upload/tus": service.Route{
+		Description: "manage resumable uploads",
+		MethodMapping: service.MethodMapping{
+			http.MethodOptions: controllers.TusOptions,
+			http.MethodPost:    controllers.TusCreate,
+			http.MethodHead:    controllers.TusHead,
+			http.MethodGet:     controllers.TusGet,
+			http.MethodPatch:   controllers.TusPatch,
+			http.MethodDelete:  controllers.TusDelete,
+		},
+	},
+	"/job": service.Route{
+		Description: "get the state of an upload job",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.JobGet,
 		},
 	},
 	"/upload/list/last": service.Route{
@@ -27,13 +70,13 @@ var routes = service.RouteMapping{
 		},
 	},
 	"/upload/list/album": service.Route{
-		Description:   "manage the list of available albums",
+		Description: "manage the list of available albums",
 		MethodMapping: service.MethodMapping{
 			http.MethodGet: controllers.FileGetListAlbums,
 		},
 	},
 	"/upload/list/artist": service.Route{
-		Description:   "manage the list of available artist",
+		Description: "manage the list of available artist",
 		MethodMapping: service.MethodMapping{
 			http.MethodGet: controllers.FileGetListArtists,
 		},
@@ -50,6 +93,64 @@ var routes = service.RouteMapping{
 			http.MethodGet: controllers.DownloadGet,
 		},
 	},
+	"/download/zip": service.Route{
+		Description: "download an album, an artist or a selection as a ZIP",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet:  controllers.DownloadZip,
+			http.MethodHead: controllers.DownloadZip,
+		},
+	},
+	"/download/link": service.Route{
+		Description: "get a signed link to download a file",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.DownloadLinkGet,
+		},
+	},
+	"/album/cover": service.Route{
+		Description: "manage the cover of an album",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet:  controllers.AlbumCoverGet,
+			http.MethodPost: controllers.AlbumCoverUpload,
+		},
+	},
+	"/waveform": service.Route{
+		Description: "get the peaks of a file",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.WaveformGet,
+		},
+	},
+	"/chapters": service.Route{
+		Description: "get the chapters of a file",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.ChapterGet,
+		},
+	},
+	"/resume": service.Route{
+		Description: "manage the position where a user stopped in a file",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.ResumeGet,
+			http.MethodPut: controllers.ResumePut,
+		},
+	},
+	"/analysis/backfill": service.Route{
+		Description: "analyse the files stored before the loudness analysis",
+		MethodMapping: service.MethodMapping{
+			http.MethodPost: controllers.AnalysisBackfillStart,
+			http.MethodGet:  controllers.AnalysisBackfillStatus,
+		},
+	},
+	"/health/duplicates": service.Route{
+		Description: "list the likely duplicates",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.FingerprintDuplicates,
+		},
+	},
+	"/health/integrity": service.Route{
+		Description: "check the files against their checksums",
+		MethodMapping: service.MethodMapping{
+			http.MethodGet: controllers.FileIntegrityCheck,
+		},
+	},
 	"/health/conflicts": service.Route{
 		Description: "check for conflicts",
 		MethodMapping: service.MethodMapping{
@@ -63,7 +164,25 @@ var routes = service.RouteMapping{
 // - CORS_ORIGIN: ... (from dockerfile)
 
 // - HOST_SUB: host where to check the sub token
+
+// CONFIG:
+// - server.writeTimeout: seconds, counted from the end of the request headers
+//   to the end of the response, so it bounds the whole handler: reading the
+//   body of the uploads (sync, tus final PATCH, batches, archives), fetching
+//   the URL imports, and streaming the transcoded files and the ZIP archives
+//   It must stay above server.readTimeout and above the time the slowest
+//   client takes to download the largest archive, or the transfer is cut
+// - parsing.sandbox: "true" or "false", enabled by default on linux, the only
+//   system where it is supported. Without it the tags are parsed in process,
+//   and a parsing which times out cannot be stopped: the upload fails but the
+//   parsing keeps running on the staged file until it ends
+
 func main() {
+	// the tags of the uploads parsed in a sandboxed child process
+	if len(os.Args) > 1 && os.Args[1] == managers.TagParserCommand {
+		os.Exit(managers.RunTagParser(os.Stdin, os.Stdout))
+	}
+
 	api.Api = API.NewAPI(
 		"warehouse", "config/config.json", routes, true)
 
@@ -73,8 +192,67 @@ func main() {
 	api.Api.Logger.CheckErrFatal(err)
 	api.Api.Database = database.NewConnector(dbConfig, true, []interface{}{
 		models.MusicEntity{},
+		models.FingerprintEntity{},
+		models.ChapterEntity{},
+		models.ResumeEntity{},
+		models.TusUploadEntity{},
+		models.JobEntity{},
+		models.IntentEntity{},
 	})
 
+	// the tracks stored before they had an id
+	api.Api.Logger.CheckErrFatal(managers.InitMusicIds())
+
+	uploadConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "upload")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitUploadLimits(uploadConfig))
+
+	stagingConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "staging")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitStaging(stagingConfig))
+
+	tusConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "tus")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitTus(tusConfig))
+
+	archiveConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "archive")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitArchiveLimits(archiveConfig))
+
+	importConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "import")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitImportPolicy(importConfig))
+
+	renditionConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "renditions")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitRenditions(renditionConfig))
+
+	sanitizeConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "sanitize")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitSanitizePolicy(sanitizeConfig))
+
+	downloadsConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "downloads")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitDownloadSigning(downloadsConfig))
+
+	imagesConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "images")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitImages(imagesConfig))
+
+	parsingConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "parsing")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitParseLimits(parsingConfig))
+
+	// FS and DB agreeing again before anything is stored
+	intentsConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "intents")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitIntents(intentsConfig))
+
+	// last, the interrupted jobs starting again right away
+	jobsConfig, err := api.Api.Config.GetSubcategoryFromFile("api", "jobs")
+	api.Api.Logger.CheckErrFatal(err)
+	api.Api.Logger.CheckErrFatal(managers.InitJobs(jobsConfig))
+
 	api.Api.Service.Start()
 	api.Api.Service.Stop()
 }
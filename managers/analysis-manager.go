@@ -0,0 +1,88 @@
+package managers
+
+import (
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"sync"
+	"time"
+)
+
+var backfill = struct {
+	sync.Mutex
+	status models.BackfillDto
+}{}
+
+// start analysing the tracks stored before the current version of the analysis
+// return false if a backfill is already running
+func AnalysisBackfillStartManager() bool {
+	backfill.Lock()
+	defer backfill.Unlock()
+
+	if backfill.status.Running {
+		return false
+	}
+
+	backfill.status = models.BackfillDto{
+		Running:   true,
+		StartedAt: time.Now(),
+	}
+
+	go analysisBackfill()
+	return true
+}
+
+func AnalysisBackfillStatusManager() models.BackfillDto {
+	backfill.Lock()
+	defer backfill.Unlock()
+
+	return backfill.status
+}
+
+func analysisBackfill() {
+	type albumKey struct {
+		artist string
+		album  string
+	}
+	var albums = make(map[albumKey]bool)
+
+	for _, m := range repositories.MusicList() {
+		if m.Virtual || m.AnalysisVersion >= repositories.AnalysisVersion {
+			continue
+		}
+
+		a, err := repositories.AnalyseFile(m.ToTags())
+		if err == nil {
+			err = repositories.MusicUpdateAudio(m.Title, m.Artist, a)
+		}
+		if err == nil {
+			err = repositories.FingerprintSave(m.Title, m.Artist, a)
+		}
+		if err == nil {
+			err = repositories.ChapterSave(m.Title, m.Artist, a.Chapters)
+		}
+
+		backfill.Lock()
+		if err != nil {
+			logger.Error(err.Error())
+			backfill.status.Failed++
+		} else {
+			backfill.status.Processed++
+		}
+		backfill.Unlock()
+
+		if err == nil {
+			albums[albumKey{m.Artist, m.Album}] = true
+		}
+	}
+
+	for k := range albums {
+		if err := repositories.MusicUpdateAlbumLoudness(k.artist, k.album); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	backfill.Lock()
+	backfill.status.Running = false
+	backfill.status.FinishedAt = time.Now()
+	backfill.Unlock()
+}
@@ -0,0 +1,235 @@
+package managers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	archiveMaxMegaBytesKey          = "maxMegaBytes"
+	archiveMaxExtractedMegaBytesKey = "maxExtractedMegaBytes"
+	archiveMaxEntriesKey            = "maxEntries"
+	archiveMaxRatioKey              = "maxRatio"
+
+	coverMaxSize = 10 << (10 * 2)
+)
+
+// names of the album artwork, in order of preference
+var coverNames = []string{
+	"cover.jpg", "cover.jpeg", "cover.png",
+	"folder.jpg", "folder.jpeg", "folder.png",
+}
+
+var ErrArchiveTooBig = errors.New("archive too big once extracted")
+
+var archiveLimits = struct {
+	size      int64
+	extracted int64
+	entries   int
+	ratio     uint64
+}{}
+
+// setup the limits of the archive uploads from the config
+func InitArchiveLimits(config map[string]string) error {
+	var values = make(map[string]int64)
+	for _, k := range []string{archiveMaxMegaBytesKey, archiveMaxExtractedMegaBytesKey,
+		archiveMaxEntriesKey, archiveMaxRatioKey} {
+		v, err := strconv.ParseInt(config[k], 10, 64)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return errors.New("archive limit " + k + " must be positive")
+		}
+		values[k] = v
+	}
+
+	archiveLimits.size = values[archiveMaxMegaBytesKey] << (10 * 2)
+	archiveLimits.extracted = values[archiveMaxExtractedMegaBytesKey] << (10 * 2)
+	archiveLimits.entries = int(values[archiveMaxEntriesKey])
+	archiveLimits.ratio = uint64(values[archiveMaxRatioKey])
+	return nil
+}
+
+func ArchiveMaxSize() int64 {
+	return archiveLimits.size
+}
+
+// fails once the bytes extracted from the whole archive exceed the budget
+type budgetReader struct {
+	r         io.Reader
+	remaining *int64
+}
+
+func (b budgetReader) Read(p []byte) (int, error) {
+	if *b.remaining <= 0 {
+		return 0, ErrArchiveTooBig
+	}
+
+	if int64(len(p)) > *b.remaining {
+		p = p[:*b.remaining]
+	}
+
+	n, err := b.r.Read(p)
+	*b.remaining -= int64(n)
+	return n, err
+}
+
+// stream the uploaded archive to the staging area
+func ArchiveStageManager(requestId string, file io.Reader) (string, string, error) {
+	header := make([]byte, repositories.SniffHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+	header = header[:n]
+
+	extension, _, err := repositories.SniffType(header)
+	if err != nil {
+		return "", "", ErrBadType
+	}
+
+	switch extension {
+	case repositories.ArchiveZip, repositories.ArchiveTar, repositories.ArchiveTarGz:
+	default:
+		return "", "", fmt.Errorf("%w: not a zip or tar archive", ErrBadType)
+	}
+
+	p, err := repositories.StageFile(io.MultiReader(bytes.NewReader(header), file),
+		archiveLimits.size, requestId, extension)
+	if err == repositories.ErrFileTooBig {
+		return "", "", errFileTooBig(archiveLimits.size)
+	}
+
+	return p, extension, err
+}
+
+func isCoverName(name string) bool {
+	return contains(coverNames, strings.ToLower(path.Base(name)))
+}
+
+func contains(l []string, v string) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// extract the audio files and the artwork of the staged archive in staging
+// files of their own, the archive is removed
+// nothing is kept if the archive exceeds the limits
+func ArchiveExtractManager(requestId string, archivePath string, kind string) ([]StagedFile, StagedFile, error) {
+	var files = make([]StagedFile, 0)
+	var cover StagedFile
+
+	defer cleanTempFile(archivePath)
+
+	remaining := archiveLimits.extracted
+	err := repositories.WalkArchive(archivePath, kind, archiveLimits.ratio, func(name string, r io.Reader) error {
+		if len(files) >= archiveLimits.entries {
+			return errors.New(fmt.Sprintf(
+				"too many entries: maximum allowed is %d", archiveLimits.entries))
+		}
+
+		f := StagedFile{
+			Filename: name,
+		}
+		id := fmt.Sprintf("%s-%d", requestId, len(files))
+		budget := budgetReader{r: r, remaining: &remaining}
+
+		if f.Err = repositories.CheckArchivePath(name); f.Err != nil {
+			files = append(files, f)
+			return nil
+		}
+
+		if isCoverName(name) && cover.Path == "" {
+			f.Path, f.Err = repositories.StageFile(budget, coverMaxSize, id,
+				strings.TrimPrefix(strings.ToLower(path.Ext(name)), "."))
+			if f.Err == nil {
+				if _, err := repositories.CheckFileImage(f.Path); err != nil {
+					FileUnstageManager(f.Path)
+					f.Path = ""
+					f.Err = fmt.Errorf("%w: %s", ErrBadType, err.Error())
+				}
+			}
+
+			if f.Err == nil {
+				f.Status = models.BatchCover
+				cover = f
+				// removed once the files are stored, not with the list
+				f.Path = ""
+			}
+		} else if isCoverName(name) {
+			f.Status = models.BatchSkipped
+		} else {
+			f.Path, _, f.Err = FileStageManager(id, budget, "")
+		}
+
+		if errors.Is(f.Err, ErrArchiveTooBig) {
+			return ErrArchiveTooBig
+		}
+		if f.Err == repositories.ErrFileTooBig {
+			f.Err = errFileTooBig(coverMaxSize)
+		}
+
+		files = append(files, f)
+		return nil
+	})
+
+	if err != nil {
+		for _, f := range files {
+			FileUnstageManager(f.Path)
+		}
+		FileUnstageManager(cover.Path)
+		return nil, StagedFile{}, err
+	}
+
+	return files, cover, nil
+}
+
+// parameters of the files of an archive, the artwork found in the archive
+// becoming the cover of the albums of the files
+func ArchiveMusicParam(imageUrl string, cover StagedFile) func(models.Tags) models.MusicParam {
+	var lock sync.Mutex
+	var stored = make(map[string]bool)
+
+	return func(t models.Tags) models.MusicParam {
+		if cover.Path == "" {
+			return models.MusicParam{
+				ImageUrl: imageUrl,
+			}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		key := t.Artist + "/" + t.Album
+		if !stored[key] {
+			extension, err := checkCover(cover.Path)
+			if err == nil {
+				err = repositories.StoreAlbumCover(t.Artist, t.Album, cover.Path, extension)
+			}
+			if err != nil {
+				logger.Error(err.Error())
+				return models.MusicParam{
+					ImageUrl: imageUrl,
+				}
+			}
+			stored[key] = true
+		}
+
+		return models.MusicParam{
+			ImageUrl: repositories.AlbumCoverUrl(t.Artist, t.Album),
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"sync"
+)
+
+// a file of a batch, staged or rejected while reading the request
+// the status is set for the files not to store
+type StagedFile struct {
+	Filename string
+	Path     string
+	Err      error
+	Status   string
+}
+
+// the same parameters for every file
+func FixedMusicParam(m models.MusicParam) func(models.Tags) models.MusicParam {
+	return func(models.Tags) models.MusicParam {
+		return m
+	}
+}
+
+var ErrTooManyFiles = fmt.Errorf("too many files: maximum allowed is %d", maxFilesNumber)
+
+func MaxBatchFiles() int {
+	return maxFilesNumber
+}
+
+func batchStatus(err error) string {
+	switch {
+	case err == nil:
+		return models.BatchStored
+	case errors.Is(err, ErrDuplicate):
+		return models.BatchDuplicate
+	case errors.Is(err, ErrBadTags):
+		return models.BatchBadTags
+	case errors.Is(err, ErrTooBig):
+		return models.BatchTooBig
+	case errors.Is(err, ErrBadType):
+		return models.BatchBadType
+	case errors.Is(err, ErrTooManyFiles):
+		return models.BatchTooMany
+	case errors.Is(err, repositories.ErrUnsafePath):
+		return models.BatchUnsafe
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, ErrBadChecksum):
+		return models.BatchChecksum
+	default:
+		return models.BatchError
+	}
+}
+
+// store the staged files, a few at a time as the analysis is CPU bound
+// each file gets its own result, in the order of the request
+// the parameters of a file are given from its tags, the duplicate policy
+// being the same for every file
+func FileBatchManager(token string, policy string, param func(models.Tags) models.MusicParam, files []StagedFile) []models.BatchResultDto {
+	var res = make([]models.BatchResultDto, len(files))
+	var wg sync.WaitGroup
+	slots := make(chan bool, uploadLimits.batchWorkers)
+
+	for i, file := range files {
+		res[i] = models.BatchResultDto{
+			Filename: file.Filename,
+		}
+
+		if file.Err != nil {
+			res[i].Status = batchStatus(file.Err)
+			res[i].Message = file.Err.Error()
+			continue
+		}
+
+		if file.Status != "" {
+			res[i].Status = file.Status
+			continue
+		}
+
+		wg.Add(1)
+		slots <- true
+		go func(i int, file StagedFile) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			music, err := FileIngestManager(token, policy, param, file.Path)
+			res[i].Status = batchStatus(err)
+			if err != nil {
+				logger.Error(fmt.Sprintf("%s: %s", file.Filename, err.Error()))
+				res[i].Message = err.Error()
+
+				var dup *DuplicateError
+				if errors.As(err, &dup) {
+					res[i].Collision = &dup.Collision
+				}
+				return
+			}
+			res[i].Music = &music
+		}(i, file)
+	}
+
+	wg.Wait()
+	return res
+}
+
+// store a staged file in FS and DB
+func FileIngestManager(token string, policy string, param func(models.Tags) models.MusicParam, p string) (models.MusicDto, error) {
+	var f models.MusicDto
+
+	stored, err := FileStoreManager(p, models.MusicParam{
+		Duplicate: policy,
+	})
+	if err != nil {
+		return f, err
+	}
+
+	m := param(stored.Metadata)
+	if !m.CheckSanity() {
+		FileDiscardManager(stored)
+		return f, errors.New("no image for the album")
+	}
+
+	music, err := FileDbCreateManager(token, m, stored)
+	if err != nil {
+		return f, err
+	}
+
+	return music, nil
+}
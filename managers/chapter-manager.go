@@ -0,0 +1,60 @@
+package managers
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"time"
+)
+
+func ChapterListManager(title string, artist string) ([]models.ChapterDto, error) {
+	if _, err := repositories.MusicGetFromTitle(title, artist); err != nil {
+		return nil, err
+	}
+
+	var res = make([]models.ChapterDto, 0)
+	for _, c := range repositories.ChapterList(title, artist) {
+		res = append(res, c.ToDto())
+	}
+
+	return res, nil
+}
+
+// the position is 0 if the user never saved one for the track
+func ResumeGetManager(token string, title string, artist string) (models.ResumeDto, error) {
+	var f models.ResumeDto
+
+	if _, err := repositories.MusicGetFromTitle(title, artist); err != nil {
+		return f, err
+	}
+
+	r, err := repositories.ResumeGet(token, title, artist)
+	if err != nil {
+		return models.ResumeDto{
+			Title:  title,
+			Artist: artist,
+		}, nil
+	}
+
+	return r.ToDto(), nil
+}
+
+func ResumeSaveManager(token string, title string, artist string, positionMs int64) (models.ResumeDto, error) {
+	var f models.ResumeDto
+
+	if positionMs < 0 {
+		return f, errors.New("invalid position")
+	}
+
+	if _, err := repositories.MusicGetFromTitle(title, artist); err != nil {
+		return f, err
+	}
+
+	r, err := repositories.ResumeSave(token, title, artist,
+		time.Duration(positionMs)*time.Millisecond)
+	if err != nil {
+		return f, err
+	}
+
+	return r.ToDto(), nil
+}
@@ -0,0 +1,192 @@
+package managers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"hash"
+	"io"
+	"strings"
+)
+
+// where the client gives the checksums of its file, as headers or form fields
+const (
+	ChecksumDigest     = "Digest"
+	ChecksumContentMd5 = "Content-MD5"
+	ChecksumSha256     = "X-Checksum-SHA256"
+
+	digestMd5    = "md5"
+	digestSha256 = "sha-256"
+
+	// the algorithms of the tus checksum extension, sha1 being required by it
+	TusChecksumAlgorithms = "sha1,md5,sha256"
+)
+
+var (
+	ErrBadChecksum      = errors.New("invalid checksum")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// add a checksum given by the client to the expected digest
+// Digest is formatted as in RFC 3230, "sha-256=<base64>,md5=<base64>", the
+// other algorithms being ignored, Content-MD5 is in base64 and
+// X-Checksum-SHA256 in hexadecimal or in base64
+func ParseChecksum(expected *models.Digest, field string, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	switch field {
+	case ChecksumDigest:
+		for _, d := range strings.Split(value, ",") {
+			values := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			if len(values) != 2 {
+				return fmt.Errorf("%w: %s", ErrBadChecksum, field)
+			}
+
+			var err error
+			switch strings.ToLower(values[0]) {
+			case digestMd5:
+				err = setChecksum(&expected.Md5, field, values[1], md5.Size, false)
+			case digestSha256:
+				err = setChecksum(&expected.Sha256, field, values[1], sha256.Size, false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case ChecksumContentMd5:
+		return setChecksum(&expected.Md5, field, value, md5.Size, false)
+	case ChecksumSha256:
+		return setChecksum(&expected.Sha256, field, value, sha256.Size, true)
+	}
+
+	return fmt.Errorf("%w: unknown field %s", ErrBadChecksum, field)
+}
+
+// decode the checksum in hexadecimal, the same one being possibly given twice
+func setChecksum(dest *string, field string, value string, size int, allowHex bool) error {
+	var sum []byte
+	if allowHex && len(value) == hex.EncodedLen(size) {
+		sum, _ = hex.DecodeString(value)
+	} else {
+		sum, _ = base64.StdEncoding.DecodeString(value)
+	}
+	if len(sum) != size {
+		return fmt.Errorf("%w: %s", ErrBadChecksum, field)
+	}
+
+	v := hex.EncodeToString(sum)
+	if *dest != "" && *dest != v {
+		return fmt.Errorf("%w: %s conflicts with another checksum", ErrBadChecksum, field)
+	}
+
+	*dest = v
+	return nil
+}
+
+// the checksum of a chunk of a resumable upload, the chunk being hashed while
+// it is written
+type ChunkChecksum struct {
+	hash hash.Hash
+	sum  []byte
+}
+
+// parse the Upload-Checksum header of the tus checksum extension, formatted as
+// "<algorithm> <base64>"
+func ParseChunkChecksum(value string) (*ChunkChecksum, error) {
+	values := strings.Fields(value)
+	if len(values) != 2 {
+		return nil, fmt.Errorf("%w: Upload-Checksum", ErrBadChecksum)
+	}
+
+	var h hash.Hash
+	switch values[0] {
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrBadChecksum, values[0])
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(values[1])
+	if err != nil || len(sum) != h.Size() {
+		return nil, fmt.Errorf("%w: Upload-Checksum", ErrBadChecksum)
+	}
+
+	return &ChunkChecksum{
+		hash: h,
+		sum:  sum,
+	}, nil
+}
+
+// what is written to the returned writer is hashed
+func (c *ChunkChecksum) Writer() io.Writer {
+	return c.hash
+}
+
+func (c *ChunkChecksum) Verify() error {
+	if actual := c.hash.Sum(nil); !bytes.Equal(actual, c.sum) {
+		return fmt.Errorf("%w: chunk expected %s, received %s", ErrChecksumMismatch,
+			base64.StdEncoding.EncodeToString(c.sum), base64.StdEncoding.EncodeToString(actual))
+	}
+	return nil
+}
+
+// compare the digest computed while staging a file with the one of the client
+func FileVerifyManager(expected models.Digest, actual models.Digest) error {
+	if expected.Sha256 != "" && expected.Sha256 != actual.Sha256 {
+		return fmt.Errorf("%w: sha-256 expected %s, received %s",
+			ErrChecksumMismatch, expected.Sha256, actual.Sha256)
+	}
+
+	if expected.Md5 != "" && expected.Md5 != actual.Md5 {
+		return fmt.Errorf("%w: md5 expected %s, received %s",
+			ErrChecksumMismatch, expected.Md5, actual.Md5)
+	}
+
+	return nil
+}
+
+// the stored files whose content changed since they were stored, or missing
+func FileIntegrityManager() []models.IntegrityDto {
+	var res = make([]models.IntegrityDto, 0)
+
+	for _, m := range repositories.MusicList() {
+		if m.Virtual || m.Sha256 == "" {
+			continue
+		}
+
+		var d = models.IntegrityDto{
+			Music: m.ToDto(),
+		}
+
+		p, err := repositories.GetFilePathForDownload(m.ToTags())
+		if err == nil {
+			d.Sha256, err = repositories.HashFile(p)
+		}
+
+		if err != nil {
+			d.Error = err.Error()
+		} else if d.Sha256 == m.Sha256 {
+			continue
+		}
+
+		res = append(res, d)
+	}
+
+	return res
+}
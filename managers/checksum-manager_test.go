@@ -0,0 +1,125 @@
+package managers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"io"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	content := []byte("content")
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	md5Hex := hex.EncodeToString(md5Sum[:])
+	sha256Hex := hex.EncodeToString(sha256Sum[:])
+	md5B64 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	sha256B64 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	otherSum := sha256.Sum256([]byte("other"))
+	otherB64 := base64.StdEncoding.EncodeToString(otherSum[:])
+
+	type field struct {
+		name  string
+		value string
+	}
+
+	var cases = []struct {
+		name     string
+		fields   []field
+		expected models.Digest
+		bad      bool
+	}{
+		{"none", []field{{ChecksumSha256, " "}}, models.Digest{}, false},
+		{"digest", []field{{ChecksumDigest, "SHA-256=" + sha256B64 + ", md5=" + md5B64}},
+			models.Digest{Md5: md5Hex, Sha256: sha256Hex}, false},
+		{"digest with other algorithms", []field{{ChecksumDigest, "sha=abc,sha-256=" + sha256B64}},
+			models.Digest{Sha256: sha256Hex}, false},
+		{"content-md5", []field{{ChecksumContentMd5, md5B64}}, models.Digest{Md5: md5Hex}, false},
+		{"sha256 in hexadecimal", []field{{ChecksumSha256, sha256Hex}}, models.Digest{Sha256: sha256Hex}, false},
+		{"sha256 in base64", []field{{ChecksumSha256, sha256B64}}, models.Digest{Sha256: sha256Hex}, false},
+		{"same checksum twice", []field{{ChecksumSha256, sha256Hex}, {ChecksumDigest, "sha-256=" + sha256B64}},
+			models.Digest{Sha256: sha256Hex}, false},
+		{"conflicting checksums", []field{{ChecksumSha256, sha256Hex}, {ChecksumDigest, "sha-256=" + otherB64}},
+			models.Digest{}, true},
+		{"content-md5 in hexadecimal", []field{{ChecksumContentMd5, md5Hex}}, models.Digest{}, true},
+		{"md5 as sha256", []field{{ChecksumSha256, md5Hex}}, models.Digest{}, true},
+		{"digest without value", []field{{ChecksumDigest, "sha-256"}}, models.Digest{}, true},
+		{"not base64", []field{{ChecksumDigest, "sha-256=***"}}, models.Digest{}, true},
+		{"unknown field", []field{{"X-Checksum-CRC32", "abcd"}}, models.Digest{}, true},
+	}
+
+	for _, c := range cases {
+		var d models.Digest
+		var err error
+		for _, f := range c.fields {
+			if err = ParseChecksum(&d, f.name, f.value); err != nil {
+				break
+			}
+		}
+
+		if c.bad {
+			if !errors.Is(err, ErrBadChecksum) {
+				t.Errorf("%s: expected an invalid checksum, got %v", c.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		} else if d != c.expected {
+			t.Errorf("%s: got %+v, expected %+v", c.name, d, c.expected)
+		}
+	}
+}
+
+func TestFileVerifyManager(t *testing.T) {
+	actual := models.Digest{Md5: "aa", Sha256: "bb"}
+
+	if err := FileVerifyManager(models.Digest{}, actual); err != nil {
+		t.Errorf("nothing expected: %s", err)
+	}
+	if err := FileVerifyManager(actual, actual); err != nil {
+		t.Errorf("same digest: %s", err)
+	}
+	if err := FileVerifyManager(models.Digest{Sha256: "cc"}, actual); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("sha-256 mismatch: got %v", err)
+	}
+	if err := FileVerifyManager(models.Digest{Md5: "cc", Sha256: "bb"}, actual); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("md5 mismatch: got %v", err)
+	}
+}
+
+func TestParseChunkChecksum(t *testing.T) {
+	chunk := []byte("chunk")
+	sha1Sum := sha1.Sum(chunk)
+	sha1B64 := base64.StdEncoding.EncodeToString(sha1Sum[:])
+
+	for _, v := range []string{"", "sha1", "crc32 AAAAAA==", "sha1 not-base64", "sha256 " + sha1B64} {
+		if _, err := ParseChunkChecksum(v); !errors.Is(err, ErrBadChecksum) {
+			t.Errorf("%q: expected an invalid checksum, got %v", v, err)
+		}
+	}
+
+	c, err := ParseChunkChecksum("sha1 " + sha1B64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(c.Writer(), bytes.NewReader(chunk)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(); err != nil {
+		t.Errorf("same chunk: %s", err)
+	}
+
+	c, _ = ParseChunkChecksum("sha1 " + sha1B64)
+	c.Writer().Write([]byte("other"))
+	if err := c.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("other chunk: got %v", err)
+	}
+}
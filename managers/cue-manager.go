@@ -0,0 +1,60 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"io/ioutil"
+)
+
+// the file stored with a cue sheet is kept after its tracks failed
+var ErrCueRollback = errors.New("the stored file could not be removed")
+
+func CueParseManager(file io.Reader) (models.CueSheet, error) {
+	var f models.CueSheet
+
+	// one more byte to detect the sheets too big
+	data, err := ioutil.ReadAll(io.LimitReader(file, repositories.CueMaxSize+1))
+	if err != nil {
+		return f, err
+	}
+
+	return repositories.ParseCue(data)
+}
+
+// create the virtual tracks of the file just stored
+func CueCreateManager(token string, m models.MusicParam, t models.Tags, sheet models.CueSheet) ([]models.MusicDto, error) {
+	parent, err := repositories.MusicGetFromTitle(t.Title, t.Artist)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cover of the file, stored with it if given
+	m.ImageUrl = parent.ImageUrl
+
+	l, err := repositories.MusicCreateVirtual(token, m, parent, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	var lDtos = make([]models.MusicDto, 0)
+	for _, v := range l {
+		lDtos = append(lDtos, v.ToDto())
+	}
+
+	return lDtos, nil
+}
+
+// remove the file just stored, its virtual tracks having failed
+// the returned error reports the removal first when it fails, so that it is
+// kept when the message is cut
+func CueRollbackManager(t models.Tags, cueErr error) error {
+	if _, err := FileDeleteManager(t); err != nil {
+		logger.Error(err.Error())
+		return fmt.Errorf("%w (%s), its tracks failing with: %s", ErrCueRollback, err.Error(), cueErr.Error())
+	}
+
+	return cueErr
+}
@@ -0,0 +1,34 @@
+package managers
+
+import (
+	"errors"
+	"github.com/Dadard29/go-api-utils/database"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"strings"
+	"testing"
+)
+
+func TestCueRollbackManager(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.MusicEntity{}, &models.IntentEntity{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(previous *database.Connector) { api.Api.Database = previous }(api.Api.Database)
+	api.Api.Database = &database.Connector{Orm: db}
+
+	// the stored file cannot be found anymore
+	cueErr := errors.New("invalid sheet")
+	err = CueRollbackManager(models.Tags{Title: "Live", Artist: "Artist", Album: "Concert"}, cueErr)
+	if !errors.Is(err, ErrCueRollback) || !strings.Contains(err.Error(), cueErr.Error()) {
+		t.Errorf("failed removal not reported: %v", err)
+	}
+}
@@ -1,10 +1,136 @@
 package managers
 
 import (
+	"errors"
 	"github.com/Dadard29/go-warehouse/models"
 	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"strconv"
 )
 
-func DownloadGetManager(tags models.Tags) (string, error) {
-	return repositories.GetFilePathForDownload(tags)
+const (
+	renditionMaxMegaBytesKey  = "maxMegaBytes"
+	renditionMaxTranscodesKey = "maxTranscodes"
+)
+
+// one slot per transcoding running, they are CPU bound
+var transcodeSlots chan bool
+
+// setup the cache of transcoded files and the number of files transcoded at
+// the same time from the config
+func InitRenditions(config map[string]string) error {
+	maxMegaBytes, err := strconv.ParseInt(config[renditionMaxMegaBytesKey], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if maxMegaBytes <= 0 {
+		return errors.New("renditions cache size must be positive")
+	}
+
+	maxTranscodes, err := strconv.Atoi(config[renditionMaxTranscodesKey])
+	if err != nil {
+		return err
+	}
+
+	if maxTranscodes <= 0 {
+		return errors.New("max transcodes must be positive")
+	}
+
+	transcodeSlots = make(chan bool, maxTranscodes)
+	return repositories.InitRenditionCache(maxMegaBytes << (10 * 2))
+}
+
+// return true if the file is temporary, in which case it must be released
+// with DownloadReleaseManager once served
+func DownloadGetManager(tags models.Tags) (string, bool, error) {
+	if m, err := repositories.MusicGetFromTitle(tags.Title, tags.Artist); err == nil && m.Virtual {
+		p, err := repositories.CutVirtualTrack(m)
+		return p, true, err
+	}
+
+	p, err := repositories.GetFilePathForDownload(tags)
+	return p, false, err
+}
+
+// get a temporary copy of the file with the ReplayGain tags written
+// it must be released with DownloadReleaseManager once served
+func DownloadReplayGainManager(tags models.Tags) (string, error) {
+	var p string
+
+	m, err := repositories.MusicGetFromTitle(tags.Title, tags.Artist)
+	if err != nil {
+		return p, err
+	}
+
+	return repositories.WriteReplayGainTags(m)
+}
+
+func DownloadReleaseManager(p string) {
+	cleanTempFile(p)
+}
+
+// content type of the files produced by the profile, false if it does not exist
+func DownloadProfileManager(profile string) (string, bool) {
+	p, ok := repositories.GetRenditionProfile(profile)
+	return p.ContentType, ok
+}
+
+// a virtual track is transcoded from the part of its parent file it covers
+func renditionSource(tags models.Tags) (repositories.RenditionSource, error) {
+	if m, err := repositories.MusicGetFromTitle(tags.Title, tags.Artist); err == nil && m.Virtual {
+		return repositories.NewVirtualRenditionSource(m)
+	}
+
+	p, err := repositories.GetFilePathForDownload(tags)
+	return repositories.RenditionSource{Path: p}, err
+}
+
+// get the cached rendition of the file in the given profile
+// return false if it has to be transcoded with DownloadStreamRenditionManager
+func DownloadRenditionManager(tags models.Tags, profile string) (string, bool, error) {
+	var f string
+
+	p, ok := repositories.GetRenditionProfile(profile)
+	if !ok {
+		return f, false, errors.New("unknown profile " + profile)
+	}
+
+	source, err := renditionSource(tags)
+	if err != nil {
+		return f, false, err
+	}
+
+	renditionPath, cached := repositories.GetRendition(source, p)
+	return renditionPath, cached, nil
+}
+
+// reserve a transcoding slot, false if they are all taken
+// it must be released with DownloadReleaseTranscodeManager once transcoded
+func DownloadAcquireTranscodeManager() bool {
+	select {
+	case transcodeSlots <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+func DownloadReleaseTranscodeManager() {
+	<-transcodeSlots
+}
+
+// transcode the file to w, the result is cached for the next downloads
+func DownloadStreamRenditionManager(tags models.Tags, profile string, w io.Writer) error {
+	p, ok := repositories.GetRenditionProfile(profile)
+	if !ok {
+		return errors.New("unknown profile " + profile)
+	}
+
+	source, err := renditionSource(tags)
+	if err != nil {
+		return err
+	}
+
+	return repositories.StreamRendition(source, p, w)
 }
@@ -0,0 +1,271 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"os"
+)
+
+var ErrBadDuplicatePolicy = errors.New("unknown duplicate policy")
+
+// an upload collided with a stored track, which is kept
+type DuplicateError struct {
+	Collision models.CollisionDto
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%s: %s by %s (%s)", ErrDuplicate.Error(),
+		e.Collision.Existing.Title, e.Collision.Existing.Artist, e.Collision.Action)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicate
+}
+
+// check the policy of a request, the configured one being used if empty
+func DuplicatePolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return duplicatePolicy, nil
+	case models.DuplicateReject, models.DuplicateReplace,
+		models.DuplicateKeepBoth, models.DuplicateKeepBest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrBadDuplicatePolicy, policy)
+	}
+}
+
+// what the policy does with a staged file
+type duplicatePlan struct {
+	// nil if the file is not already stored
+	collision *models.CollisionDto
+	existing  models.MusicEntity
+	version   int
+	// numbered title of the file, when both versions are kept
+	title string
+}
+
+// decide what the policy does if the staged file is already stored, without
+// changing anything, the error telling why it would not be stored
+func planDuplicate(tempFilePath string, tags models.Tags, policy string) (duplicatePlan, error) {
+	var plan duplicatePlan
+
+	existing, err := repositories.MusicGetFromTitle(tags.Title, tags.Artist)
+	if err != nil {
+		if repositories.FileExists(tags) {
+			// a file without its row, left to the recovery of the intents
+			return plan, fmt.Errorf("%w: %s by %s", ErrDuplicate, tags.Title, tags.Artist)
+		}
+		plan.version = 1
+		return plan, nil
+	}
+
+	// already being deleted or replaced, its file may be in the trash
+	if repositories.IntentPending(existing.Id) {
+		return plan, fmt.Errorf("%w: %s by %s", ErrDuplicate, tags.Title, tags.Artist)
+	}
+
+	policy, err = DuplicatePolicy(policy)
+	if err != nil {
+		return plan, err
+	}
+
+	c := &models.CollisionDto{
+		Policy:   policy,
+		Existing: existing.ToDto(),
+	}
+	plan.collision = c
+	plan.existing = existing
+
+	switch policy {
+	case models.DuplicateReject:
+		c.Action = models.CollisionRejected
+		return plan, &DuplicateError{Collision: *c}
+
+	case models.DuplicateKeepBoth:
+		plan.version, plan.title = nextVersion(tags)
+		c.Action = models.CollisionKeptBoth
+		return plan, nil
+
+	case models.DuplicateKeepBest:
+		better, err := betterQuality(tempFilePath, existing)
+		if err != nil {
+			return plan, err
+		}
+		if !better {
+			c.Action = models.CollisionKeptExisting
+			return plan, &DuplicateError{Collision: *c}
+		}
+	}
+
+	c.Action = models.CollisionReplaced
+	plan.version = existing.Version
+	if plan.version == 0 {
+		plan.version = 1
+	}
+	return plan, nil
+}
+
+// apply the policy if the staged file is already stored, and return the
+// collision, nil if there is none, and the version of the file
+// the tags are numbered when both versions are kept, and the replaced track
+// is returned, to be restored if the file is not stored
+// must be called with the ingest lock held
+func resolveDuplicate(tempFilePath string, tags *models.Tags, policy string) (*models.CollisionDto, int, *models.Replacement, error) {
+	plan, err := planDuplicate(tempFilePath, *tags, policy)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if plan.collision == nil {
+		return nil, plan.version, nil, nil
+	}
+
+	var replaced *models.Replacement
+	switch plan.collision.Action {
+	case models.CollisionKeptBoth:
+		if err := repositories.OverrideTags(tempFilePath, models.Tags{Title: plan.title}); err != nil {
+			return nil, 0, nil, fmt.Errorf("%w: %s", ErrBadTags, err.Error())
+		}
+		tags.Title = plan.title
+
+	case models.CollisionReplaced:
+		// both cannot be at the same place, the stored file is moved to the
+		// trash until the new one is in db
+		replaced, err = trashReplaced(plan.existing)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if repositories.FileExists(*tags) {
+			restoreReplaced(replaced)
+			return nil, 0, nil, fmt.Errorf("%w: %s by %s", ErrDuplicate, tags.Title, tags.Artist)
+		}
+	}
+
+	return plan.collision, plan.version, replaced, nil
+}
+
+// the row is deleted with the creation of the new one, by MusicCreate
+func trashReplaced(existing models.MusicEntity) (*models.Replacement, error) {
+	tags := existing.ToTags()
+
+	i, err := intentOpen(models.IntentDelete, tags, existing.Id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, errors.New("error while replacing file")
+	}
+
+	// virtual tracks only exist in DB
+	if !existing.Virtual {
+		if err := repositories.TrashFile(tags, i.TrashPath); err != nil {
+			intentClose(i.Id, true)
+			logger.Error(err.Error())
+			return nil, errors.New("error while replacing file")
+		}
+	}
+
+	return &models.Replacement{
+		Music:  existing,
+		Intent: i,
+	}, nil
+}
+
+// put the replaced file back, the new one not being stored
+func restoreReplaced(r *models.Replacement) {
+	if r == nil {
+		return
+	}
+
+	err := repositories.RestoreFile(r.Music.ToTags(), r.Intent.TrashPath)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	intentClose(r.Intent.Id, err == nil)
+}
+
+// the new file is in db, the rows derived from the replaced track and its
+// trashed file are removed, before the ones of the new file are created
+func settleReplaced(r *models.Replacement, stored models.Tags) {
+	if r == nil {
+		return
+	}
+
+	t := r.Music.ToTags()
+	fileDbDeleteDerived(t.Title, t.Artist, t.Album)
+
+	var err error
+	if t.Album == stored.Album {
+		err = repositories.RemoveReplacedFile(r.Intent.TrashPath)
+	} else {
+		err = repositories.RemoveTrashedFile(t, r.Intent.TrashPath)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	intentClose(r.Intent.Id, err == nil)
+}
+
+// the first numbered title neither in DB nor in FS
+func nextVersion(tags models.Tags) (int, string) {
+	t := tags
+	for version := 2; ; version++ {
+		t.Title = fmt.Sprintf("%s (%d)", tags.Title, version)
+		if _, err := repositories.MusicGetFromTitle(t.Title, t.Artist); err != nil &&
+			!repositories.FileExists(t) {
+			return version, t.Title
+		}
+	}
+}
+
+// lossless first, then the bitrate and the sample rate
+// the stored track is kept when both are the same
+func betterQuality(tempFilePath string, existing models.MusicEntity) (bool, error) {
+	tags := existing.ToTags()
+	if existing.Virtual {
+		tags = existing.ParentTags()
+	}
+
+	existingPath, err := repositories.GetFilePathForDownload(tags)
+	if err != nil {
+		return false, err
+	}
+
+	a, err := readQuality(tempFilePath)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrBadType, err.Error())
+	}
+
+	b, err := readQuality(existingPath)
+	if err != nil {
+		return false, err
+	}
+
+	if a.Lossless != b.Lossless {
+		return a.Lossless, nil
+	}
+	if a.Bitrate != b.Bitrate {
+		return a.Bitrate > b.Bitrate, nil
+	}
+	return a.SampleRate > b.SampleRate, nil
+}
+
+// the bitrate of the first frame is not the one of a VBR file, the average
+// one is used instead
+func readQuality(path string) (models.AudioProperties, error) {
+	p, err := repositories.ReadAudioProperties(path)
+	if err != nil {
+		return p, err
+	}
+
+	infos, err := os.Stat(path)
+	if err != nil {
+		return p, err
+	}
+
+	if p.Duration > 0 {
+		p.Bitrate = int(float64(infos.Size()) * 8 / p.Duration / 1000)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,64 @@
+package managers
+
+import (
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+)
+
+// tracks of the library likely to be the same recording, errors are only logged
+// as the upload should not fail because of them
+func fingerprintSearch(title string, artist string, a models.AudioInfo) []models.MusicDto {
+	var res = make([]models.MusicDto, 0)
+
+	matches, err := repositories.FingerprintSearch(title, artist, a)
+	if err != nil {
+		logger.Error(err.Error())
+		return res
+	}
+
+	for _, match := range matches {
+		m, err := repositories.MusicGetFromTitle(match.Second.Title, match.Second.Artist)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		res = append(res, m.ToDto())
+	}
+
+	return res
+}
+
+// report every pair of likely duplicates in the library
+func FingerprintDuplicatesManager() ([]models.DuplicateDto, error) {
+	var res = make([]models.DuplicateDto, 0)
+
+	matches, err := repositories.FingerprintDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range matches {
+		// the fingerprint of a track deleted while its derived rows were not
+		first, err := repositories.MusicGetFromTitle(match.First.Title, match.First.Artist)
+		if err != nil {
+			logger.Error(fmt.Sprintf("fingerprint of %s by %s: %s", match.First.Title, match.First.Artist, err.Error()))
+			continue
+		}
+
+		second, err := repositories.MusicGetFromTitle(match.Second.Title, match.Second.Artist)
+		if err != nil {
+			logger.Error(fmt.Sprintf("fingerprint of %s by %s: %s", match.Second.Title, match.Second.Artist, err.Error()))
+			continue
+		}
+
+		res = append(res, models.DuplicateDto{
+			First:      first.ToDto(),
+			Second:     second.ToDto(),
+			Similarity: match.Similarity,
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,190 @@
+package managers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	imagesMaxKiloBytesKey  = "maxKiloBytes"
+	imagesMinDimensionKey  = "minDimension"
+	imagesMaxDimensionKey  = "maxDimension"
+	imagesPublicBaseUrlKey = "publicBaseUrl"
+
+	coverSuffix = "-cover"
+)
+
+var (
+	ErrBadImage      = errors.New("invalid image")
+	ErrAlbumNotFound = errors.New("album not found")
+)
+
+var imageLimits = struct {
+	size         int64
+	minDimension int
+	maxDimension int
+}{}
+
+// setup the limits of the cover images from the config, and the URL they are
+// served from
+func InitImages(config map[string]string) error {
+	var values = make(map[string]int64)
+	for _, k := range []string{imagesMaxKiloBytesKey, imagesMinDimensionKey, imagesMaxDimensionKey} {
+		v, err := strconv.ParseInt(config[k], 10, 64)
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return errors.New("image limit " + k + " must be positive")
+		}
+		values[k] = v
+	}
+	if values[imagesMinDimensionKey] > values[imagesMaxDimensionKey] {
+		return errors.New("image min dimension above the max one")
+	}
+
+	imageLimits.size = values[imagesMaxKiloBytesKey] << 10
+	imageLimits.minDimension = int(values[imagesMinDimensionKey])
+	imageLimits.maxDimension = int(values[imagesMaxDimensionKey])
+
+	return repositories.InitImages(config[imagesPublicBaseUrlKey])
+}
+
+// the largest cover image accepted
+func ImageMaxSize() int64 {
+	return imageLimits.size
+}
+
+// stream the uploaded image to a staging file, checked as a cover
+func ImageStageManager(requestId string, file io.Reader) (string, error) {
+	var f string
+
+	header := make([]byte, repositories.SniffHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return f, err
+	}
+	header = header[:n]
+
+	extension, err := repositories.DetectImageFormat(header)
+	if err != nil {
+		return f, fmt.Errorf("%w: %s", ErrBadImage, err.Error())
+	}
+
+	tempFilePath, err := repositories.StageFile(io.MultiReader(bytes.NewReader(header), file),
+		imageLimits.size, requestId+coverSuffix, extension)
+	if err == repositories.ErrFileTooBig {
+		return f, fmt.Errorf("%w: larger than %d KB", ErrBadImage, imageLimits.size>>10)
+	}
+	if err != nil {
+		return f, err
+	}
+
+	if _, err := checkCover(tempFilePath); err != nil {
+		cleanTempFile(tempFilePath)
+		return f, err
+	}
+
+	return tempFilePath, nil
+}
+
+// extension of the image, if its type, size and dimensions fit a cover
+func checkCover(p string) (string, error) {
+	extension, err := repositories.CheckFileImage(p)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrBadImage, err.Error())
+	}
+
+	infos, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if infos.Size() > imageLimits.size {
+		return "", fmt.Errorf("%w: larger than %d KB", ErrBadImage, imageLimits.size>>10)
+	}
+
+	width, height, err := repositories.ImageDimensions(p)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrBadImage, err.Error())
+	}
+	for _, d := range []int{width, height} {
+		if d < imageLimits.minDimension || d > imageLimits.maxDimension {
+			return "", fmt.Errorf("%w: %dx%d, sides must be between %d and %d pixels", ErrBadImage,
+				width, height, imageLimits.minDimension, imageLimits.maxDimension)
+		}
+	}
+
+	return extension, nil
+}
+
+// the image of a track of the album: the staged cover given with it, stored
+// first, or the cover already stored for the album, the external URL otherwise
+func albumImageUrl(m models.MusicParam, t models.Tags) (string, error) {
+	if m.CoverPath != "" {
+		extension, err := checkCover(m.CoverPath)
+		if err != nil {
+			return "", err
+		}
+		if err := repositories.StoreAlbumCover(t.Artist, t.Album, m.CoverPath, extension); err != nil {
+			return "", err
+		}
+	}
+
+	if repositories.AlbumHasCover(t.Artist, t.Album) {
+		return repositories.AlbumCoverUrl(t.Artist, t.Album), nil
+	}
+
+	return m.ImageUrl, nil
+}
+
+// store the staged image as the cover of an existing album, linked to all its
+// tracks
+func AlbumCoverStoreManager(artist string, album string, stagedPath string) (models.AlbumDto, error) {
+	var f models.AlbumDto
+	defer cleanTempFile(stagedPath)
+
+	l := repositories.MusicListFromAlbum(artist, album)
+	if len(l) == 0 {
+		return f, ErrAlbumNotFound
+	}
+
+	imageUrl, err := albumImageUrl(models.MusicParam{
+		CoverPath: stagedPath,
+	}, models.Tags{
+		Artist: artist,
+		Album:  album,
+	})
+	if err != nil {
+		return f, err
+	}
+
+	if err := repositories.MusicUpdateAlbumImage(artist, album, imageUrl); err != nil {
+		return f, err
+	}
+
+	var titleList = make([]string, 0)
+	for _, m := range l {
+		titleList = append(titleList, m.Title)
+	}
+
+	return models.AlbumDto{
+		Name:      album,
+		TitleList: titleList,
+		Artist:    artist,
+		ImageURL:  imageUrl,
+	}, nil
+}
+
+// GET of the cover, from its id or from the album it is computed from
+func AlbumCoverGetManager(id string, artist string, album string) (string, error) {
+	if id == "" {
+		id = repositories.AlbumCoverId(artist, album)
+	}
+	return repositories.GetAlbumCoverPath(id)
+}
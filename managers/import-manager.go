@@ -0,0 +1,114 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	importAllowHostsKey    = "allowHosts"
+	importDenyHostsKey     = "denyHosts"
+	importAllowNetworksKey = "allowNetworks"
+	importDenyNetworksKey  = "denyNetworks"
+	importTimeoutKey       = "timeoutSeconds"
+	importMaxRedirectsKey  = "maxRedirects"
+)
+
+var ErrImportDenied = repositories.ErrImportDenied
+
+var importPolicy models.ImportPolicy
+
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var l = make([]*net.IPNet, 0)
+	for _, v := range parseList(s) {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, n)
+	}
+
+	return l, nil
+}
+
+// setup the policy applied to the URL imports from the config
+func InitImportPolicy(config map[string]string) error {
+	allowNetworks, err := parseNetworks(config[importAllowNetworksKey])
+	if err != nil {
+		return err
+	}
+
+	denyNetworks, err := parseNetworks(config[importDenyNetworksKey])
+	if err != nil {
+		return err
+	}
+
+	timeout, err := strconv.Atoi(config[importTimeoutKey])
+	if err != nil {
+		return err
+	}
+
+	maxRedirects, err := strconv.Atoi(config[importMaxRedirectsKey])
+	if err != nil {
+		return err
+	}
+
+	if timeout <= 0 || maxRedirects < 0 {
+		return errors.New("invalid import timeout or redirects")
+	}
+
+	importPolicy = models.ImportPolicy{
+		AllowHosts:    parseList(config[importAllowHostsKey]),
+		DenyHosts:     parseList(config[importDenyHostsKey]),
+		AllowNetworks: allowNetworks,
+		DenyNetworks:  denyNetworks,
+		Timeout:       time.Duration(timeout) * time.Second,
+		MaxRedirects:  maxRedirects,
+	}
+
+	return nil
+}
+
+// fetch the file to a staging file of its own, verify it against the expected
+// digest and write the given tags in it
+func ImportUrlManager(requestId string, url string, overrides models.Tags, expected models.Digest) (string, error) {
+	var tempFilePath string
+	var digest models.Digest
+
+	err := repositories.FetchUrl(url, importPolicy, func(body io.Reader, length int64) error {
+		if length > UploadMaxSize() {
+			return errFileTooBig(UploadMaxSize())
+		}
+
+		var err error
+		tempFilePath, digest, err = FileStageManager(requestId, body, "")
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := FileVerifyManager(expected, digest); err != nil {
+		cleanTempFile(tempFilePath)
+		return "", err
+	}
+
+	// the overrides are written before the upload checks the tag
+	if err := checkTagLimits(tempFilePath); err != nil {
+		cleanTempFile(tempFilePath)
+		return "", err
+	}
+
+	if err := repositories.OverrideTags(tempFilePath, overrides); err != nil {
+		cleanTempFile(tempFilePath)
+		return "", fmt.Errorf("%w: %s", ErrBadTags, err.Error())
+	}
+
+	return tempFilePath, nil
+}
@@ -0,0 +1,178 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	intentRecoverIntervalKey = "recoverIntervalMinutes"
+)
+
+// intents opened by this process and not closed yet, left to their owner
+var intents = struct {
+	sync.Mutex
+	active map[string]bool
+}{
+	active: make(map[string]bool),
+}
+
+// settle the intents left by the last run, nothing being in progress yet,
+// then periodically the ones whose owner failed to settle them
+func InitIntents(config map[string]string) error {
+	interval, err := strconv.Atoi(config[intentRecoverIntervalKey])
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.New("intent recovery interval must be positive")
+	}
+
+	if err := repositories.InitIntents(); err != nil {
+		return err
+	}
+
+	recoverIntents()
+	go func() {
+		for range time.Tick(time.Duration(interval) * time.Minute) {
+			recoverIntents()
+		}
+	}()
+
+	return nil
+}
+
+// record an operation before its first step, a deletion giving the id of the
+// deleted track
+func intentOpen(operation string, tags models.Tags, musicId string) (models.IntentEntity, error) {
+	id := NewRequestId()
+
+	// set before the row exists, so that the recovery never sees it unowned
+	intents.Lock()
+	intents.active[id] = true
+	intents.Unlock()
+
+	i, err := repositories.IntentCreate(models.IntentEntity{
+		Id:        id,
+		Operation: operation,
+		Title:     tags.Title,
+		Artist:    tags.Artist,
+		Album:     tags.Album,
+		MusicId:   musicId,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		intentRelease(id)
+		return i, err
+	}
+
+	return i, nil
+}
+
+// the row is removed once FS and DB agree, whether the operation is complete
+// or undone, and left to the recovery otherwise
+func intentClose(id string, settled bool) {
+	if settled {
+		if err := repositories.IntentDelete(id); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	intentRelease(id)
+}
+
+func intentRelease(id string) {
+	intents.Lock()
+	delete(intents.active, id)
+	intents.Unlock()
+}
+
+func recoverIntents() {
+	for _, i := range repositories.IntentList() {
+		intents.Lock()
+		active := intents.active[i.Id]
+		intents.Unlock()
+		if active {
+			continue
+		}
+
+		// no upload of the same track while it is settled
+		ingest.Lock()
+		err := recoverIntent(i)
+		ingest.Unlock()
+
+		if err != nil {
+			logger.Error(fmt.Sprintf("intent %s: %s", i.Id, err.Error()))
+			continue
+		}
+
+		if err := repositories.IntentDelete(i.Id); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+// an interrupted store is undone, an interrupted delete is undone as long as
+// the row exists and completed otherwise
+// a track being replaced has both, the deletion being settled first
+func recoverIntent(i models.IntentEntity) error {
+	t := i.Tags()
+	m, err := repositories.MusicGetFromTitle(t.Title, t.Artist)
+	inDb := err == nil
+
+	switch i.Operation {
+	case models.IntentStore:
+		// the row of a replaced track is not the one of the stored file
+		if inDb && m.Album == t.Album {
+			if !repositories.FileExists(t) {
+				logger.Info(fmt.Sprintf("intent %s: removing %s by %s from db, its file is missing",
+					i.Id, t.Title, t.Artist))
+				_, err := FileDbDelete(t.Title, t.Artist)
+				return err
+			}
+			return nil
+		}
+
+		if repositories.FileExists(t) {
+			logger.Info(fmt.Sprintf("intent %s: removing %s by %s from fs, it is not in db",
+				i.Id, t.Title, t.Artist))
+			_, err := repositories.RemoveFile(t)
+			return err
+		}
+		return nil
+
+	case models.IntentDelete:
+		if i.MusicId != "" {
+			_, err := repositories.MusicGetFromId(i.MusicId)
+			deleted := err != nil
+
+			if deleted && inDb {
+				// replaced by an upload, whose row and derived files are kept
+				logger.Info(fmt.Sprintf("intent %s: completing the replacement of %s by %s",
+					i.Id, t.Title, t.Artist))
+				if m.Album == t.Album {
+					return repositories.RemoveReplacedFile(i.TrashPath)
+				}
+				return repositories.RemoveTrashedFile(t, i.TrashPath)
+			}
+			inDb = !deleted
+		}
+
+		if inDb {
+			logger.Info(fmt.Sprintf("intent %s: restoring %s by %s", i.Id, t.Title, t.Artist))
+			return repositories.RestoreFile(t, i.TrashPath)
+		}
+
+		logger.Info(fmt.Sprintf("intent %s: completing the deletion of %s by %s",
+			i.Id, t.Title, t.Artist))
+		fileDbDeleteDerived(t.Title, t.Artist, t.Album)
+		return repositories.RemoveTrashedFile(t, i.TrashPath)
+	}
+
+	return errors.New("unknown intent operation " + i.Operation)
+}
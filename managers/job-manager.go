@@ -0,0 +1,226 @@
+package managers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	jobWorkersKey = "workers"
+
+	jobQueueSize = 1024
+
+	// progress once each step is done
+	jobProgressStarted = 5
+	jobProgressStored  = 70
+	jobProgressCreated = 90
+	jobProgressDone    = 100
+)
+
+var jobQueue chan string
+
+// start the workers from the config, and queue again the jobs interrupted by
+// the last stop
+func InitJobs(config map[string]string) error {
+	workers, err := strconv.Atoi(config[jobWorkersKey])
+	if err != nil {
+		return err
+	}
+	if workers <= 0 {
+		return errors.New("job workers must be positive")
+	}
+
+	if err := repositories.InitJobs(); err != nil {
+		return err
+	}
+
+	jobQueue = make(chan string, jobQueueSize)
+	for i := 0; i < workers; i++ {
+		go jobWorker()
+	}
+
+	for _, j := range repositories.JobListPending() {
+		// stopped after the file left the staging area
+		if _, err := os.Stat(j.StagedPath); err != nil {
+			jobFail(j.Id, errors.New("interrupted by a restart"))
+			FileUnstageManager(j.CoverPath)
+			continue
+		}
+
+		if err := repositories.JobUpdate(j.Id, map[string]interface{}{
+			"state":    models.JobQueued,
+			"stage":    "",
+			"progress": 0,
+		}); err != nil {
+			return err
+		}
+		enqueueJob(j.Id)
+	}
+
+	return nil
+}
+
+// never blocks the caller, even with a full queue
+func enqueueJob(id string) {
+	select {
+	case jobQueue <- id:
+	default:
+		go func() {
+			jobQueue <- id
+		}()
+	}
+}
+
+func jobWorker() {
+	for id := range jobQueue {
+		runJob(id)
+	}
+}
+
+func jobFail(id string, err error) {
+	logger.Error("job " + id + ": " + err.Error())
+	if err := repositories.JobUpdate(id, map[string]interface{}{
+		"state": models.JobFailed,
+		"error": truncateRunes(err.Error(), models.JobErrorMaxLength),
+	}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// the first characters of s, the column being counted in characters
+func truncateRunes(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+func jobProgress(id string, stage string, progress int) {
+	if err := repositories.JobUpdate(id, map[string]interface{}{
+		"state":    models.JobRunning,
+		"stage":    stage,
+		"progress": progress,
+	}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// same steps as the synchronous upload
+func runJob(id string) {
+	j, err := repositories.JobGet(id)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	// kept until then, the job being run again after a crash
+	defer FileUnstageManager(j.CoverPath)
+
+	m := models.MusicParam{
+		ImageUrl:  j.ImageUrl,
+		Duplicate: j.Duplicate,
+		CoverPath: j.CoverPath,
+	}
+
+	jobProgress(id, models.JobStageStore, jobProgressStarted)
+	stored, err := FileStoreManager(j.StagedPath, m)
+	if err != nil {
+		jobFail(id, err)
+		return
+	}
+
+	jobProgress(id, models.JobStageCatalog, jobProgressStored)
+	music, err := FileDbCreateManager(j.Owner, m, stored)
+	if err != nil {
+		jobFail(id, err)
+		return
+	}
+
+	if j.Cue != "" {
+		jobProgress(id, models.JobStageCue, jobProgressCreated)
+
+		var sheet models.CueSheet
+		err := json.Unmarshal([]byte(j.Cue), &sheet)
+		if err == nil {
+			_, err = CueCreateManager(j.Owner, m, stored.Metadata, sheet)
+		}
+		if err != nil {
+			jobFail(id, CueRollbackManager(stored.Metadata, err))
+			return
+		}
+	}
+
+	if err := repositories.JobUpdate(id, map[string]interface{}{
+		"state":    models.JobDone,
+		"stage":    "",
+		"progress": jobProgressDone,
+		"music_id": music.Id,
+	}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// queue the ingest of a staged file, the cue sheet being optional
+// the staged files belong to the job from then on
+func JobCreateManager(token string, m models.MusicParam, stagedPath string, sheet *models.CueSheet) (models.JobDto, error) {
+	var f models.JobDto
+
+	var cue []byte
+	if sheet != nil {
+		var err error
+		if cue, err = json.Marshal(sheet); err != nil {
+			FileUnstageManager(stagedPath)
+			FileUnstageManager(m.CoverPath)
+			return f, err
+		}
+	}
+
+	now := time.Now()
+	j, err := repositories.JobCreate(models.JobEntity{
+		Id:        NewRequestId(),
+		Owner:     token,
+		State:     models.JobQueued,
+		ImageUrl:  m.ImageUrl,
+		Duplicate: m.Duplicate,
+		Cue:       string(cue),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, stagedPath, m.CoverPath)
+	if err != nil {
+		FileUnstageManager(stagedPath)
+		FileUnstageManager(m.CoverPath)
+		return f, err
+	}
+
+	enqueueJob(j.Id)
+	return j.ToDto(), nil
+}
+
+// the state of a job of the user, with the resulting track once done
+func JobGetManager(token string, id string) (models.JobDto, error) {
+	var f models.JobDto
+
+	j, err := repositories.JobGet(id)
+	if err != nil || j.Owner != token {
+		return f, errors.New("job not found")
+	}
+
+	dto := j.ToDto()
+	if j.State == models.JobDone {
+		music, err := repositories.MusicGetFromId(j.MusicId)
+		if err != nil {
+			logger.Error(err.Error())
+		} else {
+			m := music.ToDto()
+			dto.Music = &m
+		}
+	}
+
+	return dto, nil
+}
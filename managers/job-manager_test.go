@@ -0,0 +1,71 @@
+package managers
+
+import (
+	"github.com/Dadard29/go-api-utils/database"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	if s := truncateRunes("short error", 255); s != "short error" {
+		t.Errorf("short message changed to %q", s)
+	}
+
+	// an error quoting a tag of multibyte characters
+	long := strings.Repeat("é", 300)
+	s := truncateRunes(long, 255)
+	if utf8.RuneCountInString(s) != 255 || !utf8.ValidString(s) || !strings.HasSuffix(s, "…") {
+		t.Errorf("message cut to %d characters: %q", utf8.RuneCountInString(s), s)
+	}
+}
+
+func TestJobGetManager(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.MusicEntity{}, &models.JobEntity{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(previous *database.Connector) { api.Api.Database = previous }(api.Api.Database)
+	api.Api.Database = &database.Connector{Orm: db}
+
+	// the second version of a track kept twice
+	if _, err := repositories.MusicCreate("token", models.MusicParam{}, models.File{
+		Metadata: models.Tags{Title: "Song", Artist: "Artist", Album: "Album"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := repositories.MusicCreate("token", models.MusicParam{}, models.File{
+		Metadata: models.Tags{Title: "Song (2)", Artist: "Artist", Album: "Album"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := models.JobEntity{Id: NewRequestId(), Owner: "token", State: models.JobDone, MusicId: kept.Id}
+	if err := db.Create(&j).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	dto, err := JobGetManager("token", j.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.Music == nil || dto.Music.Id != kept.Id {
+		t.Errorf("job resulting in %+v, expected %s", dto.Music, kept.Id)
+	}
+
+	if _, err := JobGetManager("other", j.Id); err == nil {
+		t.Errorf("job of another user retrieved")
+	}
+}
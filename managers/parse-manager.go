@@ -0,0 +1,257 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	parseMaxTagSizeKey     = "maxTagKiloBytes"
+	parseMaxFramesKey      = "maxFrames"
+	parseMaxPictureSizeKey = "maxPictureKiloBytes"
+	parseTimeoutKey        = "timeoutSeconds"
+	parseSandboxKey        = "sandbox"
+	parseSandboxMemoryKey  = "sandboxMemoryMegaBytes"
+
+	// first argument of the binary started as the tag parser
+	TagParserCommand = "parse-tags"
+
+	maxTagParserRequest = 1 << 20
+)
+
+// the tags of the uploaded files are untrusted, they are checked before being
+// parsed, and parsed in a limited time
+var parsing = struct {
+	limits  models.ParseLimits
+	timeout time.Duration
+	sandbox bool
+	memory  uint64
+}{}
+
+// sent to the tag parser on its standard input
+type tagParserRequest struct {
+	Path       string
+	Policy     models.SanitizePolicy
+	Memory     uint64
+	CpuSeconds uint64
+}
+
+// written by the tag parser on its standard output
+type tagParserResponse struct {
+	Tags  models.Tags
+	Error string
+}
+
+// setup the limits of the tags from the config, the parsing being done in a
+// child process if the sandbox is enabled, which it is by default where it is
+// supported
+func InitParseLimits(config map[string]string) error {
+	var values = make(map[string]int)
+	for _, k := range []string{
+		parseMaxTagSizeKey, parseMaxFramesKey, parseMaxPictureSizeKey, parseTimeoutKey,
+	} {
+		v, err := strconv.Atoi(config[k])
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("parsing %s must be positive", k)
+		}
+		values[k] = v
+	}
+
+	sandbox := sandboxSupported
+	if v := config[parseSandboxKey]; v != "" {
+		sandbox = v == "true"
+	}
+	var memory int
+	if sandbox {
+		if !sandboxSupported {
+			return errors.New("the tag parser sandbox is not supported on this system")
+		}
+
+		var err error
+		if memory, err = strconv.Atoi(config[parseSandboxMemoryKey]); err != nil {
+			return err
+		}
+		if memory <= 0 {
+			return errors.New("parsing sandbox memory must be positive")
+		}
+	}
+
+	parsing.limits = models.ParseLimits{
+		MaxTagSize:     values[parseMaxTagSizeKey] << 10,
+		MaxFrames:      values[parseMaxFramesKey],
+		MaxPictureSize: values[parseMaxPictureSizeKey] << 10,
+	}
+	parsing.timeout = time.Duration(values[parseTimeoutKey]) * time.Second
+	parsing.sandbox = sandbox
+	parsing.memory = uint64(memory) << (10 * 2)
+	return nil
+}
+
+// check the tag of a staged file is within the limits, before parsing it
+func checkTagLimits(p string) error {
+	if err := repositories.CheckTagLimits(p, parsing.limits); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadTags, err.Error())
+	}
+	return nil
+}
+
+// sanitize the tags of a staged file and read them, the tag being checked
+// first, then parsed in process or in the sandbox
+func parseTags(p string) (models.Tags, error) {
+	var f models.Tags
+
+	if err := checkTagLimits(p); err != nil {
+		return f, err
+	}
+
+	if parsing.sandbox {
+		return parseTagsSandboxed(p)
+	}
+	return parseTagsInProcess(p)
+}
+
+func sanitizeAndReadTags(p string, policy models.SanitizePolicy) (models.Tags, error) {
+	var f models.Tags
+
+	// applied before reading the tags, so that the library gets the normalized values
+	if err := repositories.SanitizeTags(p, policy); err != nil {
+		return f, fmt.Errorf("error sanitizing id3v2 tags: %s", err.Error())
+	}
+
+	tags, err := repositories.ReadRawTags(p)
+	if err != nil {
+		return f, fmt.Errorf("error reading id3v2 tags: %s", err.Error())
+	}
+
+	return tags, nil
+}
+
+// a parsing cannot be stopped in process, it is left to finish in background
+// once the upload gave up on it: it keeps its CPU and memory, and may still
+// write the staged file after the upload removed it, so the file is removed
+// again when it ends
+// only the sandbox bounds a hostile tag, this is the fallback where it is not
+// supported
+func parseTagsInProcess(p string) (models.Tags, error) {
+	var f models.Tags
+
+	type result struct {
+		tags models.Tags
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tags, err := sanitizeAndReadTags(p, sanitizePolicy)
+		done <- result{tags, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return f, fmt.Errorf("%w: %s", ErrBadTags, r.err.Error())
+		}
+		return r.tags, nil
+
+	case <-time.After(parsing.timeout):
+		logger.Error(fmt.Sprintf("parsing of %s timed out, left running in process", p))
+		go func() {
+			<-done
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				logger.Error(err.Error())
+			}
+		}()
+
+		return f, fmt.Errorf("%w: parsing timed out after %s", ErrBadTags, parsing.timeout)
+	}
+}
+
+// run the parser in a child process of the same binary, with its memory and
+// CPU time limited, and killed at the timeout
+func parseTagsSandboxed(p string) (models.Tags, error) {
+	var f models.Tags
+
+	executable, err := os.Executable()
+	if err != nil {
+		return f, err
+	}
+
+	req, err := json.Marshal(tagParserRequest{
+		Path:       p,
+		Policy:     sanitizePolicy,
+		Memory:     parsing.memory,
+		CpuSeconds: uint64(parsing.timeout/time.Second) + 1,
+	})
+	if err != nil {
+		return f, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), parsing.timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable, TagParserCommand)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	// nothing inherited, the credentials of the DB included
+	cmd.Env = []string{}
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return f, fmt.Errorf("%w: parsing timed out after %s", ErrBadTags, parsing.timeout)
+	}
+	if err != nil {
+		return f, fmt.Errorf("%w: tag parser failed: %s", ErrBadTags, err.Error())
+	}
+
+	var res tagParserResponse
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		return f, fmt.Errorf("%w: invalid tag parser response", ErrBadTags)
+	}
+	if res.Error != "" {
+		return f, fmt.Errorf("%w: %s", ErrBadTags, res.Error)
+	}
+
+	return res.Tags, nil
+}
+
+// entry point of the tag parser child process, returns its exit code
+func RunTagParser(in io.Reader, out io.Writer) int {
+	var req tagParserRequest
+	if err := json.NewDecoder(io.LimitReader(in, maxTagParserRequest)).Decode(&req); err != nil {
+		logger.Error(err.Error())
+		return 1
+	}
+
+	if err := applySandboxLimits(req.Memory, req.CpuSeconds); err != nil {
+		logger.Error(err.Error())
+		return 1
+	}
+
+	var res tagParserResponse
+	tags, err := sanitizeAndReadTags(req.Path, req.Policy)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Tags = tags
+
+	if err := json.NewEncoder(out).Encode(res); err != nil {
+		logger.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
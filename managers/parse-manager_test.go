@@ -0,0 +1,40 @@
+package managers
+
+import (
+	"testing"
+)
+
+func TestInitParseLimitsSandbox(t *testing.T) {
+	config := map[string]string{
+		parseMaxTagSizeKey:     "16",
+		parseMaxFramesKey:      "8",
+		parseMaxPictureSizeKey: "8",
+		parseTimeoutKey:        "1",
+		parseSandboxMemoryKey:  "64",
+	}
+
+	// enabled by default where it is supported
+	if err := InitParseLimits(config); err != nil {
+		t.Fatal(err)
+	}
+	if parsing.sandbox != sandboxSupported {
+		t.Errorf("sandbox %v by default, supported %v", parsing.sandbox, sandboxSupported)
+	}
+
+	config[parseSandboxKey] = "false"
+	if err := InitParseLimits(config); err != nil {
+		t.Fatal(err)
+	}
+	if parsing.sandbox {
+		t.Errorf("sandbox enabled while disabled in the config")
+	}
+
+	config[parseSandboxKey] = "true"
+	err := InitParseLimits(config)
+	if sandboxSupported && (err != nil || !parsing.sandbox) {
+		t.Errorf("sandbox not enabled: %v", err)
+	}
+	if !sandboxSupported && err == nil {
+		t.Errorf("unsupported sandbox enabled")
+	}
+}
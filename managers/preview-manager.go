@@ -0,0 +1,103 @@
+package managers
+
+import (
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"os"
+)
+
+// go through the checks of an upload on the staged file, without storing
+// anything, the staged file is removed
+// failures are the errors already found while reading the request
+func FilePreviewManager(filename string, stagedPath string, m models.MusicParam,
+	sheet *models.CueSheet, failures []string) models.PreviewDto {
+
+	var res = models.PreviewDto{
+		Filename: filename,
+		Failures: append(make([]string, 0), failures...),
+	}
+
+	if !m.CheckSanity() {
+		res.Failures = append(res.Failures, "image_url or image missing")
+	}
+
+	if sheet != nil {
+		res.CueTracks = len(sheet.Tracks)
+	}
+
+	if stagedPath != "" {
+		defer cleanTempFile(stagedPath)
+		previewFile(&res, stagedPath, m.Duplicate)
+	}
+
+	res.Valid = len(res.Failures) == 0
+	return res
+}
+
+func previewFile(res *models.PreviewDto, p string, policy string) {
+	if infos, err := os.Stat(p); err == nil {
+		res.Size = infos.Size()
+	}
+
+	if f, err := os.Open(p); err == nil {
+		header := make([]byte, repositories.SniffHeaderSize)
+		n, _ := io.ReadFull(f, header)
+		f.Close()
+		res.Extension, res.Mime = repositories.DetectAudioFormat(header[:n])
+	}
+
+	format, err := repositories.CheckFileAudio(p)
+	if err != nil {
+		res.Failures = append(res.Failures, "not an audio file: "+err.Error())
+		return
+	}
+	res.Extension, res.Mime = format, repositories.AudioMime(format)
+
+	if err := checkIngestFormat(format); err != nil {
+		res.Failures = append(res.Failures, err.Error())
+		return
+	}
+
+	// the file is a copy, it can be changed as the upload would
+	tags, err := parseTags(p)
+	if err != nil {
+		res.Failures = append(res.Failures, err.Error())
+		return
+	}
+	res.Title = tags.Title
+	res.Artist = tags.Artist
+	res.Album = tags.Album
+	res.PublishedAt = tags.PublishedAt
+	res.Genre = tags.Genre
+	res.Failures = append(res.Failures, repositories.CheckTags(tags)...)
+
+	if res.Audio, err = repositories.ReadAudioProperties(p); err != nil {
+		res.Failures = append(res.Failures, fmt.Sprintf("error decoding audio: %s", err.Error()))
+	}
+
+	if res.Gapless, err = repositories.ReadGapless(p); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if tags.Title == "" || tags.Artist == "" || tags.Album == "" {
+		return
+	}
+
+	res.FileConflict = repositories.FileExists(tags)
+	_, err = repositories.MusicGetFromTitle(tags.Title, tags.Artist)
+	res.MusicConflict = err == nil
+
+	// the conflicts are only failures if the policy would not resolve them
+	plan, err := planDuplicate(p, tags, policy)
+	res.Collision = plan.collision
+	if err != nil {
+		res.Failures = append(res.Failures, err.Error())
+	}
+	if plan.title != "" {
+		tags.Title = plan.title
+		res.Title = plan.title
+	}
+	res.Destination = repositories.GetStorePath(tags)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package managers
+
+import "syscall"
+
+const sandboxSupported = true
+
+// bound the address space and the CPU time of the current process, the kernel
+// killing it beyond
+func applySandboxLimits(memory uint64, cpuSeconds uint64) error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{
+		Cur: memory,
+		Max: memory,
+	}); err != nil {
+		return err
+	}
+
+	return syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{
+		Cur: cpuSeconds,
+		Max: cpuSeconds,
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package managers
+
+import "errors"
+
+const sandboxSupported = false
+
+func applySandboxLimits(memory uint64, cpuSeconds uint64) error {
+	return errors.New("sandbox not supported")
+}
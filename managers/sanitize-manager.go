@@ -0,0 +1,55 @@
+package managers
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	sanitizeKeepFramesKey     = "keepFrames"
+	sanitizeStripFramesKey    = "stripFrames"
+	sanitizeMaxPictureSizeKey = "maxPictureKiloBytes"
+	sanitizeVersionKey        = "version"
+)
+
+var sanitizePolicy models.SanitizePolicy
+
+func parseList(s string) []string {
+	var l = make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+// setup the policy applied to the tags of the uploaded files from the config
+func InitSanitizePolicy(config map[string]string) error {
+	maxPictureKiloBytes, err := strconv.Atoi(config[sanitizeMaxPictureSizeKey])
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if v := config[sanitizeVersionKey]; v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			return err
+		}
+	}
+	if version != 0 && version != 3 && version != 4 {
+		return errors.New("sanitize version must be 3 or 4")
+	}
+
+	sanitizePolicy = models.SanitizePolicy{
+		KeepFrames:     parseList(config[sanitizeKeepFramesKey]),
+		StripFrames:    parseList(config[sanitizeStripFramesKey]),
+		MaxPictureSize: maxPictureKiloBytes << 10,
+		Version:        byte(version),
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package managers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingKeysKey          = "signingKeys"
+	signingExpirationKey    = "expirationMinutes"
+	signingBindUserKey      = "bindUser"
+	signingRequireKey       = "requireSignature"
+	signingPublicBaseUrlKey = "publicBaseUrl"
+
+	// query of the signed links
+	signatureParam = "signature"
+	expiresParam   = "expires"
+	keyIdParam     = "kid"
+	boundParam     = "bound"
+	idParam        = "id"
+
+	downloadRoute = "/download"
+
+	signingMinKeySize = 32
+)
+
+var (
+	ErrSignatureMissing = errors.New("download link not signed")
+	ErrSignatureInvalid = errors.New("invalid download link signature")
+	ErrSignatureExpired = errors.New("download link expired")
+)
+
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+var signing = struct {
+	// the first one signs, all of them verify
+	keys       []signingKey
+	expiration time.Duration
+	bindUser   bool
+	require    bool
+	baseUrl    string
+}{}
+
+// setup the signature of the download links from the config
+// the keys are given as id:ENV_VAR, the secret being read from the environment
+// so that it is not kept with the config, removing a key revokes its links
+func InitDownloadSigning(config map[string]string) error {
+	minutes, err := strconv.Atoi(config[signingExpirationKey])
+	if err != nil {
+		return err
+	}
+	if minutes <= 0 {
+		return errors.New("download link expiration must be positive")
+	}
+
+	var keys = make([]signingKey, 0)
+	for _, v := range parseList(config[signingKeysKey]) {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errors.New("invalid signing key " + v + ", expected id:ENV_VAR")
+		}
+
+		secret := os.Getenv(parts[1])
+		if len(secret) < signingMinKeySize {
+			return errors.New("signing key " + parts[0] + " missing or shorter than " +
+				strconv.Itoa(signingMinKeySize) + " bytes")
+		}
+
+		for _, k := range keys {
+			if k.id == parts[0] {
+				return errors.New("duplicate signing key " + parts[0])
+			}
+		}
+		keys = append(keys, signingKey{
+			id:     parts[0],
+			secret: []byte(secret),
+		})
+	}
+
+	require := config[signingRequireKey] == "true"
+	if require && len(keys) == 0 {
+		return errors.New("signed downloads required without any signing key")
+	}
+
+	signing.keys = keys
+	signing.expiration = time.Duration(minutes) * time.Minute
+	signing.bindUser = config[signingBindUserKey] == "true"
+	signing.require = require
+	signing.baseUrl = strings.TrimSuffix(config[signingPublicBaseUrlKey], "/")
+	return nil
+}
+
+// the MAC of the link, the user being empty if the link is not bound to one
+func downloadSignature(key signingKey, id string, expires int64, user string) []byte {
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(strings.Join([]string{
+		key.id, id, strconv.FormatInt(expires, 10), user,
+	}, "\n")))
+
+	return mac.Sum(nil)
+}
+
+// the link to download the track, signed if keys are configured
+func DownloadLinkManager(token string, id string) models.DownloadLinkDto {
+	v := url.Values{}
+	v.Set(idParam, id)
+
+	if len(signing.keys) == 0 {
+		return models.DownloadLinkDto{
+			Url: signing.baseUrl + downloadRoute + "?" + v.Encode(),
+		}
+	}
+
+	key := signing.keys[0]
+	expiresAt := time.Now().Add(signing.expiration)
+
+	var user string
+	if signing.bindUser {
+		user = token
+		v.Set(boundParam, "true")
+	}
+
+	v.Set(expiresParam, strconv.FormatInt(expiresAt.Unix(), 10))
+	v.Set(keyIdParam, key.id)
+	v.Set(signatureParam, hex.EncodeToString(downloadSignature(key, id, expiresAt.Unix(), user)))
+
+	return models.DownloadLinkDto{
+		Url:       signing.baseUrl + downloadRoute + "?" + v.Encode(),
+		ExpiresAt: &expiresAt,
+	}
+}
+
+// set the download link of the tracks, and of their virtual tracks
+func DownloadLinksManager(token string, l []models.MusicDto) {
+	for i := range l {
+		if l[i].Id != "" {
+			l[i].DownloadUrl = DownloadLinkManager(token, l[i].Id).Url
+		}
+		DownloadLinksManager(token, l[i].Tracks)
+	}
+}
+
+// check the signature of a download, the token being the one of the user
+// downloading, used by the links bound to a user
+// the links are only optional if not required by the config
+func DownloadVerifyManager(q url.Values, token string) error {
+	signature := q.Get(signatureParam)
+	if signature == "" {
+		if signing.require {
+			return ErrSignatureMissing
+		}
+		return nil
+	}
+
+	mac, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	expires, err := strconv.ParseInt(q.Get(expiresParam), 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	var user string
+	if q.Get(boundParam) == "true" {
+		if token == "" {
+			return ErrSignatureInvalid
+		}
+		user = token
+	}
+
+	// a key removed from the config no longer verifies anything
+	kid := q.Get(keyIdParam)
+	for _, key := range signing.keys {
+		if key.id != kid {
+			continue
+		}
+
+		if !hmac.Equal(mac, downloadSignature(key, q.Get(idParam), expires, user)) {
+			return ErrSignatureInvalid
+		}
+		if time.Now().Unix() > expires {
+			return ErrSignatureExpired
+		}
+		return nil
+	}
+
+	return ErrSignatureInvalid
+}
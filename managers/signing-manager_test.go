@@ -0,0 +1,150 @@
+package managers
+
+import (
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSigningKeyEnv    = "WAREHOUSE_TEST_SIGNING_KEY"
+	testOldSigningKeyEnv = "WAREHOUSE_TEST_OLD_SIGNING_KEY"
+)
+
+func initTestSigning(t *testing.T, keys string, bindUser bool, require bool) {
+	os.Setenv(testSigningKeyEnv, strings.Repeat("k", signingMinKeySize))
+	os.Setenv(testOldSigningKeyEnv, strings.Repeat("o", signingMinKeySize))
+
+	err := InitDownloadSigning(map[string]string{
+		signingKeysKey:          keys,
+		signingExpirationKey:    "60",
+		signingBindUserKey:      strconv.FormatBool(bindUser),
+		signingRequireKey:       strconv.FormatBool(require),
+		signingPublicBaseUrlKey: "https://warehouse.example/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// query of the link made for the track
+func signedQuery(t *testing.T, token string, id string) url.Values {
+	link := DownloadLinkManager(token, id)
+
+	u, err := url.Parse(link.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "warehouse.example" || u.Path != downloadRoute {
+		t.Fatalf("unexpected link %s", link.Url)
+	}
+
+	return u.Query()
+}
+
+func TestDownloadVerifyManager(t *testing.T) {
+	initTestSigning(t, "new:"+testSigningKeyEnv+",old:"+testOldSigningKeyEnv, false, true)
+
+	q := signedQuery(t, "", "track")
+	if q.Get(keyIdParam) != "new" {
+		t.Errorf("signed with %s instead of the first key", q.Get(keyIdParam))
+	}
+	if err := DownloadVerifyManager(q, ""); err != nil {
+		t.Errorf("valid link: %s", err)
+	}
+
+	var cases = []struct {
+		name   string
+		change func(q url.Values)
+		err    error
+	}{
+		{"other track", func(q url.Values) { q.Set(idParam, "other") }, ErrSignatureInvalid},
+		{"extended", func(q url.Values) {
+			q.Set(expiresParam, strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+		}, ErrSignatureInvalid},
+		{"unknown key", func(q url.Values) { q.Set(keyIdParam, "removed") }, ErrSignatureInvalid},
+		{"other key", func(q url.Values) { q.Set(keyIdParam, "old") }, ErrSignatureInvalid},
+		{"not hexadecimal", func(q url.Values) { q.Set(signatureParam, "zz") }, ErrSignatureInvalid},
+		{"bound afterwards", func(q url.Values) { q.Set(boundParam, "true") }, ErrSignatureInvalid},
+		{"not signed", func(q url.Values) { q.Del(signatureParam) }, ErrSignatureMissing},
+	}
+
+	for _, c := range cases {
+		changed := url.Values{}
+		for k, v := range q {
+			changed[k] = append([]string(nil), v...)
+		}
+		c.change(changed)
+
+		if err := DownloadVerifyManager(changed, ""); err != c.err {
+			t.Errorf("%s: got %v, expected %v", c.name, err, c.err)
+		}
+	}
+
+	// the links of the keys still configured are valid until they expire
+	for _, expires := range []time.Duration{time.Hour, -time.Minute} {
+		key := signing.keys[1]
+		at := time.Now().Add(expires).Unix()
+
+		old := url.Values{}
+		old.Set(idParam, "track")
+		old.Set(keyIdParam, key.id)
+		old.Set(expiresParam, strconv.FormatInt(at, 10))
+		old.Set(signatureParam, hex.EncodeToString(downloadSignature(key, "track", at, "")))
+
+		err := DownloadVerifyManager(old, "")
+		if expires > 0 && err != nil {
+			t.Errorf("link of the old key: %s", err)
+		}
+		if expires < 0 && err != ErrSignatureExpired {
+			t.Errorf("expired link: got %v", err)
+		}
+	}
+}
+
+func TestDownloadVerifyManagerBound(t *testing.T) {
+	initTestSigning(t, "new:"+testSigningKeyEnv, true, false)
+
+	q := signedQuery(t, "alice-token", "track")
+	if q.Get(boundParam) != "true" {
+		t.Fatalf("link not bound to the user")
+	}
+
+	if err := DownloadVerifyManager(q, "alice-token"); err != nil {
+		t.Errorf("bound link used by its user: %s", err)
+	}
+	if err := DownloadVerifyManager(q, "bob-token"); err != ErrSignatureInvalid {
+		t.Errorf("bound link used by another user: got %v", err)
+	}
+	if err := DownloadVerifyManager(q, ""); err != ErrSignatureInvalid {
+		t.Errorf("bound link used without token: got %v", err)
+	}
+
+	// not required, the unsigned downloads are still served
+	if err := DownloadVerifyManager(url.Values{idParam: {"track"}}, ""); err != nil {
+		t.Errorf("unsigned download: %s", err)
+	}
+}
+
+func TestInitDownloadSigning(t *testing.T) {
+	os.Setenv(testSigningKeyEnv, strings.Repeat("k", signingMinKeySize))
+	os.Setenv(testOldSigningKeyEnv, "short")
+
+	var invalid = []map[string]string{
+		{signingKeysKey: "new:" + testSigningKeyEnv, signingExpirationKey: "0"},
+		{signingKeysKey: "new:" + testOldSigningKeyEnv, signingExpirationKey: "60"},
+		{signingKeysKey: testSigningKeyEnv, signingExpirationKey: "60"},
+		{signingKeysKey: "new:" + testSigningKeyEnv + ",new:" + testSigningKeyEnv, signingExpirationKey: "60"},
+		{signingKeysKey: "", signingExpirationKey: "60", signingRequireKey: "true"},
+	}
+
+	for _, config := range invalid {
+		if err := InitDownloadSigning(config); err == nil {
+			t.Errorf("config accepted: %v", config)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package managers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"strconv"
+	"time"
+)
+
+const (
+	stagingSweepIntervalKey = "sweepIntervalMinutes"
+	stagingMaxAgeKey        = "maxAgeMinutes"
+
+	requestIdBytes = 16
+)
+
+// identify an upload in the logs and in the name of its staging file
+func NewRequestId() string {
+	b := make([]byte, requestIdBytes)
+	if _, err := rand.Read(b); err != nil {
+		// the time is unique enough as a fallback
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// clean the temp dir now, nothing being in progress yet, then periodically
+// for the files older than the configured age
+func InitStaging(config map[string]string) error {
+	interval, err := strconv.Atoi(config[stagingSweepIntervalKey])
+	if err != nil {
+		return err
+	}
+
+	maxAge, err := strconv.Atoi(config[stagingMaxAgeKey])
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 || maxAge <= 0 {
+		return errors.New("staging sweep interval and max age must be positive")
+	}
+
+	removed, err := repositories.SweepTmp(0)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		logger.Info(fmt.Sprintf("removed %d stale files from the temp dir", removed))
+	}
+
+	go sweepTmp(time.Duration(interval)*time.Minute, time.Duration(maxAge)*time.Minute)
+	return nil
+}
+
+func sweepTmp(interval time.Duration, maxAge time.Duration) {
+	for range time.Tick(interval) {
+		removed, err := repositories.SweepTmp(maxAge)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		if removed > 0 {
+			logger.Info(fmt.Sprintf("removed %d stale files from the temp dir", removed))
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package managers
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	tusExpirationKey = "expirationHours"
+
+	tusSweepInterval = 10 * time.Minute
+)
+
+var (
+	ErrTusNotFound       = errors.New("upload not found")
+	ErrTusOffsetMismatch = errors.New("upload offset mismatch")
+	ErrTusBusy           = errors.New("upload already receiving a chunk")
+	ErrTusTooBig         = errors.New("upload too big")
+	ErrTusDone           = errors.New("upload already complete")
+
+	// recorded for an upload processed without its outcome being saved
+	errTusOutcomeLost = errors.New("upload processed, but its outcome was not recorded")
+)
+
+var tus = struct {
+	sync.Mutex
+	expiration time.Duration
+	// uploads receiving a chunk
+	busy map[string]bool
+}{
+	busy: make(map[string]bool),
+}
+
+// setup the resumable uploads from the config, and expire the unfinished ones
+func InitTus(config map[string]string) error {
+	hours, err := strconv.Atoi(config[tusExpirationKey])
+	if err != nil {
+		return err
+	}
+	if hours <= 0 {
+		return errors.New("tus expiration must be positive")
+	}
+
+	if err := repositories.InitTus(); err != nil {
+		return err
+	}
+
+	tus.expiration = time.Duration(hours) * time.Hour
+
+	go func() {
+		tusExpire()
+		for range time.Tick(tusSweepInterval) {
+			tusExpire()
+		}
+	}()
+
+	return nil
+}
+
+func tusExpire() {
+	for _, t := range repositories.TusListExpired(time.Now()) {
+		if !tusAcquire(t.Id) {
+			continue
+		}
+
+		if err := repositories.TusDelete(t.Id); err != nil {
+			logger.Error(err.Error())
+		}
+		tusRelease(t.Id)
+	}
+}
+
+func tusAcquire(id string) bool {
+	tus.Lock()
+	defer tus.Unlock()
+
+	if tus.busy[id] {
+		return false
+	}
+
+	tus.busy[id] = true
+	return true
+}
+
+func tusRelease(id string) {
+	tus.Lock()
+	defer tus.Unlock()
+
+	delete(tus.busy, id)
+}
+
+// the upload of the user, if not expired
+func tusGet(token string, id string) (models.TusUploadEntity, error) {
+	t, err := repositories.TusGet(id)
+	if err != nil || t.Owner != token || t.ExpiresAt.Before(time.Now()) {
+		return models.TusUploadEntity{}, ErrTusNotFound
+	}
+
+	return t, nil
+}
+
+func TusCreateManager(token string, length int64, m models.MusicParam) (models.TusUploadDto, error) {
+	var f models.TusUploadDto
+
+	if length > UploadMaxSize() {
+		return f, ErrTusTooBig
+	}
+
+	now := time.Now()
+	t := models.TusUploadEntity{
+		Id:        NewRequestId(),
+		Owner:     token,
+		Length:    length,
+		ImageUrl:  m.ImageUrl,
+		Duplicate: m.Duplicate,
+		CreatedAt: now,
+		ExpiresAt: now.Add(tus.expiration),
+	}
+
+	if err := repositories.TusCreate(t); err != nil {
+		return f, err
+	}
+
+	return t.ToDto(0), nil
+}
+
+func TusGetManager(token string, id string) (models.TusUploadDto, error) {
+	var f models.TusUploadDto
+
+	t, err := tusGet(token, id)
+	if err != nil {
+		return f, err
+	}
+
+	// the file is gone once processed
+	if t.Done {
+		return t.ToDto(t.Length), nil
+	}
+
+	offset, err := repositories.TusOffset(id)
+	if os.IsNotExist(err) {
+		// the last chunk may still be processed
+		if !tusAcquire(id) {
+			return f, ErrTusBusy
+		}
+		defer tusRelease(id)
+		return tusLost(id)
+	}
+	if err != nil {
+		return f, err
+	}
+
+	return t.ToDto(offset), nil
+}
+
+// an upload processed without its outcome being recorded has no partial file
+// anymore, it is completed with an error so that it is not left pending
+// must be called with the upload acquired
+func tusLost(id string) (models.TusUploadDto, error) {
+	var f models.TusUploadDto
+
+	t, err := repositories.TusGet(id)
+	if err != nil {
+		return f, err
+	}
+
+	if !t.Done {
+		if err := repositories.TusComplete(id, "", "", errTusOutcomeLost.Error()); err != nil {
+			logger.Error(err.Error())
+			return f, err
+		}
+		if t, err = repositories.TusGet(id); err != nil {
+			return f, err
+		}
+	}
+
+	return t.ToDto(t.Length), nil
+}
+
+// append a chunk at the given offset, and process the file once complete
+// a chunk not matching its checksum, if given, is discarded, as well as the
+// bytes of an interrupted one, which cannot be verified
+// the returned upload holds the error of the processing, if any
+func TusPatchManager(token string, id string, offset int64, chunk io.Reader, checksum *ChunkChecksum) (models.TusUploadDto, error) {
+	var f models.TusUploadDto
+
+	if !tusAcquire(id) {
+		return f, ErrTusBusy
+	}
+	defer tusRelease(id)
+
+	t, err := tusGet(token, id)
+	if err != nil {
+		return f, err
+	}
+	if t.Done {
+		return f, ErrTusDone
+	}
+
+	current, err := repositories.TusOffset(id)
+	if os.IsNotExist(err) {
+		if _, err := tusLost(id); err != nil {
+			return f, err
+		}
+		return f, ErrTusDone
+	}
+	if err != nil {
+		return f, err
+	}
+	if current != offset {
+		return f, ErrTusOffsetMismatch
+	}
+
+	if checksum != nil {
+		chunk = io.TeeReader(chunk, checksum.Writer())
+	}
+
+	n, err := repositories.TusWrite(id, chunk, t.Length-current)
+	if checksum != nil && err == nil {
+		err = checksum.Verify()
+	}
+	if checksum != nil && err != nil {
+		if truncateErr := repositories.TusTruncate(id, current); truncateErr != nil {
+			logger.Error(truncateErr.Error())
+			return f, truncateErr
+		}
+		if errors.Is(err, ErrChecksumMismatch) {
+			return f, err
+		}
+		n = 0
+	}
+
+	current += n
+	if err != nil {
+		logger.Error(err.Error())
+		return t.ToDto(current), nil
+	}
+
+	if current < t.Length {
+		return t.ToDto(current), nil
+	}
+
+	if t, err = tusFinalize(t); err != nil {
+		return f, err
+	}
+
+	return t.ToDto(current), nil
+}
+
+// same validation and cataloging as the regular uploads
+func tusFinalize(t models.TusUploadEntity) (models.TusUploadEntity, error) {
+	m := models.MusicParam{
+		ImageUrl:  t.ImageUrl,
+		Duplicate: t.Duplicate,
+	}
+
+	var message string
+	music, uploadErr := tusStore(t, m)
+	if uploadErr != nil {
+		logger.Error(uploadErr.Error())
+		message = truncateRunes(uploadErr.Error(), models.TusErrorMaxLength)
+		// the file is removed by the store, but not on every error
+		if err := repositories.TusRemovePart(t.Id); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	// the partial file is gone either way, the upload is completed by the next
+	// request if this fails
+	if err := repositories.TusComplete(t.Id, music.Title, music.Artist, message); err != nil {
+		logger.Error(err.Error())
+		return t, err
+	}
+
+	return repositories.TusGet(t.Id)
+}
+
+func tusStore(t models.TusUploadEntity, m models.MusicParam) (models.MusicDto, error) {
+	var f models.MusicDto
+
+	p := repositories.TusPath(t.Id)
+	file, err := os.Open(p)
+	if err != nil {
+		return f, err
+	}
+
+	header := make([]byte, repositories.SniffHeaderSize)
+	n, err := io.ReadFull(file, header)
+	file.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return f, err
+	}
+
+	_, limit, err := checkUploadType(header[:n], "")
+	if err != nil {
+		return f, err
+	}
+	if t.Length > limit {
+		return f, errFileTooBig(limit)
+	}
+
+	stored, err := FileStoreManager(p, m)
+	if err != nil {
+		return f, err
+	}
+
+	music, err := FileDbCreateManager(t.Owner, m, stored)
+	if err != nil {
+		return f, err
+	}
+
+	return music, nil
+}
+
+// termination, also removes the row of a complete upload
+func TusDeleteManager(token string, id string) error {
+	if !tusAcquire(id) {
+		return ErrTusBusy
+	}
+	defer tusRelease(id)
+
+	if _, err := tusGet(token, id); err != nil {
+		return err
+	}
+
+	return repositories.TusDelete(id)
+}
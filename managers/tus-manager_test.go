@@ -0,0 +1,74 @@
+package managers
+
+import (
+	"github.com/Dadard29/go-api-utils/database"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTusLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.TusUploadEntity{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(previous *database.Connector) { api.Api.Database = previous }(api.Api.Database)
+	api.Api.Database = &database.Connector{Orm: db}
+
+	if err := repositories.InitTus(); err != nil {
+		t.Fatal(err)
+	}
+	upload := models.TusUploadEntity{
+		Id:        NewRequestId(),
+		Owner:     "token",
+		Length:    10,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := repositories.TusCreate(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// processed, without the outcome recorded
+	if err := repositories.TusRemovePart(upload.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TusPatchManager("token", upload.Id, 0, nil, nil); err != ErrTusDone {
+		t.Errorf("chunk received by a lost upload: %v", err)
+	}
+
+	u, err := TusGetManager("token", upload.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Done || u.Error != errTusOutcomeLost.Error() || u.Offset != u.Length {
+		t.Errorf("lost upload not completed: %+v", u)
+	}
+}
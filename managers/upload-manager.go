@@ -1,27 +1,123 @@
 package managers
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/Dadard29/go-warehouse/models"
 	"github.com/Dadard29/go-warehouse/repositories"
-	"io/ioutil"
-	"mime/multipart"
+	"io"
 	"os"
-	"path"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
-	mimeMp3 = "audio/mpeg"
-
-	maxMegaBytes = 10
-	maxSize      = maxMegaBytes << (10 * 2)
+	uploadMaxMegaBytesKey        = "maxMegaBytes"
+	uploadDefaultMaxMegaBytesKey = "defaultMaxMegaBytes"
+	uploadBatchWorkersKey        = "batchWorkers"
+	uploadDuplicatePolicyKey     = "duplicatePolicy"
 
 	maxFilesNumber = 10
 
 	searchLimit = 10
 )
 
+// reasons of the upload failures, to report them per file
+var (
+	ErrBadType   = errors.New("bad mime")
+	ErrTooBig    = errors.New("file too big")
+	ErrBadTags   = errors.New("bad id3v2 tags")
+	ErrDuplicate = errors.New("file already stored")
+)
+
+// serializes the check for an existing file and its creation, so that two
+// uploads of the same track cannot both be stored
+var ingest sync.Mutex
+
+// maximum size of the uploaded files, per extension
+// and number of files of a batch processed at the same time
+var uploadLimits = struct {
+	formats      map[string]int64
+	fallback     int64
+	batchWorkers int
+}{}
+
+// the formats which can be stored, the other ones are only detected
+var ingestFormats = []string{models.TypeMp3}
+
+// applied when a request does not give its own
+var duplicatePolicy = models.DuplicateReject
+
+// setup the upload size limits from the config, formatted as "mp3:50,flac:500"
+// in megabytes, formats not listed get the default limit, the number of
+// batch workers and the default duplicate policy
+func InitUploadLimits(config map[string]string) error {
+	fallback, err := strconv.ParseInt(config[uploadDefaultMaxMegaBytesKey], 10, 64)
+	if err != nil {
+		return err
+	}
+	if fallback <= 0 {
+		return errors.New("upload default size limit must be positive")
+	}
+
+	var formats = make(map[string]int64)
+	for _, v := range parseList(config[uploadMaxMegaBytesKey]) {
+		values := strings.SplitN(v, ":", 2)
+		if len(values) != 2 {
+			return errors.New("invalid upload size limit " + v)
+		}
+
+		limit, err := strconv.ParseInt(strings.TrimSpace(values[1]), 10, 64)
+		if err != nil || limit <= 0 {
+			return errors.New("invalid upload size limit " + v)
+		}
+
+		formats[strings.ToLower(strings.TrimSpace(values[0]))] = limit << (10 * 2)
+	}
+
+	batchWorkers, err := strconv.Atoi(config[uploadBatchWorkersKey])
+	if err != nil {
+		return err
+	}
+	if batchWorkers <= 0 {
+		return errors.New("upload batch workers must be positive")
+	}
+
+	if config[uploadDuplicatePolicyKey] != "" {
+		policy, err := DuplicatePolicy(config[uploadDuplicatePolicyKey])
+		if err != nil {
+			return err
+		}
+		duplicatePolicy = policy
+	}
+
+	uploadLimits.formats = formats
+	uploadLimits.fallback = fallback << (10 * 2)
+	uploadLimits.batchWorkers = batchWorkers
+	return nil
+}
+
+func uploadLimit(extension string) int64 {
+	if l, ok := uploadLimits.formats[extension]; ok {
+		return l
+	}
+	return uploadLimits.fallback
+}
+
+// the largest limit of the formats which can be stored, to bound the whole
+// request, the other ones being rejected anyway
+func UploadMaxSize() int64 {
+	var max int64
+	for _, f := range ingestFormats {
+		if l := uploadLimit(f); l > max {
+			max = l
+		}
+	}
+	return max
+}
+
 func cleanTempFile(path string) {
 	err := os.Remove(path)
 	if err != nil {
@@ -36,7 +132,13 @@ func FileFsCheck() (bool, error) {
 		return false, err
 	}
 
-	dbList := repositories.MusicList()
+	// virtual tracks have no file
+	var dbList = make([]models.MusicEntity, 0)
+	for _, d := range repositories.MusicList() {
+		if !d.Virtual {
+			dbList = append(dbList, d)
+		}
+	}
 
 	if len(fsList) != len(dbList) {
 		return false, errors.New("conflicts found")
@@ -72,89 +174,276 @@ func FileListManager() ([]models.File, error) {
 	return files, err
 }
 
-func FileDeleteManager(tags models.Tags) (models.File, error) {
-	var f models.File
+// remove a file from FS and DB, the file being moved to the trash until the
+// row is deleted, so that it can be restored if the DB fails
+func FileDeleteManager(tags models.Tags) (models.MusicDto, error) {
+	var f models.MusicDto
+
+	m, err := repositories.MusicGetFromTitle(tags.Title, tags.Artist)
+	if err != nil {
+		return f, err
+	}
 
-	fileDeleted, err := repositories.RemoveFile(tags)
+	i, err := intentOpen(models.IntentDelete, tags, m.Id)
 	if err != nil {
 		logger.Error(err.Error())
 		return f, errors.New("error while deleting file")
 	}
 
-	return fileDeleted, nil
+	// virtual tracks only exist in DB
+	if !m.Virtual {
+		if err := repositories.TrashFile(tags, i.TrashPath); err != nil {
+			intentClose(i.Id, true)
+			logger.Error(err.Error())
+			return f, errors.New("error while deleting file")
+		}
+	}
+
+	dto, err := FileDbDelete(tags.Title, tags.Artist)
+	if err != nil {
+		restoreErr := repositories.RestoreFile(tags, i.TrashPath)
+		if restoreErr != nil {
+			logger.Error(restoreErr.Error())
+		}
+		intentClose(i.Id, restoreErr == nil)
+
+		logger.Error(err.Error())
+		return f, errors.New("error while deleting file in db")
+	}
+
+	err = repositories.RemoveTrashedFile(tags, i.TrashPath)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	intentClose(i.Id, err == nil)
+
+	return dto, nil
 }
 
-func FileStoreManager(file multipart.File, headers *multipart.FileHeader, mp models.MusicParam) (models.File, error) {
-	var f models.File
+// undo the store of a file which will not be in DB, the track it replaced
+// being restored
+func FileDiscardManager(file models.File) {
+	_, err := repositories.RemoveFile(file.Metadata)
+	if err != nil {
+		logger.Error(err.Error())
+	}
 
-	defer file.Close()
+	intentClose(file.Intent, err == nil)
+	restoreReplaced(file.Replaced)
+}
 
-	// check size
-	if headers.Size > maxSize {
-		return f, errors.New(fmt.Sprintf(
-			"file too big: maximum allowed is %d Mb", maxMegaBytes))
+// check the format guessed from the magic bytes and return its size limit
+// the type declared by the client is only used to explain a rejection
+func checkUploadType(header []byte, declared string) (string, int64, error) {
+	format, _ := repositories.DetectAudioFormat(header)
+	if format == "" {
+		if declared != "" {
+			return "", 0, fmt.Errorf("%w: unrecognized content, declared as %s", ErrBadType, declared)
+		}
+		return "", 0, fmt.Errorf("%w: unrecognized content", ErrBadType)
 	}
 
-	// check mime
-	if headers.Header.Get("Content-Type") != mimeMp3 {
-		logger.Info(headers.Header.Get("Content-Type"))
-		return f, errors.New("bad mime")
+	if err := checkIngestFormat(format); err != nil {
+		return "", 0, err
 	}
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
+	return format, uploadLimit(format), nil
+}
+
+// the other formats are recognized but cannot be tagged nor decoded
+func checkIngestFormat(format string) error {
+	for _, f := range ingestFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s files are not supported", ErrBadType, format)
+}
+
+func errFileTooBig(limit int64) error {
+	return fmt.Errorf("%w: maximum allowed is %d Mb", ErrTooBig, limit>>(10*2))
+}
+
+// stream the uploaded file to a staging file of its own, with the size limit of
+// its format, the declared MIME type being optional
+// the digest of the file is computed on the way, to verify it
+func FileStageManager(requestId string, file io.Reader, declared string) (string, models.Digest, error) {
+	var f string
+	var d models.Digest
+
+	header := make([]byte, repositories.SniffHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		logger.Error("error reading file")
-		return f, err
+		return f, d, err
 	}
+	header = header[:n]
 
-	tempFileName := "tempfile.mp3"
-	tempFilePath := path.Join(repositories.Tmp, tempFileName)
-	err = ioutil.WriteFile(tempFilePath, data, 0644)
+	format, limit, err := checkUploadType(header, declared)
+	if err != nil {
+		return f, d, err
+	}
+
+	digest := repositories.NewDigestReader(io.MultiReader(bytes.NewReader(header), file))
+	tempFilePath, err := repositories.StageFile(digest, limit, requestId, format)
+	if err == repositories.ErrFileTooBig {
+		return f, d, errFileTooBig(limit)
+	}
 	if err != nil {
 		logger.Error("error writing file")
+		return f, d, err
+	}
+
+	return tempFilePath, digest.Digest(), nil
+}
+
+// remove a staged file which will not be stored
+func FileUnstageManager(tempFilePath string) {
+	if tempFilePath != "" {
+		cleanTempFile(tempFilePath)
+	}
+}
+
+// check and store the file staged by FileStageManager
+func FileStoreManager(tempFilePath string, mp models.MusicParam) (models.File, error) {
+	var f models.File
+	var err error
+
+	// the magic bytes are confirmed by parsing the file
+	format, err := repositories.CheckFileAudio(tempFilePath)
+	if err == nil {
+		err = checkIngestFormat(format)
+	} else {
+		err = fmt.Errorf("%w: not an audio file: %s", ErrBadType, err.Error())
+	}
+	if err != nil {
+		cleanTempFile(tempFilePath)
+
+		logger.Error(err.Error())
 		return f, err
 	}
 
-	// check is audio
-	if !repositories.CheckFileAudio(tempFilePath) {
+	// before the tags are sanitized, for the clients to find their file back
+	uploadSha256, err := repositories.HashFile(tempFilePath)
+	if err != nil {
 		cleanTempFile(tempFilePath)
 
-		msg := "not an audio file"
-		logger.Error(msg)
-		return f, errors.New(msg)
+		logger.Error(err.Error())
+		return f, err
 	}
 
-	// check if mp3 by reading ID3V2 tag
-	tags, err := repositories.ReadTags(tempFilePath)
+	// sanitized and read within the parsing limits
+	tags, err := parseTags(tempFilePath)
+	if err == nil {
+		if failures := repositories.CheckTags(tags); len(failures) > 0 {
+			err = fmt.Errorf("%w: %s", ErrBadTags, failures[0])
+		}
+	}
 	if err != nil {
 		cleanTempFile(tempFilePath)
 
 		logger.Error(err.Error())
-		return f, errors.New("error reading id3v2 tags")
+		return f, err
+	}
+
+	ingest.Lock()
+	collision, version, replaced, err := resolveDuplicate(tempFilePath, &tags, mp.Duplicate)
+	if err != nil {
+		ingest.Unlock()
+		cleanTempFile(tempFilePath)
+
+		return f, err
 	}
 
+	// closed by FileDbCreateManager or FileDiscardManager
+	intent, err := intentOpen(models.IntentStore, tags, "")
+	if err != nil {
+		restoreReplaced(replaced)
+		ingest.Unlock()
+		cleanTempFile(tempFilePath)
+
+		logger.Error(err.Error())
+		return f, errors.New("error storing file in library")
+	}
 
 	var fileAdded models.File
-	if fileAdded, err = repositories.AddFile(tempFilePath, tags); err != nil {
+	fileAdded, err = repositories.AddFile(tempFilePath, tags)
+	if err != nil {
+		intentClose(intent.Id, !repositories.FileExists(tags))
+		restoreReplaced(replaced)
+		ingest.Unlock()
 		cleanTempFile(tempFilePath)
 
 		logger.Error(err.Error())
 		return f, errors.New("error storing file in library")
 	}
+	ingest.Unlock()
+	fileAdded.Intent = intent.Id
+	fileAdded.Version = version
+	fileAdded.Collision = collision
+	fileAdded.Replaced = replaced
+	fileAdded.Format = format
+	fileAdded.UploadSha256 = uploadSha256
+
+	// for the later integrity checks
+	if fileAdded.Sha256, err = repositories.HashFile(repositories.GetStorePath(tags)); err != nil {
+		logger.Error(err.Error())
+	}
+
+	// a failed analysis does not prevent the file from being stored,
+	// the backfill job will try again later
+	if fileAdded.Audio, err = repositories.AnalyseFile(tags); err != nil {
+		logger.Error(err.Error())
+	}
 
 	return fileAdded, nil
 }
 
 // db
-func FileDbCreateManager(token string, m models.MusicParam, t models.Tags) (models.MusicDto, error) {
+// the file stored by FileStoreManager is removed if the DB fails
+func FileDbCreateManager(token string, m models.MusicParam, file models.File) (models.MusicDto, error) {
 	var f models.MusicDto
 
-	mEntity, err := repositories.MusicCreate(token, m, t)
+	imageUrl, err := albumImageUrl(m, file.Metadata)
+	if err != nil {
+		FileDiscardManager(file)
+		return f, err
+	}
+	m.ImageUrl = imageUrl
+
+	ingest.Lock()
+	mEntity, err := repositories.MusicCreate(token, m, file)
+	ingest.Unlock()
 	if err != nil {
+		FileDiscardManager(file)
 		return f, err
 	}
+	intentClose(file.Intent, true)
+	settleReplaced(file.Replaced, file.Metadata)
 
-	return mEntity.ToDto(), nil
+	t := file.Metadata
+	if err := repositories.MusicUpdateAlbumLoudness(t.Artist, t.Album); err != nil {
+		logger.Error(err.Error())
+	} else if mEntity, err = repositories.MusicGetFromTitle(t.Title, t.Artist); err != nil {
+		// reload to get the album values
+		return f, err
+	}
+
+	dto := mEntity.ToDto()
+	dto.Collision = file.Collision
+	if file.Audio.Analysed {
+		if err := repositories.FingerprintSave(t.Title, t.Artist, file.Audio); err != nil {
+			logger.Error(err.Error())
+		}
+
+		dto.PossibleDuplicates = fingerprintSearch(t.Title, t.Artist, file.Audio)
+
+		if err := repositories.ChapterSave(t.Title, t.Artist, file.Audio.Chapters); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	return dto, nil
 }
 
 func FileDbDelete(title string, artist string) (models.MusicDto, error) {
@@ -165,9 +454,35 @@ func FileDbDelete(title string, artist string) (models.MusicDto, error) {
 		return f, err
 	}
 
+	fileDbDeleteDerived(title, artist, m.Album)
+
 	return m.ToDto(), nil
 }
 
+// the rows computed from a deleted track, also removed when settling an
+// interrupted deletion
+func fileDbDeleteDerived(title string, artist string, album string) {
+	if err := repositories.MusicUpdateAlbumLoudness(artist, album); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := repositories.FingerprintDelete(title, artist); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := repositories.MusicDeleteChildren(title, artist); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := repositories.ChapterDelete(title, artist); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := repositories.ResumeDeleteFromTrack(title, artist); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
 func FileDbListLastManager() ([]models.MusicDto, error) {
 	lEntities, err := repositories.MusicListLimit()
 	if err != nil {
@@ -219,7 +534,6 @@ func FileDbListArtistManager() ([]models.ArtistDto, error) {
 
 	artistList := repositories.MusicArtistsList()
 
-
 	var res = make([]models.ArtistDto, 0)
 	for _, ar := range artistList {
 		var arAlbumList = make([]models.AlbumDto, 0)
@@ -254,7 +568,7 @@ func FileDbSearchManager(q string) ([]models.MusicDto, error) {
 			// check if v already in lDtos
 			check := false
 			for _, e := range lDtos {
-				if e == vDto {
+				if e.Title == vDto.Title && e.Artist == vDto.Artist {
 					check = true
 				}
 			}
@@ -282,3 +596,41 @@ func FileDbGet(title string, artist string) (models.MusicDto, error) {
 
 	return m.ToDto(), nil
 }
+
+// the tags of the track with the given id, to address it as the other ones
+func FileDbTagsManager(id string) (models.Tags, error) {
+	m, err := repositories.MusicGetFromId(id)
+	if err != nil {
+		return models.Tags{}, err
+	}
+
+	return m.ToTags(), nil
+}
+
+// give an id to the tracks stored before they had one, then add the primary
+// key and the unique natural key
+// the tracks stored twice are reported, to be removed by hand, the right one
+// to keep being unknown
+func InitMusicIds() error {
+	n, err := repositories.MusicAssignIds()
+	if n > 0 {
+		logger.Info(fmt.Sprintf("%d tracks given an id", n))
+	}
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := repositories.MusicConflicts()
+	if err != nil {
+		return err
+	}
+	for _, m := range conflicts {
+		logger.Error(fmt.Sprintf("%s by %s stored twice: id %s, album %s, added at %s",
+			m.Title, m.Artist, m.Id, m.Album, m.AddedAt))
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d rows share their title and artist with another one", len(conflicts))
+	}
+
+	return repositories.MusicAddKeys()
+}
@@ -0,0 +1,29 @@
+package managers
+
+import (
+	"testing"
+)
+
+func TestUploadMaxSize(t *testing.T) {
+	config := map[string]string{
+		uploadDefaultMaxMegaBytesKey: "20",
+		uploadMaxMegaBytesKey:        "mp3:50,flac:500,wav:1000",
+		uploadBatchWorkersKey:        "1",
+	}
+
+	// the formats which cannot be stored do not raise the limit
+	if err := InitUploadLimits(config); err != nil {
+		t.Fatal(err)
+	}
+	if max := UploadMaxSize(); max != 50<<20 {
+		t.Errorf("maximum of %d bytes, expected the mp3 limit", max)
+	}
+
+	config[uploadMaxMegaBytesKey] = "flac:500"
+	if err := InitUploadLimits(config); err != nil {
+		t.Fatal(err)
+	}
+	if max := UploadMaxSize(); max != 20<<20 {
+		t.Errorf("maximum of %d bytes, expected the default limit", max)
+	}
+}
@@ -0,0 +1,43 @@
+package managers
+
+import (
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+)
+
+const (
+	WaveformDefaultResolution = 1024
+)
+
+func CheckWaveformResolution(samplesPerPixel int) bool {
+	return repositories.CheckWaveformResolution(samplesPerPixel)
+}
+
+func WaveformGetManager(tags models.Tags, samplesPerPixel int) (models.WaveformDto, error) {
+	var f models.WaveformDto
+
+	w, err := repositories.GetWaveform(tags, samplesPerPixel)
+	if err != nil {
+		return f, err
+	}
+
+	return models.WaveformDto{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      w.SampleRate,
+		SamplesPerPixel: w.SamplesPerPixel,
+		Bits:            8,
+		Length:          w.Length(),
+		Data:            w.Data,
+	}, nil
+}
+
+// audiowaveform binary format
+func WaveformGetBinaryManager(tags models.Tags, samplesPerPixel int) ([]byte, error) {
+	w, err := repositories.GetWaveform(tags, samplesPerPixel)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.MarshalBinary()
+}
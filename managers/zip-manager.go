@@ -0,0 +1,206 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	zipExtension      = ".zip"
+	playlistExtension = ".m3u"
+	selectionName     = "selection"
+)
+
+var ErrZipEmpty = errors.New("no track to download")
+
+// archive of tracks, the entries being ready to be streamed
+// it must be released with DownloadZipReleaseManager once served
+type ZipDownload struct {
+	Name string
+	Size int64
+
+	entries []repositories.ZipEntry
+	// the cuts of the virtual tracks
+	temporary []string
+}
+
+// the component of a name in the archive, unable to leave its dir
+func zipNameComponent(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// the tracks of the selection, an album, the discography of an artist or the
+// given ids, in the order of their album
+func zipTracks(artist string, album string, ids []string) ([]models.MusicEntity, string, error) {
+	var res = make([]models.MusicEntity, 0)
+	var name string
+
+	switch {
+	case len(ids) > 0:
+		name = selectionName
+		var seen = make(map[string]bool)
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			m, err := repositories.MusicGetFromId(id)
+			if err != nil {
+				return nil, name, fmt.Errorf("track %s: %w", id, err)
+			}
+			res = append(res, m)
+		}
+
+	case album != "":
+		name = artist + " - " + album
+		res = repositories.MusicListFromAlbum(artist, album)
+
+	default:
+		name = artist
+		res = repositories.MusicListFromArtist(artist)
+	}
+
+	if len(res) == 0 {
+		return nil, name, ErrZipEmpty
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Artist != res[j].Artist {
+			return res[i].Artist < res[j].Artist
+		}
+		if res[i].Album != res[j].Album {
+			return res[i].Album < res[j].Album
+		}
+		return res[i].Title < res[j].Title
+	})
+
+	return res, zipNameComponent(name), nil
+}
+
+// the entry of the file of the track, a virtual track being cut from its parent
+// beforehand, so that the size of the archive is known
+// the cut is dated as its parent, the same archive being given by HEAD and GET
+func zipTrackEntry(m models.MusicEntity, dir string) (repositories.ZipEntry, string, error) {
+	var f repositories.ZipEntry
+
+	if !m.Virtual {
+		p, err := repositories.GetFilePathForDownload(m.ToTags())
+		if err != nil {
+			return f, "", err
+		}
+
+		e, err := repositories.NewZipFileEntry(path.Join(dir, zipNameComponent(m.Title)+path.Ext(p)), p)
+		return e, "", err
+	}
+
+	parent, err := repositories.GetFilePathForDownload(m.ParentTags())
+	if err != nil {
+		return f, "", err
+	}
+	parentEntry, err := repositories.NewZipFileEntry("", parent)
+	if err != nil {
+		return f, "", err
+	}
+
+	p, err := repositories.CutVirtualTrack(m)
+	if err != nil {
+		return f, "", err
+	}
+
+	e, err := repositories.NewZipFileEntry(path.Join(dir, zipNameComponent(m.Title)+path.Ext(p)), p)
+	if err != nil {
+		cleanTempFile(p)
+		return f, "", err
+	}
+	e.Modified = parentEntry.Modified
+
+	return e, p, nil
+}
+
+// list the files of the archive, with the covers of the albums and a playlist
+// its size is exact as long as the files do not change until it is streamed
+func DownloadZipManager(artist string, album string, ids []string) (ZipDownload, error) {
+	var f ZipDownload
+
+	l, name, err := zipTracks(artist, album, ids)
+	if err != nil {
+		return f, err
+	}
+
+	var z = ZipDownload{
+		Name: name + zipExtension,
+	}
+	var entries = make([]repositories.ZipEntry, 0)
+	var playlist strings.Builder
+	var covers = make(map[string]bool)
+	var modified time.Time
+
+	playlist.WriteString("#EXTM3U\n")
+	for _, m := range l {
+		dir := path.Join(zipNameComponent(m.Artist), zipNameComponent(m.Album))
+		e, cut, err := zipTrackEntry(m, dir)
+		if err != nil {
+			DownloadZipReleaseManager(z)
+			return f, err
+		}
+		if cut != "" {
+			z.temporary = append(z.temporary, cut)
+		}
+		entries = append(entries, e)
+
+		if e.Modified.After(modified) {
+			modified = e.Modified
+		}
+
+		duration := -1
+		if m.SampleRate > 0 && m.SampleCount > 0 {
+			duration = int(m.SampleCount / int64(m.SampleRate))
+		}
+		playlist.WriteString(fmt.Sprintf("#EXTINF:%d,%s - %s\n%s\n", duration, m.Artist, m.Title, e.Name))
+
+		if covers[dir] {
+			continue
+		}
+		covers[dir] = true
+
+		if coverPath, err := repositories.GetAlbumCoverPath(repositories.AlbumCoverId(m.Artist, m.Album)); err == nil {
+			cover, err := repositories.NewZipFileEntry(path.Join(dir, "cover"+path.Ext(coverPath)), coverPath)
+			if err != nil {
+				DownloadZipReleaseManager(z)
+				return f, err
+			}
+			entries = append(entries, cover)
+		}
+	}
+
+	// dated as the latest file, so that HEAD and GET give the same archive
+	entries = append(entries, repositories.NewZipDataEntry(
+		name+playlistExtension, []byte(playlist.String()), modified))
+
+	z.Size = repositories.ZipSize(entries)
+	z.entries = entries
+	return z, nil
+}
+
+// stream the archive, the response being already started
+func DownloadZipStreamManager(z ZipDownload, w io.Writer) error {
+	return repositories.WriteZip(w, z.entries)
+}
+
+func DownloadZipReleaseManager(z ZipDownload) {
+	for _, p := range z.temporary {
+		cleanTempFile(p)
+	}
+}
@@ -0,0 +1,179 @@
+package managers
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/Dadard29/go-api-utils/database"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/Dadard29/go-warehouse/repositories"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// MPEG-1 layer III frames at 128 kbps and 44100 Hz, of 26 ms each
+func mp3Fixture(count int) []byte {
+	const frameSize = 417
+
+	var b []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, frameSize)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// a stored file of about 2.6 seconds, cut in two virtual tracks
+func setupZipTracks(t *testing.T) (models.MusicEntity, []models.MusicEntity, func()) {
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := api.Api.Database
+	teardown := func() {
+		api.Api.Database = previous
+		db.Close()
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	album := path.Join("store", "Artist", "Concert")
+	if err := os.MkdirAll(album, 0755); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("tmp", 0755); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(album, "Live.mp3"), mp3Fixture(100), 0644); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.MusicEntity{}).Error; err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	api.Api.Database = &database.Connector{Orm: db}
+
+	parent, err := repositories.MusicCreate("token", models.MusicParam{}, models.File{
+		Metadata: models.Tags{Title: "Live", Artist: "Artist", Album: "Concert"},
+	})
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	tracks, err := repositories.MusicCreateVirtual("token", models.MusicParam{}, parent, models.CueSheet{
+		Tracks: []models.CueTrack{
+			{Number: 1, Title: "Opening", End: time.Second},
+			{Number: 2, Title: "Encore", Start: time.Second},
+		},
+	})
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+
+	return parent, tracks, teardown
+}
+
+func TestZipTracksVirtual(t *testing.T) {
+	parent, tracks, teardown := setupZipTracks(t)
+	defer teardown()
+
+	var cases = []struct {
+		name string
+		ids  []string
+	}{
+		{"cue tracks only", []string{tracks[0].Id, tracks[1].Id, tracks[0].Id}},
+		{"cue track and its parent", []string{tracks[1].Id, parent.Id}},
+	}
+
+	// each track selected once, as itself
+	for _, c := range cases {
+		l, _, err := zipTracks("", "", c.ids)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if len(l) != 2 {
+			t.Errorf("%s: got %d tracks", c.name, len(l))
+		}
+		for _, m := range l {
+			if m.Id != parent.Id && !m.Virtual {
+				t.Errorf("%s: unexpected track %+v", c.name, m)
+			}
+		}
+	}
+
+	l, _, err := zipTracks("Artist", "Concert", nil)
+	if err != nil || len(l) != 3 {
+		t.Errorf("album: got %d tracks, %v", len(l), err)
+	}
+}
+
+func TestDownloadZipManagerVirtual(t *testing.T) {
+	_, tracks, teardown := setupZipTracks(t)
+	defer teardown()
+
+	z, err := DownloadZipManager("", "", []string{tracks[0].Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := DownloadZipStreamManager(z, &b); err != nil {
+		t.Fatal(err)
+	}
+	if int64(b.Len()) != z.Size {
+		t.Errorf("size announced %d, written %d", z.Size, b.Len())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "Artist/Concert/Opening.mp3" {
+		t.Fatalf("unexpected entries %+v", zr.File)
+	}
+
+	// the cut, not the whole file
+	full, err := os.Stat(path.Join("store", "Artist", "Concert", "Live.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := int64(zr.File[0].UncompressedSize64); size == 0 || size >= full.Size()/2 {
+		t.Errorf("track of %d bytes, for a file of %d", size, full.Size())
+	}
+
+	if len(z.temporary) != 1 {
+		t.Fatalf("%d cuts made", len(z.temporary))
+	}
+	DownloadZipReleaseManager(z)
+	for _, p := range z.temporary {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("cut %s not released", p)
+		}
+	}
+}
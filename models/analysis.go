@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// exposed
+type BackfillDto struct {
+	Running    bool      `json:"running"`
+	Processed  int       `json:"processed"`
+	Failed     int       `json:"failed"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// outcome of each file of a batch upload
+const (
+	BatchStored    = "stored"
+	BatchDuplicate = "duplicate"
+	BatchBadTags   = "bad_tags"
+	BatchTooBig    = "too_big"
+	BatchBadType   = "bad_type"
+	BatchTooMany   = "too_many_files"
+	BatchUnsafe    = "unsafe_path"
+	BatchChecksum  = "bad_checksum"
+	BatchCover     = "cover"
+	BatchSkipped   = "skipped"
+	BatchError     = "error"
+)
+
+// exposed
+type BatchResultDto struct {
+	Filename string    `json:"filename"`
+	Status   string    `json:"status"`
+	Message  string    `json:"message,omitempty"`
+	Music    *MusicDto `json:"music,omitempty"`
+
+	// set when the file collided with a stored track
+	Collision *CollisionDto `json:"collision,omitempty"`
+}
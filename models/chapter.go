@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// read from the ID3 CHAP frames or the MP4 chapter track
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// stored in db
+type ChapterEntity struct {
+	TrackTitle  string `gorm:"type:varchar(70);index:track_title"`
+	TrackArtist string `gorm:"type:varchar(70);index:track_artist"`
+	Number      int    `gorm:"type:int"`
+	Title       string `gorm:"type:varchar(255)"`
+	StartMs     int64  `gorm:"type:bigint"`
+	EndMs       int64  `gorm:"type:bigint"`
+}
+
+func (ChapterEntity) TableName() string {
+	return "chapter"
+}
+
+func (c ChapterEntity) ToDto() ChapterDto {
+	return ChapterDto{
+		Number:  c.Number,
+		Title:   c.Title,
+		StartMs: c.StartMs,
+		EndMs:   c.EndMs,
+	}
+}
+
+// exposed
+type ChapterDto struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+}
+
+// position where a user stopped in a track, one per user and track
+type ResumeEntity struct {
+	TrackTitle  string    `gorm:"type:varchar(70);index:track_title"`
+	TrackArtist string    `gorm:"type:varchar(70);index:track_artist"`
+	User        string    `gorm:"type:varchar(70);index:user"`
+	PositionMs  int64     `gorm:"type:bigint"`
+	UpdatedAt   time.Time `gorm:"type:datetime"`
+}
+
+func (ResumeEntity) TableName() string {
+	return "resume_position"
+}
+
+func (r ResumeEntity) ToDto() ResumeDto {
+	return ResumeDto{
+		Title:      r.TrackTitle,
+		Artist:     r.TrackArtist,
+		PositionMs: r.PositionMs,
+		UpdatedAt:  r.UpdatedAt,
+	}
+}
+
+// exposed
+type ResumeDto struct {
+	Title      string    `json:"title"`
+	Artist     string    `json:"artist"`
+	PositionMs int64     `json:"position_ms"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
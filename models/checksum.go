@@ -0,0 +1,14 @@
+package models
+
+// hexadecimal digests of a file, empty if unknown
+type Digest struct {
+	Md5    string
+	Sha256 string
+}
+
+// exposed, a stored file not matching its checksum
+type IntegrityDto struct {
+	Music  MusicDto `json:"music"`
+	Sha256 string   `json:"sha256"`
+	Error  string   `json:"error,omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	// offset of INDEX 01 in the file
+	Start time.Duration
+	// offset of the next track, 0 for the last one
+	End time.Duration
+}
+
+type CueSheet struct {
+	Title     string
+	Performer string
+	Genre     string
+	Date      string
+	Tracks    []CueTrack
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// exposed
+type DownloadLinkDto struct {
+	Url string `json:"url"`
+	// not set on the links not signed, which do not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
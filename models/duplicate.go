@@ -0,0 +1,24 @@
+package models
+
+// what to do when an upload is already stored
+const (
+	DuplicateReject   = "reject"
+	DuplicateReplace  = "replace"
+	DuplicateKeepBoth = "keep_both"
+	DuplicateKeepBest = "keep_best"
+)
+
+// what was done with the collision
+const (
+	CollisionRejected     = "rejected"
+	CollisionReplaced     = "replaced"
+	CollisionKeptBoth     = "kept_both"
+	CollisionKeptExisting = "kept_existing"
+)
+
+// exposed, the track an upload collided with
+type CollisionDto struct {
+	Policy   string   `json:"policy"`
+	Action   string   `json:"action"`
+	Existing MusicDto `json:"existing"`
+}
@@ -17,8 +17,56 @@ type Tags struct {
 	Genre       string
 }
 
+// encoder delay and padding, in samples per channel
+type GaplessInfo struct {
+	Found          bool
+	EncoderDelay   int
+	EncoderPadding int
+	Samples        int64
+	SampleRate     int
+}
+
+// computed from the decoded audio
+type AudioInfo struct {
+	Analysed bool
+	Version  int
+
+	Loudness       float64
+	LoudnessBlocks int
+	Peak           float64
+
+	// in seconds
+	Duration float64
+	// encoded chromagram fingerprint
+	Fingerprint string
+
+	Gapless GaplessInfo
+
+	Chapters []Chapter
+}
+
+// a stored track replaced by an upload, its file waiting in the trash under
+// the intent of its deletion until the upload is in db
+type Replacement struct {
+	Music  MusicEntity
+	Intent IntentEntity
+}
+
 type File struct {
 	Filename string
 	AddedAt  time.Time
 	Metadata Tags
+	Audio    AudioInfo
+
+	// intent of the store, closed once the file is in db
+	Intent string
+
+	Version   int
+	Collision *CollisionDto
+	Replaced  *Replacement
+	Format    string
+
+	// SHA-256 of the stored file, and of the file as uploaded
+	Sha256       string
+	UploadSha256 string
 }
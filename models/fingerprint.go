@@ -0,0 +1,22 @@
+package models
+
+// stored in db
+type FingerprintEntity struct {
+	Title  string `gorm:"type:varchar(70);index:title"`
+	Artist string `gorm:"type:varchar(70);index:artist"`
+
+	// in seconds, used to skip the comparisons with tracks too long or too short
+	Duration float64 `gorm:"type:double;index:duration"`
+	Data     string  `gorm:"type:mediumtext"`
+}
+
+func (FingerprintEntity) TableName() string {
+	return "fingerprint"
+}
+
+// exposed
+type DuplicateDto struct {
+	First      MusicDto `json:"first"`
+	Second     MusicDto `json:"second"`
+	Similarity float64  `json:"similarity"`
+}
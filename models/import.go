@@ -0,0 +1,20 @@
+package models
+
+import (
+	"net"
+	"time"
+)
+
+// applied to the URLs imported by the server
+type ImportPolicy struct {
+	// if not empty, only these hosts and their subdomains can be fetched
+	AllowHosts []string
+	// never fetched, even if listed in AllowHosts
+	DenyHosts []string
+	// if not empty, only the addresses in these networks can be dialed
+	AllowNetworks []*net.IPNet
+	// never dialed, even if in AllowNetworks
+	DenyNetworks []*net.IPNet
+	Timeout      time.Duration
+	MaxRedirects int
+}
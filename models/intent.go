@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	IntentStore  = "store"
+	IntentDelete = "delete"
+)
+
+// write operation on FS and DB in progress, stored in db before the first step
+// and removed once both agree, so that an interrupted one can be settled
+type IntentEntity struct {
+	Id        string `gorm:"type:varchar(32);primary_key"`
+	Operation string `gorm:"type:varchar(10)"`
+	Title     string `gorm:"type:varchar(70)"`
+	Artist    string `gorm:"type:varchar(70)"`
+	Album     string `gorm:"type:varchar(70)"`
+
+	// the deleted track, its title being taken by the upload replacing it
+	MusicId string `gorm:"type:char(36)"`
+
+	// where the file waits during a deletion
+	TrashPath string `gorm:"type:varchar(255)"`
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+}
+
+func (IntentEntity) TableName() string {
+	return "intent"
+}
+
+func (i IntentEntity) Tags() Tags {
+	return Tags{
+		Title:  i.Title,
+		Artist: i.Artist,
+		Album:  i.Album,
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	// steps of a running job
+	JobStageStore   = "store"
+	JobStageCatalog = "catalog"
+	JobStageCue     = "cue"
+
+	JobErrorMaxLength = 255
+)
+
+// stored in db, so that the jobs survive a restart
+type JobEntity struct {
+	Id       string `gorm:"type:varchar(32);primary_key"`
+	Owner    string `gorm:"type:varchar(70);index:owner"`
+	State    string `gorm:"type:varchar(10);index:state"`
+	Stage    string `gorm:"type:varchar(10)"`
+	Progress int    `gorm:"type:int"`
+	// cut to JobErrorMaxLength characters
+	Error string `gorm:"type:varchar(255)"`
+
+	// inputs
+	StagedPath string `gorm:"type:varchar(255)"`
+	ImageUrl   string `gorm:"type:varchar(255)"`
+	CoverPath  string `gorm:"type:varchar(255)"`
+	Duplicate  string `gorm:"type:varchar(10)"`
+	// cue sheet encoded in JSON, empty if none
+	Cue string `gorm:"type:mediumtext"`
+
+	// resulting track
+	MusicId string `gorm:"type:char(36)"`
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+	UpdatedAt time.Time `gorm:"type:datetime"`
+}
+
+func (JobEntity) TableName() string {
+	return "job"
+}
+
+func (j JobEntity) ToDto() JobDto {
+	return JobDto{
+		Id:        j.Id,
+		State:     j.State,
+		Stage:     j.Stage,
+		Progress:  j.Progress,
+		Error:     j.Error,
+		CreatedAt: j.CreatedAt,
+		UpdatedAt: j.UpdatedAt,
+	}
+}
+
+// exposed
+type JobDto struct {
+	Id        string    `json:"id"`
+	State     string    `json:"state"`
+	Stage     string    `json:"stage,omitempty"`
+	Progress  int       `json:"progress"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Music     *MusicDto `json:"music,omitempty"`
+}
@@ -2,10 +2,17 @@ package models
 
 import "time"
 
+// size of the image column, the cover URLs built by the API must fit in it
+const ImageUrlMaxLength = 200
+
 // stored in db
 type MusicEntity struct {
-	Title       string `gorm:"type:varchar(70);index:title"`
-	Artist      string `gorm:"type:varchar(70);index:artist"`
+	// generated on creation, never changes with the tags
+	Id string `gorm:"type:char(36);primary_key"`
+
+	// natural key, unique
+	Title       string `gorm:"type:varchar(70);index:title;unique_index:title_artist"`
+	Artist      string `gorm:"type:varchar(70);index:artist;unique_index:title_artist"`
 	Album       string `gorm:"type:varchar(70);index:album"`
 	PublishedAt string `gorm:"type:varchar(20);index:published_at"`
 	Genre       string `gorm:"type:varchar(40);index:genre"`
@@ -13,14 +20,64 @@ type MusicEntity struct {
 
 	AddedAt time.Time `gorm:"type:datetime;index:added_at"`
 	AddedBy string    `gorm:"type:varchar(70);index:added_by"`
+
+	// loudness analysis, ReplayGain values are in dB and peaks are linear
+	Analysed       bool    `gorm:"index:analysed"`
+	Loudness       float64 `gorm:"type:double"`
+	LoudnessBlocks int     `gorm:"type:int"`
+	TrackGain      float64 `gorm:"type:double"`
+	TrackPeak      float64 `gorm:"type:double"`
+	AlbumGain      float64 `gorm:"type:double"`
+	AlbumPeak      float64 `gorm:"type:double"`
+
+	// version of the analysis the values come from
+	AnalysisVersion int `gorm:"type:int;index:analysis_version"`
+
+	// gapless playback, delay and padding are in samples per channel
+	Gapless        bool  `gorm:"index:gapless"`
+	EncoderDelay   int   `gorm:"type:int"`
+	EncoderPadding int   `gorm:"type:int"`
+	SampleCount    int64 `gorm:"type:bigint"`
+	SampleRate     int   `gorm:"type:int"`
+
+	// tracks defined by a cue sheet, cut from their parent file on download
+	Virtual      bool   `gorm:"index:virtual"`
+	ParentTitle  string `gorm:"type:varchar(70);index:parent_title"`
+	ParentArtist string `gorm:"type:varchar(70);index:parent_artist"`
+	ParentAlbum  string `gorm:"type:varchar(70)"`
+	TrackNumber  int    `gorm:"type:int"`
+	StartMs      int64  `gorm:"type:bigint"`
+	EndMs        int64  `gorm:"type:bigint"`
+
+	// several versions of a track are kept under numbered titles
+	Version int `gorm:"type:int;default:1"`
+
+	// detected from the content of the file
+	Format string `gorm:"type:varchar(10);default:'mp3'"`
+
+	// SHA-256 of the stored file, and of the file as uploaded, before its tags
+	// were sanitized
+	Sha256       string `gorm:"type:char(64)"`
+	UploadSha256 string `gorm:"type:char(64);index:upload_sha256"`
 }
 
 func (MusicEntity) TableName() string {
 	return "music"
 }
 
+func (m MusicEntity) ToTags() Tags {
+	return Tags{
+		Title:       m.Title,
+		Artist:      m.Artist,
+		Album:       m.Album,
+		PublishedAt: m.PublishedAt,
+		Genre:       m.Genre,
+	}
+}
+
 func (m MusicEntity) ToDto() MusicDto {
 	return MusicDto{
+		Id:          m.Id,
 		Title:       m.Title,
 		Artist:      m.Artist,
 		Album:       m.Album,
@@ -28,11 +85,46 @@ func (m MusicEntity) ToDto() MusicDto {
 		Genre:       m.Genre,
 		ImageUrl:    m.ImageUrl,
 		AddedAt:     m.AddedAt,
+
+		Analysed:  m.Analysed,
+		Loudness:  m.Loudness,
+		TrackGain: m.TrackGain,
+		TrackPeak: m.TrackPeak,
+		AlbumGain: m.AlbumGain,
+		AlbumPeak: m.AlbumPeak,
+
+		Gapless:        m.Gapless,
+		EncoderDelay:   m.EncoderDelay,
+		EncoderPadding: m.EncoderPadding,
+		SampleCount:    m.SampleCount,
+		SampleRate:     m.SampleRate,
+
+		Virtual:      m.Virtual,
+		ParentTitle:  m.ParentTitle,
+		ParentArtist: m.ParentArtist,
+		TrackNumber:  m.TrackNumber,
+		StartMs:      m.StartMs,
+		EndMs:        m.EndMs,
+
+		Version: m.Version,
+		Format:  m.Format,
+
+		Sha256:       m.Sha256,
+		UploadSha256: m.UploadSha256,
+	}
+}
+
+func (m MusicEntity) ParentTags() Tags {
+	return Tags{
+		Title:  m.ParentTitle,
+		Artist: m.ParentArtist,
+		Album:  m.ParentAlbum,
 	}
 }
 
 // exposed
 type MusicDto struct {
+	Id          string `json:"id"`
 	Title       string `json:"title"`
 	Artist      string `json:"artist"`
 	Album       string `json:"album"`
@@ -41,25 +133,63 @@ type MusicDto struct {
 	ImageUrl    string `json:"image_url"`
 
 	AddedAt time.Time `json:"added_at"`
+
+	Analysed  bool    `json:"analysed"`
+	Loudness  float64 `json:"loudness"`
+	TrackGain float64 `json:"track_gain"`
+	TrackPeak float64 `json:"track_peak"`
+	AlbumGain float64 `json:"album_gain"`
+	AlbumPeak float64 `json:"album_peak"`
+
+	Gapless        bool  `json:"gapless"`
+	EncoderDelay   int   `json:"encoder_delay"`
+	EncoderPadding int   `json:"encoder_padding"`
+	SampleCount    int64 `json:"sample_count"`
+	SampleRate     int   `json:"sample_rate"`
+
+	Virtual      bool   `json:"virtual"`
+	ParentTitle  string `json:"parent_title"`
+	ParentArtist string `json:"parent_artist"`
+	TrackNumber  int    `json:"track_number"`
+	StartMs      int64  `json:"start_ms"`
+	EndMs        int64  `json:"end_ms"`
+
+	Version int    `json:"version"`
+	Format  string `json:"format"`
+
+	Sha256       string `json:"sha256,omitempty"`
+	UploadSha256 string `json:"upload_sha256,omitempty"`
+
+	// signed link, set by the authenticated endpoints
+	DownloadUrl string `json:"download_url,omitempty"`
+
+	// only set on upload
+	PossibleDuplicates []MusicDto    `json:"possible_duplicates,omitempty"`
+	Collision          *CollisionDto `json:"collision,omitempty"`
+	Tracks             []MusicDto    `json:"tracks,omitempty"`
 }
 
 type AlbumDto struct {
-	Name string   `json:"name"`
+	Name      string   `json:"name"`
 	TitleList []string `json:"title_list"`
 	Artist    string   `json:"artist"`
 	ImageURL  string   `json:"image_url"`
 }
 
 type ArtistDto struct {
-	Name string `json:"name"`
-	AlbumList  []AlbumDto `json:"album_list"`
+	Name      string     `json:"name"`
+	AlbumList []AlbumDto `json:"album_list"`
 }
 
 // input
 type MusicParam struct {
 	ImageUrl string `json:"image_url"`
+	// duplicate policy, the configured one if empty
+	Duplicate string `json:"duplicate"`
+	// staged image, becoming the cover of the album instead of ImageUrl
+	CoverPath string `json:"-"`
 }
 
 func (m MusicParam) CheckSanity() bool {
-	return m.ImageUrl != ""
+	return m.ImageUrl != "" || m.CoverPath != ""
 }
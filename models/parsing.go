@@ -0,0 +1,8 @@
+package models
+
+// bounds of the tags of the uploaded files, checked before they are parsed
+type ParseLimits struct {
+	MaxTagSize     int
+	MaxFrames      int
+	MaxPictureSize int
+}
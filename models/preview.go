@@ -0,0 +1,38 @@
+package models
+
+// read from the first frame and the decoder
+type AudioProperties struct {
+	SampleRate int     `json:"sample_rate"`
+	Channels   int     `json:"channels"`
+	Bitrate    int     `json:"bitrate"`
+	Duration   float64 `json:"duration"`
+	Lossless   bool    `json:"lossless"`
+}
+
+// exposed, what an upload of the file would do
+type PreviewDto struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Extension string `json:"extension"`
+	Mime      string `json:"mime"`
+
+	// after the sanitation
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	PublishedAt string `json:"published_at"`
+	Genre       string `json:"genre"`
+
+	Audio   AudioProperties `json:"audio"`
+	Gapless GaplessInfo     `json:"gapless"`
+
+	Destination   string `json:"destination"`
+	FileConflict  bool   `json:"file_conflict"`
+	MusicConflict bool   `json:"music_conflict"`
+	CueTracks     int    `json:"cue_tracks"`
+	// what the duplicate policy would do, if the track is already stored
+	Collision *CollisionDto `json:"collision,omitempty"`
+
+	Valid    bool     `json:"valid"`
+	Failures []string `json:"failures"`
+}
@@ -0,0 +1,13 @@
+package models
+
+// applied to the tags of the uploaded files
+type SanitizePolicy struct {
+	// if not empty, only these frames are kept
+	KeepFrames []string
+	// removed, even if listed in KeepFrames
+	StripFrames []string
+	// pictures bigger than this are removed, 0 for no limit
+	MaxPictureSize int
+	// ID3v2 version to convert to, 3 or 4, 0 to keep the original one
+	Version byte
+}
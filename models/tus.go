@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+const TusErrorMaxLength = 255
+
+// stored in db, the offset being the size of the partial file
+type TusUploadEntity struct {
+	Id        string    `gorm:"type:varchar(32);primary_key"`
+	Owner     string    `gorm:"type:varchar(70);index:owner"`
+	Length    int64     `gorm:"type:bigint"`
+	ImageUrl  string    `gorm:"type:varchar(255)"`
+	Duplicate string    `gorm:"type:varchar(10)"`
+	CreatedAt time.Time `gorm:"type:datetime"`
+	ExpiresAt time.Time `gorm:"type:datetime;index:expires_at"`
+
+	// set once the last chunk is received and the file processed
+	Done bool `gorm:"type:tinyint(1)"`
+	// cut to TusErrorMaxLength characters
+	Error  string `gorm:"type:varchar(255)"`
+	Title  string `gorm:"type:varchar(70)"`
+	Artist string `gorm:"type:varchar(70)"`
+}
+
+func (TusUploadEntity) TableName() string {
+	return "tus_upload"
+}
+
+func (t TusUploadEntity) ToDto(offset int64) TusUploadDto {
+	return TusUploadDto{
+		Id:        t.Id,
+		Offset:    offset,
+		Length:    t.Length,
+		ExpiresAt: t.ExpiresAt,
+		Done:      t.Done,
+		Error:     t.Error,
+		Title:     t.Title,
+		Artist:    t.Artist,
+	}
+}
+
+// exposed
+type TusUploadDto struct {
+	Id        string    `json:"id"`
+	Offset    int64     `json:"offset"`
+	Length    int64     `json:"length"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Done      bool      `json:"done"`
+	Error     string    `json:"error,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Artist    string    `json:"artist,omitempty"`
+}
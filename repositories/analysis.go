@@ -0,0 +1,218 @@
+package repositories
+
+import (
+	"fmt"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/bogem/id3v2"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	replayGainTempPattern = "replaygain-*" + mp3Extension
+
+	// to increase when the analysis gets new values, so that the backfill
+	// processes the tracks again
+	AnalysisVersion = 3
+
+	iTunSMPBDescription = "iTunSMPB"
+)
+
+// decode the stored file once to measure its loudness, cache its peaks and
+// compute its fingerprint, and read its gapless information and chapters
+func AnalyseFile(tags models.Tags) (models.AudioInfo, error) {
+	var f models.AudioInfo
+
+	// a broken LAME or iTunSMPB tag does not prevent the rest of the analysis,
+	// the track is then played without trimming its padding
+	gapless, err := ReadGapless(getFullFilePath(tags))
+	if err != nil {
+		logger.Error(err.Error())
+		gapless = models.GaplessInfo{}
+	}
+
+	meter := audio.NewLoudnessMeter()
+	waveform := audio.NewWaveformBuilder(audio.WaveformResolutions...)
+	fingerprinter := audio.NewFingerprinter()
+	if err := audio.Scan(getFullFilePath(tags), meter, waveform, fingerprinter); err != nil {
+		return f, err
+	}
+
+	if err := StoreWaveforms(tags, waveform.Result()); err != nil {
+		logger.Error(err.Error())
+	}
+
+	l := meter.Result()
+	fp := fingerprinter.Result()
+
+	duration := time.Duration(fp.Duration * float64(time.Second))
+	chapters, err := ReadChapters(getFullFilePath(tags), duration)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return models.AudioInfo{
+		Analysed:       true,
+		Version:        AnalysisVersion,
+		Gapless:        gapless,
+		Loudness:       l.Integrated,
+		LoudnessBlocks: l.Blocks,
+		Peak:           l.Peak,
+		Duration:       fp.Duration,
+		Fingerprint:    fp.Encode(),
+		Chapters:       chapters,
+	}, nil
+}
+
+// from the LAME tag, or from the iTunSMPB comment iTunes writes
+func ReadGapless(path string) (models.GaplessInfo, error) {
+	var f models.GaplessInfo
+
+	g, found, err := audio.ReadGapless(path)
+	if err != nil {
+		return f, err
+	}
+
+	if !found {
+		var ok bool
+		if g, ok = readITunSMPBComment(path, g.SampleRate); !ok {
+			return models.GaplessInfo{
+				SampleRate: g.SampleRate,
+			}, nil
+		}
+	}
+
+	return models.GaplessInfo{
+		Found:          true,
+		EncoderDelay:   g.EncoderDelay,
+		EncoderPadding: g.EncoderPadding,
+		Samples:        g.Samples,
+		SampleRate:     g.SampleRate,
+	}, nil
+}
+
+// format of the stream, read from the headers of its container, the duration
+// of a mp3 needing to decode the whole file
+func ReadAudioProperties(path string) (models.AudioProperties, error) {
+	p, err := audio.ReadProperties(path)
+	if err != nil {
+		return models.AudioProperties{}, err
+	}
+
+	return models.AudioProperties{
+		SampleRate: p.SampleRate,
+		Channels:   p.Channels,
+		Bitrate:    p.Bitrate,
+		Duration:   p.Duration,
+		Lossless:   p.Lossless,
+	}, nil
+}
+
+func readITunSMPBComment(path string, sampleRate int) (audio.Gapless, bool) {
+	var g audio.Gapless
+
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return g, false
+	}
+	defer tag.Close()
+
+	for _, f := range tag.GetFrames(tag.CommonID("Comments")) {
+		c, ok := f.(id3v2.CommentFrame)
+		if !ok || c.Description != iTunSMPBDescription {
+			continue
+		}
+
+		if g, ok = audio.ParseITunSMPB(c.Text); ok {
+			g.SampleRate = sampleRate
+			return g, true
+		}
+	}
+
+	return g, false
+}
+
+// compute the album values from the tracks already analysed
+func AlbumLoudness(tracks []models.MusicEntity) audio.Loudness {
+	var l = make([]audio.Loudness, 0)
+	for _, t := range tracks {
+		if !t.Analysed {
+			continue
+		}
+
+		l = append(l, audio.Loudness{
+			Integrated: t.Loudness,
+			Peak:       t.TrackPeak,
+			Blocks:     t.LoudnessBlocks,
+		})
+	}
+
+	return audio.AlbumLoudness(l)
+}
+
+// copy the file in the temp dir, or cut it from its parent for a virtual
+// track, and write the ReplayGain tags in the copy
+// the caller is responsible for removing the returned file
+func WriteReplayGainTags(m models.MusicEntity) (string, error) {
+	var p string
+
+	tmpPath, err := copyTrack(m)
+	if err != nil {
+		return p, err
+	}
+
+	tag, err := id3v2.Open(tmpPath, id3v2.Options{Parse: true})
+	if err != nil {
+		os.Remove(tmpPath)
+		return p, err
+	}
+	defer tag.Close()
+
+	values := map[string]string{
+		"REPLAYGAIN_TRACK_GAIN": fmt.Sprintf("%.2f dB", m.TrackGain),
+		"REPLAYGAIN_TRACK_PEAK": fmt.Sprintf("%.6f", m.TrackPeak),
+		"REPLAYGAIN_ALBUM_GAIN": fmt.Sprintf("%.2f dB", m.AlbumGain),
+		"REPLAYGAIN_ALBUM_PEAK": fmt.Sprintf("%.6f", m.AlbumPeak),
+		"REPLAYGAIN_REFERENCE_LOUDNESS": fmt.Sprintf(
+			"%.2f LUFS", audio.ReplayGainReference),
+	}
+	for k, v := range values {
+		tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			Description: k,
+			Value:       v,
+		})
+	}
+
+	if err := tag.Save(); err != nil {
+		os.Remove(tmpPath)
+		return p, err
+	}
+
+	return tmpPath, nil
+}
+
+// temporary copy of the audio of the track
+func copyTrack(m models.MusicEntity) (string, error) {
+	var p string
+
+	if m.Virtual {
+		return CutVirtualTrack(m)
+	}
+
+	tmpFile, err := ioutil.TempFile(Tmp, replayGainTempPattern)
+	if err != nil {
+		return p, err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+
+	if err := copyFile(getFullFilePath(m.ToTags()), tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return p, err
+	}
+
+	return tmpPath, nil
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "gz"
+)
+
+var ErrUnsafePath = errors.New("unsafe path in archive")
+
+// the entries are handed with their name as found in the archive, the caller
+// must never use it as a path on disk
+type ArchiveEntryFunc func(name string, r io.Reader) error
+
+// reject the absolute paths and the ones going up the tree (zip slip)
+func CheckArchivePath(name string) error {
+	name = strings.Replace(name, "\\", "/", -1)
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return ErrUnsafePath
+	}
+
+	return nil
+}
+
+// call fn for each regular file of the archive, links and directories are
+// skipped
+// maxRatio is the maximum ratio between the declared sizes of a compressed
+// zip entry, the caller still has to limit what it reads
+func WalkArchive(p string, kind string, maxRatio uint64, fn ArchiveEntryFunc) error {
+	switch kind {
+	case ArchiveZip:
+		return walkZip(p, maxRatio, fn)
+	case ArchiveTar, ArchiveTarGz:
+		return walkTar(p, kind == ArchiveTarGz, fn)
+	default:
+		return errors.New("unsupported archive type")
+	}
+}
+
+func walkZip(p string, maxRatio uint64, fn ArchiveEntryFunc) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		if f.UncompressedSize64 > maxRatio*(f.CompressedSize64+1) {
+			return errors.New("suspicious compression ratio for " + f.Name)
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = fn(f.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkTar(p string, compressed bool, fn ArchiveEntryFunc) error {
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if compressed {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if err := fn(h.Name, tr); err != nil {
+			return err
+		}
+	}
+}
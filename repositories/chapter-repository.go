@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"os"
+	"time"
+)
+
+// read the chapters of a stored file, from the ID3 CHAP frames ordered by the
+// top level CTOC frame, or from the MP4 chapters
+func ReadChapters(path string, duration time.Duration) ([]models.Chapter, error) {
+	var l []audio.Chapter
+	var err error
+
+	if isMp4File(path) {
+		l, err = audio.ReadMp4Chapters(path)
+	} else {
+		l, err = readId3Chapters(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res = make([]models.Chapter, 0, len(l))
+	for _, c := range audio.NormalizeChapters(l, duration) {
+		res = append(res, models.Chapter{
+			Title: c.Title,
+			Start: c.Start,
+			End:   c.End,
+		})
+	}
+
+	return res, nil
+}
+
+func isMp4File(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := f.Read(header); err != nil {
+		return false
+	}
+
+	return audio.IsMp4(header)
+}
+
+func readId3Chapters(path string) ([]audio.Chapter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return audio.ReadId3Chapters(f)
+}
+
+// replace the chapters of a track
+func ChapterSave(title string, artist string, l []models.Chapter) error {
+	if err := ChapterDelete(title, artist); err != nil {
+		return err
+	}
+
+	for i, c := range l {
+		err := api.Api.Database.Orm.Create(&models.ChapterEntity{
+			TrackTitle:  title,
+			TrackArtist: artist,
+			Number:      i + 1,
+			Title:       c.Title,
+			StartMs:     c.Start.Milliseconds(),
+			EndMs:       c.End.Milliseconds(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ChapterDelete(title string, artist string) error {
+	return api.Api.Database.Orm.Where(&models.ChapterEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+	}).Delete(&models.ChapterEntity{}).Error
+}
+
+func ChapterList(title string, artist string) []models.ChapterEntity {
+	var l []models.ChapterEntity
+	api.Api.Database.Orm.Where(&models.ChapterEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+	}).Order("number").Find(&l)
+
+	return l
+}
@@ -3,14 +3,21 @@ package repositories
 import (
 	"errors"
 	"fmt"
+	"github.com/Dadard29/go-warehouse/audio"
 	"github.com/Dadard29/go-warehouse/models"
 	"github.com/bogem/id3v2"
-	"github.com/h2non/filetype"
-	"io/ioutil"
 	"os"
 	"path"
 )
 
+var audioMimes = map[string]string{
+	audio.FormatMp3:  "audio/mpeg",
+	audio.FormatFlac: "audio/flac",
+	audio.FormatWav:  "audio/wav",
+	audio.FormatOgg:  "audio/ogg",
+	audio.FormatMp4:  "audio/mp4",
+}
+
 // create placeholder if needed
 func checkPlaceholder(token string) error {
 	path2check := path.Join(baseDirStore, token)
@@ -75,41 +82,68 @@ func checkAlbum(artist string, album string) error {
 	return nil
 }
 
-func CheckFileAudio(path string) bool {
-	buf, err := ioutil.ReadFile(path)
+// format guessed from the magic bytes, and its MIME type, empty if unknown
+func DetectAudioFormat(header []byte) (string, string) {
+	format := audio.DetectFormat(header)
+	return format, audioMimes[format]
+}
+
+// the format of the file, once its container or its first frames are parsed
+// only the beginning of the file is read, it may be large
+func CheckFileAudio(path string) (string, error) {
+	return audio.VerifyFormat(path)
+}
+
+func AudioMime(format string) string {
+	return audioMimes[format]
+}
+
+// check the ID3v2 tag of the file is within the limits, before parsing it
+func CheckTagLimits(path string, limits models.ParseLimits) error {
+	f, err := os.Open(path)
 	if err != nil {
-		return false
+		return err
 	}
+	defer f.Close()
 
-	return filetype.IsAudio(buf)
+	return audio.CheckId3(f, limits.MaxTagSize, limits.MaxFrames, limits.MaxPictureSize)
 }
 
-func ReadTags(path string) (models.Tags, error) {
-	var fallback models.Tags
-	f, err := id3v2.Open(path, id3v2.Options{Parse: true})
-	if err != nil {
-		return fallback, err
+// the rules an upload must follow, empty if the tags are valid
+func CheckTags(t models.Tags) []string {
+	var res = make([]string, 0)
+
+	if t.Title == "" {
+		res = append(res, "title tag empty")
 	}
 
-	if f.Title() == "" {
-		return fallback, errors.New("title tag empty")
+	if t.Artist == "" {
+		res = append(res, "artist tag empty")
 	}
 
-	if f.Artist() == "" {
-		return fallback, errors.New("artist tag empty")
+	if t.Album == "" {
+		res = append(res, "album tag empty")
 	}
 
-	if f.Album() == "" {
-		return fallback, errors.New("album tag empty")
+	if t.Genre == "" {
+		res = append(res, "genre tag empty")
 	}
 
-	if f.Genre() == "" {
-		return fallback, errors.New("genre tag empty")
+	if t.PublishedAt == "" {
+		res = append(res, "year tag empty")
 	}
 
-	if f.Year() == "" {
-		return fallback, errors.New("year tag empty")
+	return res
+}
+
+// the tags without checking them
+func ReadRawTags(path string) (models.Tags, error) {
+	var fallback models.Tags
+	f, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return fallback, err
 	}
+	defer f.Close()
 
 	return models.Tags{
 		Title:       f.Title(),
@@ -120,7 +154,57 @@ func ReadTags(path string) (models.Tags, error) {
 	}, nil
 }
 
+func ReadTags(path string) (models.Tags, error) {
+	var fallback models.Tags
+	t, err := ReadRawTags(path)
+	if err != nil {
+		return fallback, err
+	}
+
+	if failures := CheckTags(t); len(failures) > 0 {
+		return fallback, errors.New(failures[0])
+	}
+
+	return t, nil
+}
+
+// write the non empty values in the tag of the file
+func OverrideTags(path string, tags models.Tags) error {
+	f, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if tags.Title != "" {
+		f.SetTitle(tags.Title)
+	}
+	if tags.Artist != "" {
+		f.SetArtist(tags.Artist)
+	}
+	if tags.Album != "" {
+		f.SetAlbum(tags.Album)
+	}
+	if tags.PublishedAt != "" {
+		f.SetYear(tags.PublishedAt)
+	}
+	if tags.Genre != "" {
+		f.SetGenre(tags.Genre)
+	}
+
+	return f.Save()
+}
+
+// where the file would be stored
+func GetStorePath(tags models.Tags) string {
+	return getFullFilePath(tags)
+}
+
 // return true if file exist
+func FileExists(tags models.Tags) bool {
+	return checkFileExist(tags)
+}
+
 func checkFileExist(tags models.Tags) bool {
 	file2check := getFullFilePath(tags)
 	_, err := os.Stat(file2check)
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Dadard29/go-warehouse/models"
+	"hash"
+	"io"
+	"os"
+)
+
+// hash what is read through it
+type DigestReader struct {
+	r      io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func NewDigestReader(r io.Reader) *DigestReader {
+	return &DigestReader{
+		r:      r,
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+}
+
+func (d *DigestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.md5.Write(p[:n])
+	d.sha256.Write(p[:n])
+	return n, err
+}
+
+// of what was read so far
+func (d *DigestReader) Digest() models.Digest {
+	return models.Digest{
+		Md5:    hex.EncodeToString(d.md5.Sum(nil)),
+		Sha256: hex.EncodeToString(d.sha256.Sum(nil)),
+	}
+}
+
+// SHA-256 of a file, in hexadecimal
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
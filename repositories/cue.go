@@ -0,0 +1,234 @@
+package repositories
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/bogem/id3v2"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	cueFramesPerSecond = 75
+	CueMaxSize         = 1 << 20
+
+	cutTempPattern = "cut-*" + mp3Extension
+)
+
+var utf8Bom = []byte{0xEF, 0xBB, 0xBF}
+
+// split a cue line in its command and arguments, quotes are removed
+func splitCueLine(line string) []string {
+	var res = make([]string, 0)
+	var current strings.Builder
+	inQuotes := false
+	hasToken := false
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasToken {
+				res = append(res, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+
+	if hasToken {
+		res = append(res, current.String())
+	}
+
+	return res
+}
+
+// mm:ss:ff, with 75 frames per second
+func parseCueTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid cue time %s", s)
+	}
+
+	var values [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid cue time %s", s)
+		}
+		values[i] = v
+	}
+
+	if values[1] >= 60 || values[2] >= cueFramesPerSecond {
+		return 0, fmt.Errorf("invalid cue time %s", s)
+	}
+
+	frames := (values[0]*60+values[1])*cueFramesPerSecond + values[2]
+	return time.Duration(frames) * time.Second / cueFramesPerSecond, nil
+}
+
+// cue sheets are often not in UTF-8, fallback to latin-1
+func decodeCueText(data []byte) string {
+	data = bytes.TrimPrefix(data, utf8Bom)
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	var b strings.Builder
+	for _, c := range data {
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
+
+// only sheets describing a single audio file are supported
+func ParseCue(data []byte) (models.CueSheet, error) {
+	var sheet models.CueSheet
+
+	if len(data) > CueMaxSize {
+		return sheet, errors.New("cue sheet too big")
+	}
+
+	var current *models.CueTrack
+	files := 0
+	scanner := bufio.NewScanner(strings.NewReader(decodeCueText(data)))
+	for scanner.Scan() {
+		args := splitCueLine(strings.TrimSpace(scanner.Text()))
+		if len(args) == 0 {
+			continue
+		}
+
+		command := strings.ToUpper(args[0])
+		args = args[1:]
+		switch {
+		case command == "FILE":
+			files++
+			if files > 1 {
+				return sheet, errors.New("cue sheets with several files are not supported")
+			}
+
+		case command == "TRACK" && len(args) >= 1:
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return sheet, fmt.Errorf("invalid track number %s", args[0])
+			}
+			sheet.Tracks = append(sheet.Tracks, models.CueTrack{
+				Number: n,
+				Start:  -1,
+			})
+			current = &sheet.Tracks[len(sheet.Tracks)-1]
+
+		case command == "TITLE" && len(args) >= 1:
+			if current != nil {
+				current.Title = args[0]
+			} else {
+				sheet.Title = args[0]
+			}
+
+		case command == "PERFORMER" && len(args) >= 1:
+			if current != nil {
+				current.Performer = args[0]
+			} else {
+				sheet.Performer = args[0]
+			}
+
+		case command == "INDEX" && len(args) >= 2 && current != nil:
+			if args[0] != "01" && args[0] != "1" {
+				continue
+			}
+			start, err := parseCueTime(args[1])
+			if err != nil {
+				return sheet, err
+			}
+			current.Start = start
+
+		case command == "REM" && len(args) >= 2 && current == nil:
+			switch strings.ToUpper(args[0]) {
+			case "GENRE":
+				sheet.Genre = args[1]
+			case "DATE":
+				sheet.Date = args[1]
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return sheet, err
+	}
+
+	if len(sheet.Tracks) == 0 {
+		return sheet, errors.New("no track in cue sheet")
+	}
+
+	for i := range sheet.Tracks {
+		t := &sheet.Tracks[i]
+		if t.Start < 0 {
+			return sheet, fmt.Errorf("track %d has no INDEX 01", t.Number)
+		}
+		if t.Title == "" {
+			return sheet, fmt.Errorf("track %d has no title", t.Number)
+		}
+
+		if i > 0 {
+			previous := &sheet.Tracks[i-1]
+			if t.Start <= previous.Start {
+				return sheet, fmt.Errorf("track %d starts before the previous one", t.Number)
+			}
+			previous.End = t.Start
+		}
+	}
+
+	return sheet, nil
+}
+
+// write the virtual track in a temporary file, with its own tag
+// the caller is responsible for removing the returned file
+func CutVirtualTrack(m models.MusicEntity) (string, error) {
+	var p string
+
+	if !m.Virtual {
+		return p, errors.New("not a virtual track")
+	}
+
+	tmpFile, err := ioutil.TempFile(Tmp, cutTempPattern)
+	if err != nil {
+		return p, err
+	}
+	defer tmpFile.Close()
+
+	tag := id3v2.NewEmptyTag()
+	tag.SetTitle(m.Title)
+	tag.SetArtist(m.Artist)
+	tag.SetAlbum(m.Album)
+	tag.SetYear(m.PublishedAt)
+	tag.SetGenre(m.Genre)
+	tag.AddTextFrame(tag.CommonID("Track number/Position in set"),
+		tag.DefaultEncoding(), strconv.Itoa(m.TrackNumber))
+
+	if _, err := tag.WriteTo(tmpFile); err != nil {
+		os.Remove(tmpFile.Name())
+		return p, err
+	}
+
+	start := time.Duration(m.StartMs) * time.Millisecond
+	end := time.Duration(m.EndMs) * time.Millisecond
+	if err := audio.CutMp3(getFullFilePath(m.ParentTags()), start, end, tmpFile); err != nil {
+		os.Remove(tmpFile.Name())
+		return p, err
+	}
+
+	return tmpFile.Name(), nil
+}
@@ -0,0 +1,155 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+var ErrImportDenied = errors.New("url not allowed")
+
+// the link-local (cloud metadata), unspecified and multicast addresses are
+// never dialed
+func ipAlwaysDenied(ip net.IP) bool {
+	return ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+func inNetworks(l []*net.IPNet, ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostMatches(l []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range l {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkImportUrl(u *url.URL, policy models.ImportPolicy) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %s", ErrImportDenied, u.Scheme)
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials in url", ErrImportDenied)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrImportDenied)
+	}
+
+	if hostMatches(policy.DenyHosts, host) {
+		return fmt.Errorf("%w: host %s denied", ErrImportDenied, host)
+	}
+
+	if len(policy.AllowHosts) > 0 && !hostMatches(policy.AllowHosts, host) {
+		return fmt.Errorf("%w: host %s not allowed", ErrImportDenied, host)
+	}
+
+	return nil
+}
+
+// check the address actually dialed, after the resolution, so that a host
+// resolving to a denied address cannot be used
+func checkImportAddress(policy models.ImportPolicy) func(string, string, syscall.RawConn) error {
+	return func(network string, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%w: invalid address %s", ErrImportDenied, host)
+		}
+
+		if ipAlwaysDenied(ip) || inNetworks(policy.DenyNetworks, ip) {
+			return fmt.Errorf("%w: address %s denied", ErrImportDenied, ip)
+		}
+
+		// the loopback must be allowed explicitly
+		if ip.IsLoopback() && !inNetworks(policy.AllowNetworks, ip) {
+			return fmt.Errorf("%w: address %s denied", ErrImportDenied, ip)
+		}
+
+		if len(policy.AllowNetworks) > 0 && !inNetworks(policy.AllowNetworks, ip) {
+			return fmt.Errorf("%w: address %s not allowed", ErrImportDenied, ip)
+		}
+
+		return nil
+	}
+}
+
+func newImportClient(policy models.ImportPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: policy.Timeout,
+		Control: checkImportAddress(policy),
+	}
+
+	return &http.Client{
+		Timeout: policy.Timeout,
+		Transport: &http.Transport{
+			// a proxy would dial in our place
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   policy.Timeout,
+			ResponseHeaderTimeout: policy.Timeout,
+		},
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) > policy.MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkImportUrl(r.URL, policy)
+		},
+	}
+}
+
+// fetch the url and hand its body to fn, which must read it before returning
+// the declared length is given, -1 if unknown
+func FetchUrl(rawUrl string, policy models.ImportPolicy, fn func(body io.Reader, length int64) error) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	if err := checkImportUrl(u, policy); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := newImportClient(policy)
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return fn(resp.Body, resp.ContentLength)
+}
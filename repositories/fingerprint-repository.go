@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"sort"
+)
+
+// a fingerprint close enough to another one
+type FingerprintMatch struct {
+	First      models.FingerprintEntity
+	Second     models.FingerprintEntity
+	Similarity float64
+}
+
+// create or replace the fingerprint of a track
+func FingerprintSave(title string, artist string, a models.AudioInfo) error {
+	if err := FingerprintDelete(title, artist); err != nil {
+		return err
+	}
+
+	return api.Api.Database.Orm.Create(&models.FingerprintEntity{
+		Title:    title,
+		Artist:   artist,
+		Duration: a.Duration,
+		Data:     a.Fingerprint,
+	}).Error
+}
+
+func FingerprintDelete(title string, artist string) error {
+	return api.Api.Database.Orm.Where(&models.FingerprintEntity{
+		Title:  title,
+		Artist: artist,
+	}).Delete(&models.FingerprintEntity{}).Error
+}
+
+// fingerprints with a duration close to the given one
+func fingerprintCandidates(duration float64) []models.FingerprintEntity {
+	var l []models.FingerprintEntity
+	min, max := audio.CloseDurationRange(duration)
+	api.Api.Database.Orm.Where("duration BETWEEN ? AND ?", min, max).Find(&l)
+
+	var res = make([]models.FingerprintEntity, 0)
+	for _, e := range l {
+		if audio.CloseDurations(e.Duration, duration) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// tracks likely to be the same recording as the given audio, best match first
+func FingerprintSearch(title string, artist string, a models.AudioInfo) ([]FingerprintMatch, error) {
+	fp, err := audio.DecodeFingerprint(a.Fingerprint, a.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	var self = models.FingerprintEntity{
+		Title:    title,
+		Artist:   artist,
+		Duration: a.Duration,
+		Data:     a.Fingerprint,
+	}
+
+	var res = make([]FingerprintMatch, 0)
+	for _, e := range fingerprintCandidates(a.Duration) {
+		if e.Title == title && e.Artist == artist {
+			continue
+		}
+
+		other, err := audio.DecodeFingerprint(e.Data, e.Duration)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		if s := audio.Similarity(fp, other); s >= audio.DuplicateThreshold {
+			res = append(res, FingerprintMatch{
+				First:      self,
+				Second:     e,
+				Similarity: s,
+			})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Similarity > res[j].Similarity
+	})
+
+	return res, nil
+}
+
+// every pair of tracks likely to be the same recording in the library
+// the fingerprints are read in order of duration, only the ones close to the
+// current one being kept in memory
+func FingerprintDuplicates() ([]FingerprintMatch, error) {
+	rows, err := api.Api.Database.Orm.Model(&models.FingerprintEntity{}).Order("duration").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type decodedEntity struct {
+		entity models.FingerprintEntity
+		fp     audio.Fingerprint
+	}
+
+	var window = make([]decodedEntity, 0)
+	var res = make([]FingerprintMatch, 0)
+	for rows.Next() {
+		var e models.FingerprintEntity
+		if err := api.Api.Database.Orm.ScanRows(rows, &e); err != nil {
+			return nil, err
+		}
+
+		fp, err := audio.DecodeFingerprint(e.Data, e.Duration)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+
+		// sorted by duration, the ones too short for this one are too short
+		// for the next ones too
+		for len(window) > 0 && !audio.CloseDurations(window[0].entity.Duration, e.Duration) {
+			window = window[1:]
+		}
+
+		for _, w := range window {
+			if s := audio.Similarity(w.fp, fp); s >= audio.DuplicateThreshold {
+				res = append(res, FingerprintMatch{
+					First:      w.entity,
+					Second:     e,
+					Similarity: s,
+				})
+			}
+		}
+
+		window = append(window, decodedEntity{
+			entity: e,
+			fp:     fp,
+		})
+	}
+
+	return res, rows.Err()
+}
@@ -20,7 +20,7 @@ func getFullFilePath(tags models.Tags) string {
 	return path.Join(baseDirStore, tags.Artist, tags.Album, tags.Title+mp3Extension)
 }
 
-func moveFile(sourcePath, destPath string) error {
+func copyFile(sourcePath, destPath string) error {
 	inputFile, err := os.Open(sourcePath)
 	if err != nil {
 		return fmt.Errorf("couldn't open source file: %s", err)
@@ -36,8 +36,21 @@ func moveFile(sourcePath, destPath string) error {
 	if err != nil {
 		return fmt.Errorf("writing to output file failed: %s", err)
 	}
+	return nil
+}
+
+// rename when possible, so that the destination never holds a partial file
+func moveFile(sourcePath, destPath string) error {
+	if err := os.Rename(sourcePath, destPath); err == nil {
+		return nil
+	}
+
+	if err := copyFile(sourcePath, destPath); err != nil {
+		os.Remove(destPath)
+		return err
+	}
 	// The copy was successful, so now delete the original file
-	err = os.Remove(sourcePath)
+	err := os.Remove(sourcePath)
 	if err != nil {
 		return fmt.Errorf("failed removing original file: %s", err)
 	}
@@ -100,6 +113,9 @@ func RemoveFile(tags models.Tags) (models.File, error) {
 		return f, err
 	}
 
+	RemoveWaveforms(tags)
+	RemoveRenditions(p)
+
 	return models.File{
 		Filename: infos.Name(),
 		AddedAt:  infos.ModTime(),
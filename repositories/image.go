@@ -0,0 +1,155 @@
+package repositories
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/h2non/filetype"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	baseDirImages = "images"
+	coverName     = "cover"
+
+	coverRoute = "/album/cover"
+	// hexadecimal characters of the cover ids
+	coverIdSize = 32
+)
+
+var ErrBadCoverId = errors.New("invalid cover id")
+
+var coverExtensions = []string{"jpg", "png"}
+
+// prefix of the cover URLs, relative to the API if empty
+var coverBaseUrl string
+
+// create the images dir, the cover URLs being built from the public URL of the API
+// and stored as the image of the tracks
+func InitImages(baseUrl string) error {
+	coverBaseUrl = strings.TrimSuffix(baseUrl, "/")
+	if l := len(AlbumCoverUrl("", "")); l > models.ImageUrlMaxLength {
+		return fmt.Errorf("cover URLs of %d characters, above the %d of the image URLs",
+			l, models.ImageUrlMaxLength)
+	}
+
+	return os.MkdirAll(baseDirImages, 0755)
+}
+
+// extension of the image from its first bytes, if it is one of the supported ones
+func DetectImageFormat(header []byte) (string, error) {
+	t, err := filetype.Match(header)
+	if err != nil || !filetype.IsImage(header) || !contains(coverExtensions, t.Extension) {
+		return "", errors.New("not a jpg or png image")
+	}
+
+	return t.Extension, nil
+}
+
+// extension of the image, if it is one of the supported ones
+func CheckFileImage(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, SniffHeaderSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return DetectImageFormat(buf[:n])
+}
+
+// width and height of the image, only its header being decoded
+func ImageDimensions(p string) (int, int, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, errors.New("invalid image: " + err.Error())
+	}
+
+	return c.Width, c.Height, nil
+}
+
+// the covers are named after a hash of their album, so that neither the tags
+// nor the requests give a path on disk, and their URL stays short
+func AlbumCoverId(artist string, album string) string {
+	h := sha256.Sum256([]byte(artist + "\x00" + album))
+	return hex.EncodeToString(h[:])[:coverIdSize]
+}
+
+// the id of a request is a path element, only the ones of AlbumCoverId are accepted
+func checkCoverId(id string) error {
+	if len(id) != coverIdSize {
+		return ErrBadCoverId
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return ErrBadCoverId
+	}
+	return nil
+}
+
+func getCoverPath(id string, extension string) string {
+	return path.Join(baseDirImages, id+"."+extension)
+}
+
+// copy the image as the cover of the album, replacing the previous one
+func StoreAlbumCover(artist string, album string, srcPath string, extension string) error {
+	id := AlbumCoverId(artist, album)
+	if err := removeAlbumCover(id); err != nil {
+		return err
+	}
+
+	return copyFile(srcPath, getCoverPath(id, extension))
+}
+
+func removeAlbumCover(id string) error {
+	for _, ext := range coverExtensions {
+		if err := os.Remove(getCoverPath(id, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetAlbumCoverPath(id string) (string, error) {
+	if err := checkCoverId(id); err != nil {
+		return "", err
+	}
+
+	for _, ext := range coverExtensions {
+		p := getCoverPath(id, ext)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", errors.New("cover not found")
+}
+
+func AlbumHasCover(artist string, album string) bool {
+	_, err := GetAlbumCoverPath(AlbumCoverId(artist, album))
+	return err == nil
+}
+
+// served by the API, so that the cover does not depend on another host
+func AlbumCoverUrl(artist string, album string) string {
+	return coverBaseUrl + coverRoute + "?id=" + AlbumCoverId(artist, album)
+}
@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/models"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestAlbumCoverPath(t *testing.T) {
+	for _, a := range [][2]string{
+		{"Artist", "Album"},
+		{"..", ".."},
+		{"../../etc", "passwd"},
+		{"/", "\\..\\"},
+	} {
+		id := AlbumCoverId(a[0], a[1])
+		if err := checkCoverId(id); err != nil {
+			t.Errorf("%q: invalid id %q", a, id)
+		}
+		if p := getCoverPath(id, "jpg"); path.Dir(p) != baseDirImages {
+			t.Errorf("%q: cover stored at %s", a, p)
+		}
+	}
+
+	if AlbumCoverId("Artist", "Album") == AlbumCoverId("Artist", "Album 2") {
+		t.Errorf("same id for two albums")
+	}
+
+	for _, id := range []string{"", "../../etc/passwd", strings.Repeat(".", coverIdSize), strings.Repeat("a", coverIdSize+1)} {
+		if _, err := GetAlbumCoverPath(id); !errors.Is(err, ErrBadCoverId) {
+			t.Errorf("%q: expected an invalid id, got %v", id, err)
+		}
+	}
+}
+
+func TestAlbumCoverUrl(t *testing.T) {
+	defer func(u string) { coverBaseUrl = u }(coverBaseUrl)
+
+	coverBaseUrl = "https://warehouse.example.com/api"
+	long := strings.Repeat("é", 70)
+	if u := AlbumCoverUrl(long, long); len(u) > models.ImageUrlMaxLength {
+		t.Errorf("URL of %d characters", len(u))
+	}
+
+	if err := InitImages("https://" + strings.Repeat("a", models.ImageUrlMaxLength)); err == nil {
+		t.Errorf("base URL too long accepted")
+	}
+}
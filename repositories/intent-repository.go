@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"os"
+	"path"
+)
+
+const (
+	baseDirTrash = "trash"
+)
+
+// the dir is kept by the sweep of the temp dir, the files being deleted
+func InitIntents() error {
+	return os.MkdirAll(path.Join(Tmp, baseDirTrash), 0755)
+}
+
+func IntentCreate(i models.IntentEntity) (models.IntentEntity, error) {
+	var f models.IntentEntity
+
+	if i.Operation == models.IntentDelete {
+		i.TrashPath = path.Join(Tmp, baseDirTrash, i.Id+mp3Extension)
+	}
+
+	if err := api.Api.Database.Orm.Create(&i).Error; err != nil {
+		return f, err
+	}
+
+	return i, nil
+}
+
+func IntentDelete(id string) error {
+	return api.Api.Database.Orm.Where(&models.IntentEntity{
+		Id: id,
+	}).Delete(&models.IntentEntity{}).Error
+}
+
+// whether a deletion of the track is in progress, or left to the recovery
+func IntentPending(musicId string) bool {
+	var count int
+	if musicId == "" {
+		return false
+	}
+
+	api.Api.Database.Orm.Model(&models.IntentEntity{}).Where(&models.IntentEntity{
+		MusicId: musicId,
+	}).Count(&count)
+
+	return count > 0
+}
+
+// in order of creation
+func IntentList() []models.IntentEntity {
+	var l []models.IntentEntity
+	api.Api.Database.Orm.Order("created_at").Find(&l)
+
+	return l
+}
+
+// move a stored file to the trash, where it can be restored from
+func TrashFile(tags models.Tags, trashPath string) error {
+	return moveFile(getFullFilePath(tags), trashPath)
+}
+
+// nothing to do if the file is not in the trash
+func RestoreFile(tags models.Tags, trashPath string) error {
+	if _, err := os.Stat(trashPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	return moveFile(trashPath, getFullFilePath(tags))
+}
+
+// remove a trashed file replaced by another one at the same place, the files
+// derived from it being the ones of the new file, except the renditions of
+// the old version which are never served again
+func RemoveReplacedFile(trashPath string) error {
+	if err := os.Remove(trashPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// remove a trashed file and the files derived from it
+func RemoveTrashedFile(tags models.Tags, trashPath string) error {
+	if err := os.Remove(trashPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	RemoveWaveforms(tags)
+	RemoveRenditions(getFullFilePath(tags))
+	return nil
+}
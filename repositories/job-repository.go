@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	baseDirJobs = "jobs"
+)
+
+// the dir is kept by the sweep of the temp dir, the files waiting for their job
+func InitJobs() error {
+	return os.MkdirAll(path.Join(Tmp, baseDirJobs), 0755)
+}
+
+// move the staged files where they wait for their job, and create the job
+// the staged cover is optional
+func JobCreate(j models.JobEntity, stagedPath string, coverPath string) (models.JobEntity, error) {
+	var f models.JobEntity
+
+	j.StagedPath = path.Join(Tmp, baseDirJobs, j.Id+path.Ext(stagedPath))
+	if err := moveFile(stagedPath, j.StagedPath); err != nil {
+		return f, err
+	}
+
+	if coverPath != "" {
+		j.CoverPath = path.Join(Tmp, baseDirJobs, j.Id+"-cover"+path.Ext(coverPath))
+		if err := moveFile(coverPath, j.CoverPath); err != nil {
+			os.Remove(j.StagedPath)
+			return f, err
+		}
+	}
+
+	if err := api.Api.Database.Orm.Create(&j).Error; err != nil {
+		os.Remove(j.StagedPath)
+		if j.CoverPath != "" {
+			os.Remove(j.CoverPath)
+		}
+		return f, err
+	}
+
+	return j, nil
+}
+
+func JobGet(id string) (models.JobEntity, error) {
+	var f models.JobEntity
+	var j models.JobEntity
+	api.Api.Database.Orm.Where(&models.JobEntity{
+		Id: id,
+	}).First(&j)
+
+	if j.Id != id {
+		return f, errors.New("job not found")
+	}
+
+	return j, nil
+}
+
+func JobUpdate(id string, values map[string]interface{}) error {
+	values["updated_at"] = time.Now()
+	return api.Api.Database.Orm.Model(&models.JobEntity{}).Where(&models.JobEntity{
+		Id: id,
+	}).Updates(values).Error
+}
+
+// jobs not finished, in order of creation
+func JobListPending() []models.JobEntity {
+	var l []models.JobEntity
+	api.Api.Database.Orm.Where("state IN (?)", []string{
+		models.JobQueued, models.JobRunning,
+	}).Order("created_at").Find(&l)
+
+	return l
+}
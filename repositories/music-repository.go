@@ -1,9 +1,11 @@
 package repositories
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/audio"
 	"github.com/Dadard29/go-warehouse/models"
 	"time"
 )
@@ -14,6 +16,9 @@ const (
 	SearchFieldTitle  = "title"
 	SearchFieldArtist = "artist"
 	SearchFieldAlbum  = "album"
+
+	// unique index of the natural key
+	musicNaturalKey = "title_artist"
 )
 
 func musicExists(title string, artist string) bool {
@@ -21,21 +26,149 @@ func musicExists(title string, artist string) bool {
 	return err == nil
 }
 
+// random UUID (version 4), the id of a new track
+func newMusicId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
 func MusicGetFromTitle(title string, artist string) (models.MusicEntity, error) {
 	var f models.MusicEntity
 	var m models.MusicEntity
+
+	// the empty fields would be ignored by the query
+	if title == "" || artist == "" {
+		return f, errors.New("music not found")
+	}
+
 	api.Api.Database.Orm.Where(&models.MusicEntity{
 		Title:  title,
 		Artist: artist,
 	}).First(&m)
 
-	if m.Title != title && m.Artist != artist {
+	if m.Title != title || m.Artist != artist {
+		return f, errors.New("music not found")
+	}
+
+	return m, nil
+}
+
+func MusicGetFromId(id string) (models.MusicEntity, error) {
+	var f models.MusicEntity
+	var m models.MusicEntity
+
+	if id == "" {
+		return f, errors.New("music not found")
+	}
+
+	api.Api.Database.Orm.Where(&models.MusicEntity{
+		Id: id,
+	}).First(&m)
+
+	if m.Id != id {
 		return f, errors.New("music not found")
 	}
 
 	return m, nil
 }
 
+// give an id to the tracks stored before they had one, returning how many
+func MusicAssignIds() (int, error) {
+	var l []models.MusicEntity
+	if err := api.Api.Database.Orm.Where("id IS NULL OR id = ''").Find(&l).Error; err != nil {
+		return 0, err
+	}
+
+	for i, m := range l {
+		id, err := newMusicId()
+		if err != nil {
+			return i, err
+		}
+
+		// no key to tell the rows apart yet, other than the natural one
+		if err := api.Api.Database.Orm.Exec(
+			"UPDATE music SET id = ? WHERE title = ? AND artist = ? AND (id IS NULL OR id = '') LIMIT 1",
+			id, m.Title, m.Artist).Error; err != nil {
+			return i, err
+		}
+	}
+
+	return len(l), nil
+}
+
+// the rows sharing their natural key with another one, which prevent the
+// unique index from being created
+func MusicConflicts() ([]models.MusicEntity, error) {
+	var keys []models.MusicEntity
+	if err := api.Api.Database.Orm.Raw(
+		"SELECT title, artist FROM music GROUP BY title, artist HAVING COUNT(*) > 1").
+		Scan(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	var res = make([]models.MusicEntity, 0)
+	for _, k := range keys {
+		var l []models.MusicEntity
+		if err := api.Api.Database.Orm.Where(&models.MusicEntity{
+			Title:  k.Title,
+			Artist: k.Artist,
+		}).Order("added_at").Find(&l).Error; err != nil {
+			return nil, err
+		}
+		res = append(res, l...)
+	}
+
+	return res, nil
+}
+
+// add the keys missing from a table created before the tracks had an id, the
+// migration only adding the new columns
+// the ids must be assigned and the natural key free of conflicts
+func MusicAddKeys() error {
+	db := api.Api.Database.Orm
+
+	hasPrimaryKey, err := musicHasPrimaryKey()
+	if err != nil {
+		return err
+	}
+	if !hasPrimaryKey {
+		if err := db.Exec("ALTER TABLE music ADD PRIMARY KEY (id)").Error; err != nil {
+			return err
+		}
+	}
+
+	if !db.Dialect().HasIndex("music", musicNaturalKey) {
+		if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON music (title, artist)",
+			musicNaturalKey)).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// only the tables of mysql predate the ids, the other ones being created with
+// the key
+func musicHasPrimaryKey() (bool, error) {
+	db := api.Api.Database.Orm
+	if db.Dialect().GetName() != "mysql" {
+		return true, nil
+	}
+
+	var count int
+	err := db.Raw("SELECT COUNT(*) FROM information_schema.table_constraints "+
+		"WHERE table_schema = DATABASE() AND table_name = ? AND constraint_type = 'PRIMARY KEY'",
+		"music").Row().Scan(&count)
+
+	return count > 0, err
+}
+
 func MusicDelete(title string, artist string) (models.MusicEntity, error) {
 	var f models.MusicEntity
 	if !musicExists(title, artist) {
@@ -47,7 +180,11 @@ func MusicDelete(title string, artist string) (models.MusicEntity, error) {
 		return f, err
 	}
 
-	api.Api.Database.Orm.Where(&m).Delete(&m)
+	// the natural key, both fields being set
+	api.Api.Database.Orm.Where(&models.MusicEntity{
+		Title:  m.Title,
+		Artist: m.Artist,
+	}).Delete(&models.MusicEntity{})
 
 	if musicExists(title, artist) {
 		return f, errors.New("error deleting music")
@@ -56,14 +193,22 @@ func MusicDelete(title string, artist string) (models.MusicEntity, error) {
 	return m, nil
 }
 
-func MusicCreate(token string, mp models.MusicParam, t models.Tags) (models.MusicEntity, error) {
+// the track replaced by the file, if any, is deleted in the same transaction
+func MusicCreate(token string, mp models.MusicParam, file models.File) (models.MusicEntity, error) {
 	var f models.MusicEntity
+	t := file.Metadata
 
-	if musicExists(t.Title, t.Artist) {
+	if file.Replaced == nil && musicExists(t.Title, t.Artist) {
 		return f, errors.New("music already exists")
 	}
 
+	id, err := newMusicId()
+	if err != nil {
+		return f, err
+	}
+
 	var m = models.MusicEntity{
+		Id:          id,
 		Title:       t.Title,
 		Artist:      t.Artist,
 		Album:       t.Album,
@@ -72,8 +217,41 @@ func MusicCreate(token string, mp models.MusicParam, t models.Tags) (models.Musi
 		ImageUrl:    mp.ImageUrl,
 		AddedAt:     time.Now(),
 		AddedBy:     token,
+		Version:     file.Version,
+		Format:      file.Format,
+
+		Sha256:       file.Sha256,
+		UploadSha256: file.UploadSha256,
+	}
+	setAudioInfo(&m, file.Audio)
+
+	tx := api.Api.Database.Orm.Begin()
+	if tx.Error != nil {
+		return f, tx.Error
+	}
+
+	if r := file.Replaced; r != nil {
+		res := tx.Where("id = ? AND title = ? AND artist = ?", r.Music.Id, r.Music.Title, r.Music.Artist).
+			Delete(&models.MusicEntity{})
+		if res.Error != nil {
+			tx.Rollback()
+			return f, res.Error
+		}
+		if res.RowsAffected != 1 {
+			tx.Rollback()
+			return f, errors.New("replaced music not found")
+		}
+	}
+
+	// the unique key rejects a track created by a concurrent request
+	if err := tx.Create(&m).Error; err != nil {
+		tx.Rollback()
+		return f, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return f, err
 	}
-	api.Api.Database.Orm.Create(&m)
 
 	if !musicExists(t.Title, t.Artist) {
 		return f, errors.New("error storing in DB")
@@ -82,6 +260,179 @@ func MusicCreate(token string, mp models.MusicParam, t models.Tags) (models.Musi
 	return m, nil
 }
 
+// create the tracks described by the cue sheet of the parent file
+// nothing is created if one of them already exists, or appears twice in the sheet
+func MusicCreateVirtual(token string, mp models.MusicParam, parent models.MusicEntity, sheet models.CueSheet) ([]models.MusicEntity, error) {
+	var l = make([]models.MusicEntity, 0)
+	var seen = make(map[string]bool)
+
+	for _, t := range sheet.Tracks {
+		id, err := newMusicId()
+		if err != nil {
+			return nil, err
+		}
+
+		var m = models.MusicEntity{
+			Id:          id,
+			Title:       t.Title,
+			Artist:      firstNotEmpty(t.Performer, sheet.Performer, parent.Artist),
+			Album:       firstNotEmpty(sheet.Title, parent.Album),
+			PublishedAt: firstNotEmpty(sheet.Date, parent.PublishedAt),
+			Genre:       firstNotEmpty(sheet.Genre, parent.Genre),
+			ImageUrl:    mp.ImageUrl,
+			AddedAt:     time.Now(),
+			AddedBy:     token,
+
+			Virtual:      true,
+			ParentTitle:  parent.Title,
+			ParentArtist: parent.Artist,
+			ParentAlbum:  parent.Album,
+			Format:       parent.Format,
+			TrackNumber:  t.Number,
+			StartMs:      t.Start.Milliseconds(),
+			EndMs:        t.End.Milliseconds(),
+		}
+
+		key := m.Title + "\n" + m.Artist
+		if seen[key] {
+			return nil, fmt.Errorf("music %s appears twice in the cue sheet", m.Title)
+		}
+		seen[key] = true
+
+		if musicExists(m.Title, m.Artist) {
+			return nil, fmt.Errorf("music %s already exists", m.Title)
+		}
+
+		l = append(l, m)
+	}
+
+	// all of them or none, a track created concurrently failing the unique key
+	tx := api.Api.Database.Orm.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	for i := range l {
+		if err := tx.Create(&l[i]).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func firstNotEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// remove the virtual tracks cut from the given file
+func MusicDeleteChildren(title string, artist string) error {
+	return api.Api.Database.Orm.Where(&models.MusicEntity{
+		Virtual:      true,
+		ParentTitle:  title,
+		ParentArtist: artist,
+	}).Delete(&models.MusicEntity{}).Error
+}
+
+func setAudioInfo(m *models.MusicEntity, a models.AudioInfo) {
+	m.Analysed = a.Analysed
+	m.AnalysisVersion = a.Version
+	m.Loudness = a.Loudness
+	m.LoudnessBlocks = a.LoudnessBlocks
+	m.TrackPeak = a.Peak
+	m.TrackGain = audio.Loudness{
+		Integrated: a.Loudness,
+		Blocks:     a.LoudnessBlocks,
+	}.Gain()
+
+	m.Gapless = a.Gapless.Found
+	m.EncoderDelay = a.Gapless.EncoderDelay
+	m.EncoderPadding = a.Gapless.EncoderPadding
+	m.SampleCount = a.Gapless.Samples
+	m.SampleRate = a.Gapless.SampleRate
+}
+
+// store the result of an analysis made after the creation
+func MusicUpdateAudio(title string, artist string, a models.AudioInfo) error {
+	m, err := MusicGetFromTitle(title, artist)
+	if err != nil {
+		return err
+	}
+
+	setAudioInfo(&m, a)
+	return api.Api.Database.Orm.Model(&models.MusicEntity{}).Where(&models.MusicEntity{
+		Title:  title,
+		Artist: artist,
+	}).Updates(map[string]interface{}{
+		"analysed":         m.Analysed,
+		"loudness":         m.Loudness,
+		"loudness_blocks":  m.LoudnessBlocks,
+		"track_gain":       m.TrackGain,
+		"track_peak":       m.TrackPeak,
+		"analysis_version": m.AnalysisVersion,
+		"gapless":          m.Gapless,
+		"encoder_delay":    m.EncoderDelay,
+		"encoder_padding":  m.EncoderPadding,
+		"sample_count":     m.SampleCount,
+		"sample_rate":      m.SampleRate,
+	}).Error
+}
+
+func MusicListFromAlbum(artist string, album string) []models.MusicEntity {
+	var l []models.MusicEntity
+	api.Api.Database.Orm.Where(&models.MusicEntity{
+		Artist: artist,
+		Album:  album,
+	}).Find(&l)
+
+	return l
+}
+
+func MusicListFromArtist(artist string) []models.MusicEntity {
+	var l = make([]models.MusicEntity, 0)
+	// the empty field would be ignored by the query
+	if artist == "" {
+		return l
+	}
+
+	api.Api.Database.Orm.Where(&models.MusicEntity{
+		Artist: artist,
+	}).Find(&l)
+
+	return l
+}
+
+// compute the album gain and peak from its tracks and store it in each of them
+func MusicUpdateAlbumLoudness(artist string, album string) error {
+	l := AlbumLoudness(MusicListFromAlbum(artist, album))
+
+	return api.Api.Database.Orm.Model(&models.MusicEntity{}).Where(&models.MusicEntity{
+		Artist: artist,
+		Album:  album,
+	}).Updates(map[string]interface{}{
+		"album_gain": l.Gain(),
+		"album_peak": l.Peak,
+	}).Error
+}
+
+// link the tracks of the album to its cover
+func MusicUpdateAlbumImage(artist string, album string, imageUrl string) error {
+	return api.Api.Database.Orm.Model(&models.MusicEntity{}).Where(&models.MusicEntity{
+		Artist: artist,
+		Album:  album,
+	}).Update("image_url", imageUrl).Error
+}
+
 func MusicAlbumsList() []models.MusicEntity {
 	var res = make([]models.MusicEntity, 0)
 	api.Api.Database.Orm.Table("music").Select("DISTINCT album").Scan(&res)
@@ -96,7 +447,7 @@ func MusicArtistsList() []models.MusicEntity {
 	return res
 }
 
-func MusicList() ([]models.MusicEntity) {
+func MusicList() []models.MusicEntity {
 	var l []models.MusicEntity
 	api.Api.Database.Orm.Order("added_at desc").Find(&l)
 
@@ -0,0 +1,188 @@
+package repositories
+
+import (
+	"github.com/Dadard29/go-api-utils/database"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"testing"
+)
+
+// an in-memory database with the given tables, used by the repositories until
+// the returned function is called
+func setupTestDb(t *testing.T, tables ...interface{}) func() {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single connection, each one having its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(tables...).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	previous := api.Api.Database
+	api.Api.Database = &database.Connector{Orm: db}
+	return func() {
+		api.Api.Database = previous
+		db.Close()
+	}
+}
+
+func testFile(title string, artist string, album string) models.File {
+	return models.File{
+		Metadata: models.Tags{
+			Title:  title,
+			Artist: artist,
+			Album:  album,
+		},
+		Version: 1,
+		Format:  models.TypeMp3,
+	}
+}
+
+func TestMusicCreateReplacing(t *testing.T) {
+	defer setupTestDb(t, &models.MusicEntity{})()
+
+	old, err := MusicCreate("token", models.MusicParam{}, testFile("Song", "Artist", "First"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MusicCreate("token", models.MusicParam{}, testFile("Song", "Artist", "Second")); err == nil {
+		t.Fatalf("created twice without replacing")
+	}
+
+	// a replaced row which is not the stored one anymore
+	stale := testFile("Song", "Artist", "Second")
+	stale.Replaced = &models.Replacement{Music: models.MusicEntity{
+		Id:     "00000000-0000-4000-8000-000000000000",
+		Title:  "Song",
+		Artist: "Artist",
+	}}
+	if _, err := MusicCreate("token", models.MusicParam{}, stale); err == nil {
+		t.Fatalf("created while replacing a missing row")
+	}
+	if m, err := MusicGetFromTitle("Song", "Artist"); err != nil || m.Id != old.Id {
+		t.Fatalf("stored row changed by a failed replacement: %+v, %v", m, err)
+	}
+
+	replacing := testFile("Song", "Artist", "Second")
+	replacing.Replaced = &models.Replacement{Music: old}
+	m, err := MusicCreate("token", models.MusicParam{}, replacing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Id == old.Id || m.Album != "Second" {
+		t.Errorf("unexpected replacement %+v", m)
+	}
+
+	if _, err := MusicGetFromId(old.Id); err == nil {
+		t.Errorf("replaced row still stored")
+	}
+	if got, err := MusicGetFromTitle("Song", "Artist"); err != nil || got.Id != m.Id {
+		t.Errorf("replacement not stored: %+v, %v", got, err)
+	}
+}
+
+func TestMusicGetFromTitle(t *testing.T) {
+	defer setupTestDb(t, &models.MusicEntity{})()
+
+	m, err := MusicCreate("token", models.MusicParam{}, testFile("Song", "Artist", "Album"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MusicCreate("token", models.MusicParam{}, testFile("Song", "Other", "Album")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := MusicGetFromTitle("Song", "Artist")
+	if err != nil || got.Id != m.Id || got.Album != "Album" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	// the empty fields would match every row
+	for _, k := range [][2]string{{"Song", ""}, {"", "Artist"}, {"", ""}, {"Song", "Nobody"}} {
+		if got, err := MusicGetFromTitle(k[0], k[1]); err == nil {
+			t.Errorf("%q: found %+v", k, got)
+		}
+	}
+}
+
+func TestMusicGetFromId(t *testing.T) {
+	defer setupTestDb(t, &models.MusicEntity{})()
+
+	m, err := MusicCreate("token", models.MusicParam{}, testFile("Song", "Artist", "Album"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Id) != 36 {
+		t.Fatalf("unexpected id %q", m.Id)
+	}
+
+	got, err := MusicGetFromId(m.Id)
+	if err != nil || got.Title != "Song" || got.Artist != "Artist" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	for _, id := range []string{"", "00000000-0000-4000-8000-000000000000"} {
+		if got, err := MusicGetFromId(id); err == nil {
+			t.Errorf("%q: found %+v", id, got)
+		}
+	}
+}
+
+func TestMusicAddKeys(t *testing.T) {
+	defer setupTestDb(t)()
+
+	// a table of before the ids, without the keys
+	db := api.Api.Database.Orm
+	if err := db.Exec("CREATE TABLE music (id char(36), title varchar(70), artist varchar(70), " +
+		"album varchar(70), added_at datetime)").Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][3]string{
+		{"00000000-0000-4000-8000-000000000001", "Song", "First"},
+		{"00000000-0000-4000-8000-000000000002", "Song", "Second"},
+		{"00000000-0000-4000-8000-000000000003", "Other", "First"},
+	} {
+		if err := db.Exec("INSERT INTO music (id, title, artist, album) VALUES (?, ?, 'Artist', ?)",
+			r[0], r[1], r[2]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conflicts, err := MusicConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 2 || conflicts[0].Title != "Song" || conflicts[1].Title != "Song" {
+		t.Fatalf("unexpected conflicts %+v", conflicts)
+	}
+	if err := MusicAddKeys(); err == nil {
+		t.Fatalf("unique key added despite the conflicts")
+	}
+
+	if err := db.Exec("DELETE FROM music WHERE album = 'Second'").Error; err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, err := MusicConflicts(); err != nil || len(conflicts) != 0 {
+		t.Fatalf("conflicts left: %+v, %v", conflicts, err)
+	}
+	if err := MusicAddKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Dialect().HasIndex("music", musicNaturalKey) {
+		t.Errorf("unique key not added")
+	}
+
+	// added once
+	if err := MusicAddKeys(); err != nil {
+		t.Errorf("keys added twice: %s", err)
+	}
+	if err := db.Exec("INSERT INTO music (id, title, artist) VALUES ('x', 'Other', 'Artist')").Error; err == nil {
+		t.Errorf("natural key not enforced")
+	}
+}
@@ -0,0 +1,344 @@
+package repositories
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/bogem/id3v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transcoded versions of the stored files, kept in a size bounded LRU cache
+
+const (
+	baseDirRendition = "renditions"
+	renditionPartExt = ".part"
+)
+
+type RenditionProfile struct {
+	Name        string
+	Format      string
+	Extension   string
+	ContentType string
+
+	// kbps, for the lossy formats only
+	Bitrate int
+}
+
+var renditionProfiles = map[string]RenditionProfile{
+	"mp3-64": {
+		Name:        "mp3-64",
+		Format:      audio.FormatMp3,
+		Extension:   ".mp3",
+		ContentType: "audio/mpeg",
+		Bitrate:     64,
+	},
+	"mp3-96": {
+		Name:        "mp3-96",
+		Format:      audio.FormatMp3,
+		Extension:   ".mp3",
+		ContentType: "audio/mpeg",
+		Bitrate:     96,
+	},
+	"mp3-128": {
+		Name:        "mp3-128",
+		Format:      audio.FormatMp3,
+		Extension:   ".mp3",
+		ContentType: "audio/mpeg",
+		Bitrate:     128,
+	},
+	"wav": {
+		Name:        "wav",
+		Format:      audio.FormatWav,
+		Extension:   ".wav",
+		ContentType: "audio/wav",
+	},
+}
+
+// the audio a rendition is transcoded from, a stored file or the part of its
+// parent file covered by a virtual track
+type RenditionSource struct {
+	Path  string
+	Track *models.MusicEntity
+}
+
+// the renditions of the track depend on the file of its parent
+func NewVirtualRenditionSource(m models.MusicEntity) (RenditionSource, error) {
+	var s RenditionSource
+
+	if !m.Virtual {
+		return s, errors.New("not a virtual track")
+	}
+
+	p, err := GetFilePathForDownload(m.ParentTags())
+	if err != nil {
+		return s, err
+	}
+
+	return RenditionSource{Path: p, Track: &m}, nil
+}
+
+// distinguish the tracks cut from the same file
+func (s RenditionSource) trackKey() string {
+	if s.Track == nil {
+		return ""
+	}
+
+	return hashString(fmt.Sprintf("%s-%d-%d", s.Track.Id, s.Track.StartMs, s.Track.EndMs))[:16] + "-"
+}
+
+type renditionEntry struct {
+	name string
+	size int64
+}
+
+var renditions = struct {
+	sync.Mutex
+	maxSize int64
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}{
+	order:   list.New(),
+	entries: make(map[string]*list.Element),
+}
+
+func GetRenditionProfile(name string) (RenditionProfile, bool) {
+	p, ok := renditionProfiles[name]
+	return p, ok
+}
+
+// load the renditions already on disk, least recently used first
+func InitRenditionCache(maxSize int64) error {
+	if err := os.MkdirAll(baseDirRendition, 0755); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(baseDirRendition)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	renditions.Lock()
+	defer renditions.Unlock()
+
+	renditions.maxSize = maxSize
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		// interrupted transcoding
+		if strings.HasSuffix(f.Name(), renditionPartExt) {
+			os.Remove(path.Join(baseDirRendition, f.Name()))
+			continue
+		}
+
+		renditions.entries[f.Name()] = renditions.order.PushBack(renditionEntry{
+			name: f.Name(),
+			size: f.Size(),
+		})
+		renditions.size += f.Size()
+	}
+
+	evictRenditions()
+	return nil
+}
+
+func hashString(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// the name is made of the source, of its version, of the track cut from it
+// and of the profile, so a rendition of a modified source is never served
+func renditionName(source RenditionSource, profile RenditionProfile) (string, error) {
+	prefix, err := renditionVersionPrefix(source.Path)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + source.trackKey() + profile.Name + profile.Extension, nil
+}
+
+func renditionPrefix(sourcePath string) string {
+	return hashString(sourcePath)[:16] + "-"
+}
+
+func renditionVersionPrefix(sourcePath string) (string, error) {
+	infos, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	version := fmt.Sprintf("%d-%d", infos.ModTime().UnixNano(), infos.Size())
+	return renditionPrefix(sourcePath) + hashString(version)[:16] + "-", nil
+}
+
+// must be called with the lock held
+func evictRenditions() {
+	for renditions.size > renditions.maxSize && renditions.order.Len() > 0 {
+		removeRendition(renditions.order.Front())
+	}
+}
+
+// must be called with the lock held
+func removeRendition(e *list.Element) {
+	entry := e.Value.(renditionEntry)
+	renditions.order.Remove(e)
+	delete(renditions.entries, entry.name)
+	renditions.size -= entry.size
+
+	err := os.Remove(path.Join(baseDirRendition, entry.name))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error(err.Error())
+	}
+}
+
+// remove the renditions of a source, outdated or not
+func RemoveRenditions(sourcePath string) {
+	renditions.Lock()
+	defer renditions.Unlock()
+
+	removeRenditions(renditionPrefix(sourcePath), "")
+}
+
+// remove the renditions of a source except the ones of the current version
+// must be called with the lock held
+func removeRenditions(prefix string, keepPrefix string) {
+	for name, e := range renditions.entries {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if keepPrefix != "" && strings.HasPrefix(name, keepPrefix) {
+			continue
+		}
+
+		removeRendition(e)
+	}
+}
+
+// path of the cached rendition, if any
+func GetRendition(source RenditionSource, profile RenditionProfile) (string, bool) {
+	name, err := renditionName(source, profile)
+	if err != nil {
+		return "", false
+	}
+
+	renditions.Lock()
+	defer renditions.Unlock()
+
+	e, ok := renditions.entries[name]
+	if !ok {
+		return "", false
+	}
+
+	renditions.order.MoveToBack(e)
+	p := path.Join(baseDirRendition, name)
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return p, true
+}
+
+// transcode the source to w, and keep the result in the cache
+func StreamRendition(source RenditionSource, profile RenditionProfile, w io.Writer) error {
+	name, err := renditionName(source, profile)
+	if err != nil {
+		return err
+	}
+
+	// a virtual track is transcoded from its cut, tagged as the track
+	transcodePath := source.Path
+	if source.Track != nil {
+		transcodePath, err = CutVirtualTrack(*source.Track)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(transcodePath)
+	}
+
+	part, err := ioutil.TempFile(baseDirRendition, "*"+renditionPartExt)
+	if err != nil {
+		return err
+	}
+	partPath := part.Name()
+
+	out := io.MultiWriter(w, part)
+	if profile.Format == audio.FormatMp3 {
+		err = writeSourceTag(transcodePath, out)
+	}
+	if err == nil {
+		err = audio.Transcode(transcodePath, profile.Format, profile.Bitrate, out)
+	}
+	part.Close()
+
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+
+	infos, err := os.Stat(partPath)
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+
+	renditions.Lock()
+	defer renditions.Unlock()
+
+	if err := os.Rename(partPath, path.Join(baseDirRendition, name)); err != nil {
+		os.Remove(partPath)
+		return err
+	}
+
+	if e, ok := renditions.entries[name]; ok {
+		// transcoded concurrently
+		renditions.size -= e.Value.(renditionEntry).size
+		renditions.order.Remove(e)
+	}
+	renditions.entries[name] = renditions.order.PushBack(renditionEntry{
+		name: name,
+		size: infos.Size(),
+	})
+	renditions.size += infos.Size()
+
+	if current, err := renditionVersionPrefix(source.Path); err == nil {
+		removeRenditions(renditionPrefix(source.Path), current)
+	}
+	evictRenditions()
+	return nil
+}
+
+// copy the ID3 tag of the source at the beginning of a mp3 rendition
+func writeSourceTag(sourcePath string, w io.Writer) error {
+	tag, err := id3v2.Open(sourcePath, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+
+	if !tag.HasFrames() {
+		return nil
+	}
+
+	_, err = tag.WriteTo(w)
+	return err
+}
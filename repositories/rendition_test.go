@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"github.com/Dadard29/go-warehouse/models"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRenditionNameVirtual(t *testing.T) {
+	f, err := ioutil.TempFile("", "parent-*.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	profile := renditionProfiles["mp3-128"]
+	parent := RenditionSource{Path: f.Name()}
+	first := RenditionSource{Path: f.Name(), Track: &models.MusicEntity{
+		Id: "00000000-0000-4000-8000-000000000001", Virtual: true, EndMs: 60000,
+	}}
+	second := RenditionSource{Path: f.Name(), Track: &models.MusicEntity{
+		Id: "00000000-0000-4000-8000-000000000002", Virtual: true, StartMs: 60000,
+	}}
+
+	names := make(map[string]bool)
+	for _, s := range []RenditionSource{parent, first, second} {
+		name, err := renditionName(s, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names[name] {
+			t.Errorf("%s shared by two tracks", name)
+		}
+		names[name] = true
+
+		// removed with the renditions of the parent file
+		if !strings.HasPrefix(name, renditionPrefix(f.Name())) {
+			t.Errorf("%s not named after the parent file", name)
+		}
+	}
+}
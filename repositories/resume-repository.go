@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"time"
+)
+
+func ResumeGet(token string, title string, artist string) (models.ResumeEntity, error) {
+	var f models.ResumeEntity
+	var r models.ResumeEntity
+	api.Api.Database.Orm.Where(&models.ResumeEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+		User:        token,
+	}).First(&r)
+
+	if r.User != token || r.TrackTitle != title || r.TrackArtist != artist {
+		return f, errors.New("resume position not found")
+	}
+
+	return r, nil
+}
+
+// create or update the position of the user in the track
+func ResumeSave(token string, title string, artist string, position time.Duration) (models.ResumeEntity, error) {
+	var f models.ResumeEntity
+
+	r := models.ResumeEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+		User:        token,
+		PositionMs:  position.Milliseconds(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if _, err := ResumeGet(token, title, artist); err != nil {
+		if err := api.Api.Database.Orm.Create(&r).Error; err != nil {
+			return f, err
+		}
+		return r, nil
+	}
+
+	err := api.Api.Database.Orm.Model(&models.ResumeEntity{}).Where(&models.ResumeEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+		User:        token,
+	}).Updates(map[string]interface{}{
+		"position_ms": r.PositionMs,
+		"updated_at":  r.UpdatedAt,
+	}).Error
+	if err != nil {
+		return f, err
+	}
+
+	return r, nil
+}
+
+// positions of all the users in a track
+func ResumeDeleteFromTrack(title string, artist string) error {
+	return api.Api.Database.Orm.Where(&models.ResumeEntity{
+		TrackTitle:  title,
+		TrackArtist: artist,
+	}).Delete(&models.ResumeEntity{}).Error
+}
@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"github.com/bogem/id3v2"
+)
+
+const (
+	frameComment         = "COMM"
+	frameUserDefinedText = "TXXX"
+	framePicture         = "APIC"
+	frameChapter         = "CHAP"
+	frameTableOfContents = "CTOC"
+)
+
+// frames renamed between ID3v2.3 and ID3v2.4
+var framesV3ToV4 = map[string]string{
+	"TYER": "TDRC",
+	"TORY": "TDOR",
+}
+
+// frames without equivalent in the other version, removed on conversion
+var framesOnlyV3 = []string{"TDAT", "TIME", "TRDA", "TSIZ", "EQUA", "RVAD"}
+var framesOnlyV4 = []string{
+	"TDRL", "TDTG", "TSST", "TMOO", "TPRO", "TSOA", "TSOP", "TSOT",
+	"ASPI", "EQU2", "RVA2", "SEEK", "SIGN",
+}
+
+func contains(l []string, v string) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// comments needed by the gapless playback are always kept
+func isGaplessComment(f id3v2.Framer) bool {
+	c, ok := f.(id3v2.CommentFrame)
+	return ok && c.Description == iTunSMPBDescription
+}
+
+// rewrite the tag of the file according to the policy
+func SanitizeTags(path string, policy models.SanitizePolicy) error {
+	if policy.Version != 0 && policy.Version != 3 && policy.Version != 4 {
+		return errors.New("unsupported ID3v2 version")
+	}
+
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+
+	version := tag.Version()
+	if policy.Version != 0 {
+		version = policy.Version
+	}
+
+	// ID3v2.3 does not support UTF-8
+	encoding := id3v2.EncodingUTF8
+	if version == 3 {
+		encoding = id3v2.EncodingUTF16
+	}
+
+	from := tag.Version()
+	frames := tag.AllFrames()
+	tag.DeleteAllFrames()
+	tag.SetVersion(version)
+	tag.SetDefaultEncoding(encoding)
+
+	for id, l := range frames {
+		id = convertFrameId(id, version)
+		if id == "" {
+			continue
+		}
+
+		for _, f := range l {
+			if !frameAllowed(id, f, policy) {
+				continue
+			}
+
+			if err := addFrame(tag, id, f, encoding, from); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tag.Save()
+}
+
+// return the id of the frame in the given version, empty if it does not exist
+func convertFrameId(id string, version byte) string {
+	if version == 4 {
+		if contains(framesOnlyV3, id) {
+			return ""
+		}
+		if v4, ok := framesV3ToV4[id]; ok {
+			return v4
+		}
+		return id
+	}
+
+	if version == 3 {
+		if contains(framesOnlyV4, id) {
+			return ""
+		}
+		for v3, v4 := range framesV3ToV4 {
+			if id == v4 {
+				return v3
+			}
+		}
+	}
+
+	return id
+}
+
+func frameAllowed(id string, f id3v2.Framer, policy models.SanitizePolicy) bool {
+	if id == frameComment && isGaplessComment(f) {
+		return true
+	}
+
+	if len(policy.KeepFrames) > 0 && !contains(policy.KeepFrames, id) {
+		return false
+	}
+
+	if contains(policy.StripFrames, id) {
+		return false
+	}
+
+	if p, ok := f.(id3v2.PictureFrame); ok && policy.MaxPictureSize > 0 &&
+		len(p.Picture) > policy.MaxPictureSize {
+		return false
+	}
+
+	return true
+}
+
+// add the frame back, with its text converted to the given encoding, and the
+// frames embedded in the chapters converted to the version of the tag
+func addFrame(tag *id3v2.Tag, id string, f id3v2.Framer, encoding id3v2.Encoding, from byte) error {
+	switch v := f.(type) {
+	case id3v2.TextFrame:
+		tag.AddTextFrame(id, encoding, v.Text)
+	case id3v2.UserDefinedTextFrame:
+		v.Encoding = encoding
+		tag.AddUserDefinedTextFrame(v)
+	case id3v2.CommentFrame:
+		v.Encoding = encoding
+		tag.AddCommentFrame(v)
+	case id3v2.PictureFrame:
+		v.Encoding = encoding
+		tag.AddAttachedPicture(v)
+	case id3v2.UnknownFrame:
+		if id == frameChapter || id == frameTableOfContents {
+			body, err := audio.ConvertChapterFrame(id, v.Body, from, tag.Version())
+			if err != nil {
+				return err
+			}
+			v.Body = body
+		}
+		tag.AddFrame(id, v)
+	default:
+		tag.AddFrame(id, f)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/h2non/filetype"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	stagingPrefix = "staging-"
+
+	// enough for the magic numbers known by filetype
+	SniffHeaderSize = 262
+
+	gitKeep = ".gitkeep"
+)
+
+var ErrFileTooBig = errors.New("file too big")
+
+// extension and MIME type guessed from the first bytes of a file
+func SniffType(header []byte) (string, string, error) {
+	t, err := filetype.Match(header)
+	if err != nil {
+		return "", "", err
+	}
+
+	if t == filetype.Unknown {
+		return "", "", errors.New("unknown file type")
+	}
+
+	return t.Extension, t.MIME.Value, nil
+}
+
+// copy the stream in a staging file of its own, without holding it in memory
+// the partial file is removed on error, or if more than max bytes are read
+func StageFile(r io.Reader, max int64, requestId string, extension string) (string, error) {
+	var p string
+
+	stagingPath := path.Join(Tmp, stagingPrefix+requestId+"."+extension)
+	f, err := os.OpenFile(stagingPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return p, err
+	}
+
+	// one more byte to detect the files too big
+	n, err := io.Copy(f, io.LimitReader(r, max+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > max {
+		err = ErrFileTooBig
+	}
+
+	if err != nil {
+		os.Remove(stagingPath)
+		return p, err
+	}
+
+	return stagingPath, nil
+}
+
+// remove the files of the temp dir not modified since maxAge, left by a crash
+// or a request which failed to clean up
+// the directories are kept, they belong to other processes
+func SweepTmp(maxAge time.Duration) (int, error) {
+	files, err := ioutil.ReadDir(Tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	limit := time.Now().Add(-maxAge)
+	for _, f := range files {
+		if f.IsDir() || f.Name() == gitKeep || f.ModTime().After(limit) {
+			continue
+		}
+
+		if err := os.Remove(path.Join(Tmp, f.Name())); err != nil && !os.IsNotExist(err) {
+			logger.Error(err.Error())
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"errors"
+	"github.com/Dadard29/go-warehouse/api"
+	"github.com/Dadard29/go-warehouse/models"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	baseDirTus = "tus"
+	tusPartExt = ".part"
+)
+
+func getTusPath(id string) string {
+	return path.Join(Tmp, baseDirTus, id+tusPartExt)
+}
+
+// the dir is kept by the sweep of the temp dir, the expiration handles it
+func InitTus() error {
+	return os.MkdirAll(path.Join(Tmp, baseDirTus), 0755)
+}
+
+// create the empty partial file and its row
+func TusCreate(t models.TusUploadEntity) error {
+	f, err := os.OpenFile(getTusPath(t.Id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := api.Api.Database.Orm.Create(&t).Error; err != nil {
+		os.Remove(getTusPath(t.Id))
+		return err
+	}
+
+	return nil
+}
+
+func TusGet(id string) (models.TusUploadEntity, error) {
+	var f models.TusUploadEntity
+	var t models.TusUploadEntity
+	api.Api.Database.Orm.Where(&models.TusUploadEntity{
+		Id: id,
+	}).First(&t)
+
+	if t.Id != id {
+		return f, errors.New("upload not found")
+	}
+
+	return t, nil
+}
+
+func TusOffset(id string) (int64, error) {
+	infos, err := os.Stat(getTusPath(id))
+	if err != nil {
+		return 0, err
+	}
+
+	return infos.Size(), nil
+}
+
+func TusPath(id string) string {
+	return getTusPath(id)
+}
+
+// append at most max bytes of the chunk to the partial file
+// the bytes received before an interruption are kept, as the client resumes
+// from the offset
+func TusWrite(id string, r io.Reader, max int64) (int64, error) {
+	f, err := os.OpenFile(getTusPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, max))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+
+	return n, err
+}
+
+// cut the partial file back to the given size, to discard a chunk
+func TusTruncate(id string, size int64) error {
+	return os.Truncate(getTusPath(id), size)
+}
+
+// record the outcome of the processing of a complete upload, the error being
+// empty on success
+func TusComplete(id string, title string, artist string, uploadErr string) error {
+	values := map[string]interface{}{
+		"done":   true,
+		"title":  title,
+		"artist": artist,
+		"error":  uploadErr,
+	}
+
+	return api.Api.Database.Orm.Model(&models.TusUploadEntity{}).Where(&models.TusUploadEntity{
+		Id: id,
+	}).Updates(values).Error
+}
+
+// remove the partial file, if still there
+func TusRemovePart(id string) error {
+	if err := os.Remove(getTusPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// remove the partial file and the row
+func TusDelete(id string) error {
+	if err := TusRemovePart(id); err != nil {
+		return err
+	}
+
+	return api.Api.Database.Orm.Where(&models.TusUploadEntity{
+		Id: id,
+	}).Delete(&models.TusUploadEntity{}).Error
+}
+
+func TusListExpired(now time.Time) []models.TusUploadEntity {
+	var l []models.TusUploadEntity
+	api.Api.Database.Orm.Where("expires_at < ?", now).Find(&l)
+
+	return l
+}
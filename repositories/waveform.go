@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Dadard29/go-warehouse/audio"
+	"github.com/Dadard29/go-warehouse/models"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	baseDirWaveform    = "waveforms"
+	waveformExtension  = ".dat"
+	waveformTmpPattern = "waveform-*" + waveformExtension
+)
+
+func getWaveformPath(tags models.Tags, samplesPerPixel int) string {
+	return path.Join(baseDirWaveform, tags.Artist, tags.Album,
+		fmt.Sprintf("%s.%d%s", tags.Title, samplesPerPixel, waveformExtension))
+}
+
+func CheckWaveformResolution(samplesPerPixel int) bool {
+	for _, r := range audio.WaveformResolutions {
+		if r == samplesPerPixel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write the peaks of every resolution in the cache
+func StoreWaveforms(tags models.Tags, waveforms []audio.Waveform) error {
+	dir := path.Join(baseDirWaveform, tags.Artist, tags.Album)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, w := range waveforms {
+		data, err := w.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		// write then rename so that a concurrent read never gets a partial file
+		tmpFile, err := ioutil.TempFile(dir, waveformTmpPattern)
+		if err != nil {
+			return err
+		}
+		_, err = tmpFile.Write(data)
+		tmpFile.Close()
+		if err == nil {
+			err = os.Rename(tmpFile.Name(), getWaveformPath(tags, w.SamplesPerPixel))
+		}
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decode the stored file to compute its peaks
+func BuildWaveforms(tags models.Tags) ([]audio.Waveform, error) {
+	builder := audio.NewWaveformBuilder(audio.WaveformResolutions...)
+	if err := audio.Scan(getFullFilePath(tags), builder); err != nil {
+		return nil, err
+	}
+
+	return builder.Result(), nil
+}
+
+// get the peaks from the cache, tracks stored before the cache existed are
+// computed on first request
+func GetWaveform(tags models.Tags, samplesPerPixel int) (audio.Waveform, error) {
+	var w audio.Waveform
+
+	data, err := ioutil.ReadFile(getWaveformPath(tags, samplesPerPixel))
+	if err == nil {
+		err = w.UnmarshalBinary(data)
+		return w, err
+	}
+	if !os.IsNotExist(err) {
+		return w, err
+	}
+
+	if !checkFileExist(tags) {
+		return w, errors.New("file not found")
+	}
+
+	waveforms, err := BuildWaveforms(tags)
+	if err != nil {
+		return w, err
+	}
+
+	if err := StoreWaveforms(tags, waveforms); err != nil {
+		logger.Error(err.Error())
+	}
+
+	for _, v := range waveforms {
+		if v.SamplesPerPixel == samplesPerPixel {
+			return v, nil
+		}
+	}
+
+	return w, errors.New("resolution not available")
+}
+
+func RemoveWaveforms(tags models.Tags) {
+	for _, r := range audio.WaveformResolutions {
+		err := os.Remove(getWaveformPath(tags, r))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error(err.Error())
+		}
+	}
+}
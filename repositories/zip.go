@@ -0,0 +1,304 @@
+package repositories
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// the archives are streamed with stored entries only, so that their size is
+// known before writing them
+// the CRC of each entry is computed while streaming it, and written after the
+// data in a descriptor, the way the zip tools do when writing to a pipe
+
+const (
+	zipLocalHeaderSignature   = 0x04034b50
+	zipDescriptorSignature    = 0x08074b50
+	zipCentralHeaderSignature = 0x02014b50
+	zipEnd64Signature         = 0x06064b50
+	zipEnd64LocatorSignature  = 0x07064b50
+	zipEndSignature           = 0x06054b50
+
+	zipLocalHeaderSize   = 30
+	zipCentralHeaderSize = 46
+	zipEnd64Size         = 56
+	zipEnd64LocatorSize  = 20
+	zipEndSize           = 22
+
+	zipVersion   = 20
+	zipVersion64 = 45
+
+	// sizes in the descriptor, names in UTF-8
+	zipFlags = 0x8 | 0x800
+
+	zipExtra64Id = 0x0001
+
+	zipMax16 = 0xffff
+	zipMax32 = 0xffffffff
+)
+
+// entry of an archive, read from a file or held in memory
+type ZipEntry struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+
+	path string
+	data []byte
+}
+
+// entry of the file, its size being the current one
+func NewZipFileEntry(name string, p string) (ZipEntry, error) {
+	infos, err := os.Stat(p)
+	if err != nil {
+		return ZipEntry{}, err
+	}
+
+	return ZipEntry{
+		Name:     name,
+		Size:     infos.Size(),
+		Modified: infos.ModTime(),
+		path:     p,
+	}, nil
+}
+
+func NewZipDataEntry(name string, data []byte, modified time.Time) ZipEntry {
+	return ZipEntry{
+		Name:     name,
+		Size:     int64(len(data)),
+		Modified: modified,
+		data:     data,
+	}
+}
+
+// the sizes too large for the regular headers are in the zip64 extra field
+func (e ZipEntry) zip64() bool {
+	return e.Size >= zipMax32
+}
+
+func (e ZipEntry) localHeaderSize() int64 {
+	n := int64(zipLocalHeaderSize + len(e.Name))
+	if e.zip64() {
+		n += 4 + 16
+	}
+	return n
+}
+
+func (e ZipEntry) descriptorSize() int64 {
+	if e.zip64() {
+		return 24
+	}
+	return 16
+}
+
+func (e ZipEntry) centralExtraSize(offset int64) int {
+	var n int
+	if e.zip64() {
+		n += 16
+	}
+	if offset >= zipMax32 {
+		n += 8
+	}
+	if n > 0 {
+		n += 4
+	}
+	return n
+}
+
+// offsets of the local headers, then of the central directory and its size
+func zipLayout(entries []ZipEntry) ([]int64, int64, int64) {
+	var offsets = make([]int64, len(entries))
+	var offset int64
+	for i, e := range entries {
+		offsets[i] = offset
+		offset += e.localHeaderSize() + e.Size + e.descriptorSize()
+	}
+
+	var cdSize int64
+	for i, e := range entries {
+		cdSize += int64(zipCentralHeaderSize + len(e.Name) + e.centralExtraSize(offsets[i]))
+	}
+
+	return offsets, offset, cdSize
+}
+
+func zipEnd64(count int, cdOffset int64, cdSize int64) bool {
+	return count >= zipMax16 || cdOffset >= zipMax32 || cdSize >= zipMax32
+}
+
+// exact size of the archive written by WriteZip
+func ZipSize(entries []ZipEntry) int64 {
+	_, cdOffset, cdSize := zipLayout(entries)
+
+	n := cdOffset + cdSize + zipEndSize
+	if zipEnd64(len(entries), cdOffset, cdSize) {
+		n += zipEnd64Size + zipEnd64LocatorSize
+	}
+	return n
+}
+
+// MS-DOS time and date, which cannot go before 1980
+func zipDosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1),
+		uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
+
+// little endian fields, written in order
+func zipFields(values ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+// stream the archive, failing if a file changed size since its entry was made
+func WriteZip(w io.Writer, entries []ZipEntry) error {
+	offsets, cdOffset, cdSize := zipLayout(entries)
+	var crcs = make([]uint32, len(entries))
+
+	for i, e := range entries {
+		crc, err := writeZipEntry(w, e)
+		if err != nil {
+			return err
+		}
+		crcs[i] = crc
+	}
+
+	for i, e := range entries {
+		if err := writeZipCentralHeader(w, e, offsets[i], crcs[i]); err != nil {
+			return err
+		}
+	}
+
+	return writeZipEnd(w, len(entries), cdOffset, cdSize)
+}
+
+func writeZipEntry(w io.Writer, e ZipEntry) (uint32, error) {
+	modTime, modDate := zipDosTime(e.Modified)
+
+	var size uint32
+	var extra []byte
+	version := uint16(zipVersion)
+	if e.zip64() {
+		// the actual sizes are in the descriptor
+		size = zipMax32
+		extra = zipFields(uint16(zipExtra64Id), uint16(16), uint64(0), uint64(0))
+		version = zipVersion64
+	}
+
+	header := zipFields(uint32(zipLocalHeaderSignature), version, uint16(zipFlags),
+		uint16(0), modTime, modDate, uint32(0), size, size,
+		uint16(len(e.Name)), uint16(len(extra)))
+	if _, err := w.Write(append(append(header, e.Name...), extra...)); err != nil {
+		return 0, err
+	}
+
+	crc, err := writeZipData(w, e)
+	if err != nil {
+		return 0, err
+	}
+
+	var descriptor []byte
+	if e.zip64() {
+		descriptor = zipFields(uint32(zipDescriptorSignature), crc, uint64(e.Size), uint64(e.Size))
+	} else {
+		descriptor = zipFields(uint32(zipDescriptorSignature), crc, uint32(e.Size), uint32(e.Size))
+	}
+	if _, err := w.Write(descriptor); err != nil {
+		return 0, err
+	}
+
+	return crc, nil
+}
+
+func writeZipData(w io.Writer, e ZipEntry) (uint32, error) {
+	hash := crc32.NewIEEE()
+	out := io.MultiWriter(w, hash)
+
+	if e.path == "" {
+		_, err := out.Write(e.data)
+		return hash.Sum32(), err
+	}
+
+	f, err := os.Open(e.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// the size was announced, the archive would be corrupted otherwise
+	n, err := io.Copy(out, io.LimitReader(f, e.Size))
+	if err != nil {
+		return 0, err
+	}
+	if n != e.Size {
+		return 0, errors.New("file " + e.Name + " changed while archived")
+	}
+
+	return hash.Sum32(), nil
+}
+
+func writeZipCentralHeader(w io.Writer, e ZipEntry, offset int64, crc uint32) error {
+	modTime, modDate := zipDosTime(e.Modified)
+
+	size := uint32(e.Size)
+	localOffset := uint32(offset)
+	version := uint16(zipVersion)
+
+	var extra []byte
+	if e.centralExtraSize(offset) > 0 {
+		version = zipVersion64
+
+		var values = []interface{}{uint16(zipExtra64Id), uint16(e.centralExtraSize(offset) - 4)}
+		if e.zip64() {
+			size = zipMax32
+			values = append(values, uint64(e.Size), uint64(e.Size))
+		}
+		if offset >= zipMax32 {
+			localOffset = zipMax32
+			values = append(values, uint64(offset))
+		}
+		extra = zipFields(values...)
+	}
+
+	header := zipFields(uint32(zipCentralHeaderSignature), version, version, uint16(zipFlags),
+		uint16(0), modTime, modDate, crc, size, size,
+		uint16(len(e.Name)), uint16(len(extra)), uint16(0), uint16(0), uint16(0), uint32(0),
+		localOffset)
+	_, err := w.Write(append(append(header, e.Name...), extra...))
+	return err
+}
+
+func writeZipEnd(w io.Writer, count int, cdOffset int64, cdSize int64) error {
+	records := uint16(count)
+	size := uint32(cdSize)
+	offset := uint32(cdOffset)
+
+	if zipEnd64(count, cdOffset, cdSize) {
+		end64Offset := cdOffset + cdSize
+		end64 := zipFields(uint32(zipEnd64Signature), uint64(zipEnd64Size-12),
+			uint16(zipVersion64), uint16(zipVersion64), uint32(0), uint32(0),
+			uint64(count), uint64(count), uint64(cdSize), uint64(cdOffset),
+			uint32(zipEnd64LocatorSignature), uint32(0), uint64(end64Offset), uint32(1))
+		if _, err := w.Write(end64); err != nil {
+			return err
+		}
+
+		records = zipMax16
+		size = zipMax32
+		offset = zipMax32
+	}
+
+	_, err := w.Write(zipFields(uint32(zipEndSignature), uint16(0), uint16(0),
+		records, records, size, offset, uint16(0)))
+	return err
+}
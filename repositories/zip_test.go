@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// write the archive in memory, checking its size and reading it back
+func checkZip(t *testing.T, name string, entries []ZipEntry, content map[string]string) {
+	var b bytes.Buffer
+	if err := WriteZip(&b, entries); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	if size := ZipSize(entries); size != int64(b.Len()) {
+		t.Errorf("%s: size announced %d, written %d", name, size, b.Len())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if len(zr.File) != len(entries) {
+		t.Fatalf("%s: %d entries read, %d written", name, len(zr.File), len(entries))
+	}
+
+	for _, f := range zr.File {
+		want, ok := content[f.Name]
+		if !ok {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		// the CRC is checked at the end of the data
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("%s: entry %s read as %q, %v", name, f.Name, got, err)
+		}
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "track.mp3")
+	if err := ioutil.WriteFile(p, []byte("audio data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewZipFileEntry("Artist/Album/Tïtle.mp3", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	entries := []ZipEntry{
+		file,
+		NewZipDataEntry("empty", nil, modified),
+		NewZipDataEntry("playlist.m3u", []byte("#EXTM3U\n"), time.Time{}),
+	}
+	checkZip(t, "regular", entries, map[string]string{
+		"Artist/Album/Tïtle.mp3": "audio data",
+		"empty":                  "",
+		"playlist.m3u":           "#EXTM3U\n",
+	})
+
+	checkZip(t, "no entry", nil, nil)
+
+	// too many entries for the regular end record
+	var many = make([]ZipEntry, 0, zipMax16)
+	for i := 0; i < zipMax16; i++ {
+		many = append(many, NewZipDataEntry(fmt.Sprintf("%d", i), []byte{byte(i)}, modified))
+	}
+	checkZip(t, "zip64 count", many, map[string]string{
+		"0":     "\x00",
+		"65534": "\xfe",
+	})
+
+	// the file changed since its entry was made
+	if err := ioutil.WriteFile(p, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteZip(&countingWriter{}, []ZipEntry{file}); err == nil {
+		t.Errorf("changed file archived")
+	}
+}
+
+func TestWriteZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams more than 4 GB")
+	}
+
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// sparse, nothing written on disk
+	p := path.Join(dir, "large")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(zipMax32 + 1); err != nil {
+		f.Close()
+		t.Skip("sparse files not supported: " + err.Error())
+	}
+	f.Close()
+
+	large, err := NewZipFileEntry("large", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entry after the large one has an offset beyond 4 GB
+	entries := []ZipEntry{
+		NewZipDataEntry("before", []byte("data"), time.Time{}),
+		large,
+		NewZipDataEntry("after", []byte("data"), time.Time{}),
+	}
+
+	var w countingWriter
+	if err := WriteZip(&w, entries); err != nil {
+		t.Fatal(err)
+	}
+	if size := ZipSize(entries); size != w.n {
+		t.Errorf("size announced %d, written %d", size, w.n)
+	}
+}